@@ -2,21 +2,68 @@ package cmd
 
 import (
 	"context"
+	"strings"
 
 	"github.com/lance6716/plan-change-capturer/pkg/pcc"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
 	rootCmd = &cobra.Command{
 		Use:   "plan-change-capturer",
 		Short: "A tool used to capture plan changes among different versions of TiDB",
+		PersistentPreRunE: func(c *cobra.Command, _ []string) error {
+			return loadConfigFile(c)
+		},
 		RunE: func(c *cobra.Command, _ []string) error {
 			return pcc.Run(c.Context(), config)
 		},
 		SilenceErrors: true,
 		SilenceUsage:  true,
 	}
+	captureCmd = &cobra.Command{
+		Use:   "capture",
+		Short: "Capture statements, structure and stats from the old version cluster into the work directory",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Capture(c.Context(), config)
+		},
+	}
+	syncCmd = &cobra.Command{
+		Use:   "sync",
+		Short: "Synchronize structure, stats and bindings in the work directory to the new version cluster",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Sync(c.Context(), config)
+		},
+	}
+	compareCmd = &cobra.Command{
+		Use:   "compare",
+		Short: "Compare plans of the captured statements on the new version cluster",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Compare(c.Context(), config)
+		},
+	}
+	replayCmd = &cobra.Command{
+		Use:   "replay",
+		Short: "Synchronize, compare and report from a captured work directory, without accessing the old version cluster",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Replay(c.Context(), config)
+		},
+	}
+	reportCmd = &cobra.Command{
+		Use:   "report",
+		Short: "Render the report from the work directory",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Report(c.Context(), config)
+		},
+	}
+	cleanupCmd = &cobra.Command{
+		Use:   "cleanup",
+		Short: "Drop the objects and restore the global variables changed by pcc on the new version cluster",
+		RunE: func(c *cobra.Command, _ []string) error {
+			return pcc.Cleanup(c.Context(), config)
+		},
+	}
 )
 
 // Execute executes the root command.
@@ -24,12 +71,83 @@ func Execute(ctx context.Context) error {
 	return rootCmd.ExecuteContext(ctx)
 }
 
-var config = &pcc.Config{}
+var (
+	config     = &pcc.Config{}
+	configFile string
+)
+
+// loadConfigFile loads the task file specified by --config into config. The
+// flags set in command line have higher priority than the task file.
+func loadConfigFile(c *cobra.Command) error {
+	if configFile == "" {
+		return nil
+	}
+
+	changed := make(map[string]string)
+	changedSlices := make(map[pflag.SliceValue][]string)
+	c.Flags().Visit(func(f *pflag.Flag) {
+		// the String() of slice flags can't be parsed back by Set
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			changedSlices[v] = v.GetSlice()
+			return
+		}
+		// the String() of map flags is wrapped by brackets, and Set merges the
+		// pairs into the map loaded from the task file
+		if f.Value.Type() == "stringToString" {
+			changed[f.Name] = strings.Trim(f.Value.String(), "[]")
+			return
+		}
+		changed[f.Name] = f.Value.String()
+	})
+	if err := config.LoadFile(configFile); err != nil {
+		return err
+	}
+	for name, value := range changed {
+		if err := c.Flags().Set(name, value); err != nil {
+			return err
+		}
+	}
+	for v, value := range changedSlices {
+		if err := v.Replace(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func init() {
 	cobra.OnInitialize()
 
+	rootCmd.AddCommand(captureCmd, syncCmd, compareCmd, replayCmd, reportCmd, cleanupCmd)
+
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "task file in TOML or YAML format, flags override values in it")
+
+	rootCmd.PersistentFlags().StringVar(&config.TaskName, "task-name", "", "task name, default is task-{current time}")
+	rootCmd.PersistentFlags().StringVar(&config.Description, "description", "", "task description")
 	rootCmd.PersistentFlags().StringVarP(&config.WorkDir, "work-dir", "w", "", "work directory")
+	rootCmd.PersistentFlags().StringVar(&config.Log.Filename, "log-file", "", "log file, default is stdout")
+	rootCmd.PersistentFlags().DurationVar(&config.GlobalTimeLimit, "global-time-limit", 0, "stop comparing new statements after the duration and report the partial result. 0 means unlimited")
+	rootCmd.PersistentFlags().DurationVar(&config.PerSQLTimeLimit, "per-sql-time-limit", 0, "time limit of synchronizing and EXPLAIN for one statement. 0 means unlimited")
+	rootCmd.Flags().DurationVar(&config.Interval, "interval", 0, "capture and compare every interval until interrupted, like 10m. 0 means run once")
+
+	rootCmd.PersistentFlags().StringVar(&config.SyncBackend, "sync-backend", "schema", "how to transfer structure and stats to the new version cluster, one of schema and plan-replayer")
+	rootCmd.PersistentFlags().StringSliceVar(&config.SyncVariables, "sync-variables", nil, "global variables to synchronize from the old version cluster, supports wildcards * and ?. Default is the variables affecting the optimizer")
+	rootCmd.PersistentFlags().BoolVar(&config.CompareWithoutBinding, "compare-without-binding", false, "also compare the plans without the binding for the SQLs whose plan is from a binding")
+	rootCmd.PersistentFlags().BoolVar(&config.LogicalTiFlash, "logical-tiflash", false, "use hypothetical TiFlash replicas in the new version cluster instead of creating real ones")
+	rootCmd.PersistentFlags().StringVar((*string)(&config.OnSchemaMismatch), "on-schema-mismatch", "report", "what to do when a table already exists in the new version cluster with a different structure, one of report, adopt and recreate")
+	rootCmd.PersistentFlags().StringToStringVar((*map[string]string)(&config.SchemaMapping), "schema-mapping", nil, "rename the synchronized databases in the new version cluster, like app=pcc_task1_app or *=pcc_task1_*")
+	rootCmd.PersistentFlags().StringVar(&config.Source.Type, "source-type", "stmt-summary", "where to read the statements, one of stmt-summary, slow-log and workload")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Source.SlowLogFiles, "slow-log-files", nil, "slow log files to read when source-type is slow-log, supports glob patterns. Default is reading INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Source.WorkloadFiles, "workload-files", nil, ".sql or JSON lines files to read when source-type is workload, supports glob patterns")
+	rootCmd.PersistentFlags().StringVar((*string)(&config.Source.StmtSummaryWindow), "stmt-summary-window", "history", "statement summary tables to read, one of history, current and both")
+	rootCmd.PersistentFlags().DurationVar(&config.Source.PageInterval, "page-interval", 0, "range of SUMMARY_BEGIN_TIME read by one query, default is 1h")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Filter.IncludeSchemas, "include-schemas", nil, "only capture statements whose current database matches the patterns, supports wildcards * and ?")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Filter.ExcludeSchemas, "exclude-schemas", nil, "skip statements whose current database matches the patterns, supports wildcards * and ?")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Filter.IncludeTables, "include-tables", nil, "only capture statements accessing any table matching the schema.table patterns, supports wildcards * and ?")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Filter.ExcludeTables, "exclude-tables", nil, "skip statements accessing any table matching the schema.table patterns, supports wildcards * and ?")
+	rootCmd.PersistentFlags().StringSliceVar(&config.Filter.StmtTypes, "stmt-types", nil, "STMT_TYPE to capture, default is Select, Insert, Replace, Update, Delete")
+	rootCmd.PersistentFlags().IntVar(&config.Filter.MinExecCount, "min-exec-count", 2, "minimum EXEC_COUNT of the statements to capture")
+	rootCmd.PersistentFlags().DurationVar(&config.Filter.MinAvgLatency, "min-avg-latency", 0, "minimum AVG_LATENCY of the statements to capture")
 
 	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Host, "old-host", "", "old version host")
 	rootCmd.PersistentFlags().IntVar(&config.OldVersion.Port, "old-port", 4000, "old version port")
@@ -37,10 +155,20 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Password, "old-password", "", "old version password")
 	rootCmd.PersistentFlags().IntVar(&config.OldVersion.StatusPort, "old-status-port", 10080, "old version status port")
 	rootCmd.PersistentFlags().IntVar(&config.OldVersion.MaxConn, "old-max-conn", 4, "old version max connections")
+	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Security.SSLMode, "old-ssl-mode", "", "old version TLS mode, one of disabled, required, verify-ca and verify-identity. Default is verify-identity if any TLS file is set, otherwise disabled")
+	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Security.CA, "old-ssl-ca", "", "old version CA file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Security.Cert, "old-ssl-cert", "", "old version client certificate file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Security.Key, "old-ssl-key", "", "old version client private key file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.OldVersion.Security.ServerName, "old-ssl-server-name", "", "old version server name to verify, default is the host")
 
 	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Host, "new-host", "", "new version host")
 	rootCmd.PersistentFlags().IntVar(&config.NewVersion.Port, "new-port", 4001, "new version port")
 	rootCmd.PersistentFlags().StringVar(&config.NewVersion.User, "new-user", "root", "new version user")
 	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Password, "new-password", "", "new version password")
 	rootCmd.PersistentFlags().IntVar(&config.NewVersion.MaxConn, "new-max-conn", 128, "new version max connections")
+	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Security.SSLMode, "new-ssl-mode", "", "new version TLS mode, one of disabled, required, verify-ca and verify-identity. Default is verify-identity if any TLS file is set, otherwise disabled")
+	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Security.CA, "new-ssl-ca", "", "new version CA file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Security.Cert, "new-ssl-cert", "", "new version client certificate file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Security.Key, "new-ssl-key", "", "new version client private key file in PEM format, enables TLS")
+	rootCmd.PersistentFlags().StringVar(&config.NewVersion.Security.ServerName, "new-ssl-server-name", "", "new version server name to verify, default is the host")
 }
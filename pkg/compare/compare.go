@@ -16,6 +16,11 @@ const (
 	Unknown        = "unknown"
 	Same    Result = "same"
 	Diff           = "different"
+	// Timeout means the comparison exceeds the per-SQL time limit.
+	Timeout Result = "timeout"
+	// Unsupported means the statement can't be compared, see
+	// source.StmtSummary.Unsupported.
+	Unsupported Result = "unsupported"
 )
 
 // CmpPlan compares two plan trees and returns the result. Please note that the
@@ -36,6 +41,40 @@ func CmpPlan(sql string, a, b *plan.Op) (Result, error) {
 	return cmpPlan(a, b), nil
 }
 
+// BindingUsage tells whether the binding of a statement still matters on the
+// new version cluster.
+type BindingUsage string
+
+const (
+	// BindingNeeded means the plan changes without the binding, and the plan
+	// with the binding is the same as the old plan.
+	BindingNeeded BindingUsage = "still needed"
+	// BindingEffective means the plan changes without the binding, but the plan
+	// with the binding is different from the old plan.
+	BindingEffective BindingUsage = "still effective"
+	// BindingRedundant means the optimizer generates the same plan without the
+	// binding.
+	BindingRedundant BindingUsage = "redundant"
+)
+
+// CmpBinding compares the plans with and without the binding on the new
+// version cluster. `result` is the result of comparing the old plan and the
+// plan with the binding. The input is modified in-place like CmpPlan.
+func CmpBinding(sql string, result Result, withBinding, withoutBinding *plan.Op) (BindingUsage, error) {
+	r, err := CmpPlan(sql, withBinding, withoutBinding)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	switch {
+	case r == Same:
+		return BindingRedundant, nil
+	case result == Same:
+		return BindingNeeded, nil
+	default:
+		return BindingEffective, nil
+	}
+}
+
 func cmpPlan(a, b *plan.Op) Result {
 	if a.Type != b.Type {
 		return Diff
@@ -23,6 +23,30 @@ func TestCmpPlan(t *testing.T) {
 	require.Equal(t, Same, result)
 }
 
+func TestCmpBinding(t *testing.T) {
+	const sql = "SELECT * FROM t WHERE a = 1"
+	tableScan := func() *plan.Op {
+		op := plan.NewOp4Test("TableReader_5")
+		op.Children = []*plan.Op{plan.NewOp4Test("TableFullScan_4")}
+		return op
+	}
+	indexScan := func() *plan.Op {
+		op := plan.NewOp4Test("IndexReader_6")
+		op.Children = []*plan.Op{plan.NewOp4Test("IndexRangeScan_5")}
+		return op
+	}
+
+	usage, err := CmpBinding(sql, Same, indexScan(), indexScan())
+	require.NoError(t, err)
+	require.Equal(t, BindingRedundant, usage)
+	usage, err = CmpBinding(sql, Same, indexScan(), tableScan())
+	require.NoError(t, err)
+	require.Equal(t, BindingNeeded, usage)
+	usage, err = CmpBinding(sql, Diff, indexScan(), tableScan())
+	require.NoError(t, err)
+	require.Equal(t, BindingEffective, usage)
+}
+
 func TestRemoveProj(t *testing.T) {
 	// test projection at root
 	input := plan.NewOp4Test("Projection_4")
@@ -13,6 +13,12 @@ type PlanCmpResult struct {
 	OldVersionInfo *source.StmtSummary
 	OldPlan        string
 	NewDiffPlan    string
+
+	// BindingUsage is set when the plan is also compared without the binding of
+	// the statement, see CmpBinding. NoBindingPlan is the plan without the
+	// binding if it's different from the plan with the binding.
+	BindingUsage  BindingUsage
+	NoBindingPlan string
 }
 
 // TODO(lance6716): support execution comparison.
@@ -0,0 +1,90 @@
+package filemgr
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/pingcap/errors"
+)
+
+const journalFile = "cleanup-journal.jsonl"
+
+// journalMu serializes the appending to the journal file, the Manager of the
+// same work directory may be created multiple times in one process.
+var journalMu sync.Mutex
+
+// AppendJournal appends the change made on the new version cluster to the
+// journal file, one JSON object per line. It implements schema.Journal.
+func (m *Manager) AppendJournal(entry *schema.JournalEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	content = append(content, '\n')
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	if err = os.MkdirAll(m.workDir, 0776); err != nil {
+		return errors.Trace(err)
+	}
+	f, err := os.OpenFile(filepath.Join(m.workDir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
+
+// ReadJournal reads the entries written by AppendJournal in the order of
+// appending. It returns nil if the journal file does not exist. An incomplete
+// last line, which is caused by a crash during appending, is ignored.
+func (m *Manager) ReadJournal() ([]*schema.JournalEntry, error) {
+	f, err := os.Open(filepath.Join(m.workDir, journalFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	var ret []*schema.JournalEntry
+	reader := bufio.NewReader(f)
+	for {
+		line, err2 := reader.ReadBytes('\n')
+		if err2 == io.EOF {
+			// the line without '\n' is not completely written
+			break
+		}
+		if err2 != nil {
+			return nil, errors.Trace(err2)
+		}
+		entry := &schema.JournalEntry{}
+		if err2 = json.Unmarshal(line, entry); err2 != nil {
+			return nil, errors.Annotatef(err2, "unmarshal journal line %s", line)
+		}
+		ret = append(ret, entry)
+	}
+	return ret, nil
+}
+
+// RemoveJournal removes the journal file after all the changes are undone.
+func (m *Manager) RemoveJournal() error {
+	err := os.Remove(filepath.Join(m.workDir, journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
@@ -2,8 +2,10 @@ package filemgr
 
 import (
 	"encoding/json"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/lance6716/plan-change-capturer/pkg/compare"
@@ -17,10 +19,20 @@ const (
 	stmtSummaryExt     = ".json"
 	schemaSubDir       = "schema"
 	schemaFilename     = "create.sql"
+	tableOptionsFile   = "table-options.json"
+	placementPolicyDir = "placement-policy"
+	placementPolicyExt = ".sql"
 	tableStatsDir      = "table-stats"
 	tableStatsFilename = "table-stats.json"
+	planReplayerDir    = "plan-replayer"
+	planReplayerExt    = ".zip"
 	resultSubDir       = "result"
 	resultExt          = ".json"
+	captureMetaFile    = "capture-meta.json"
+	compareMetaFile    = "compare-meta.json"
+	readCheckpointFile = "stmt-summary-checkpoint.json"
+	variableDiffsFile  = "variable-diffs.json"
+	reportFilename     = "report.html"
 )
 
 // Manager owns a folder and organizes the files needed by the plan change
@@ -32,12 +44,26 @@ const (
 // INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY tables.
 //
 // - schemaSubDir: stores the statements to be restored. So the captured SQL can
-// run.
+// run. The TableOptions of a table is stored beside its statement.
+//
+// - placementPolicyDir: stores the placement policies used by the databases
+// and tables.
 //
 // - tableStatsDir: stores the table stats to be restored. So the captured SQL
 // can run and generate the same plan.
 //
+// - planReplayerDir: stores the PLAN REPLAYER dump of each statement when it's
+// used to synchronize instead of schemaSubDir and tableStatsDir.
+//
 // - resultSubDir: stores the comparison results.
+//
+// Besides the subfolders, captureMetaFile and compareMetaFile store the
+// metadata of the capture and compare stages, readCheckpointFile stores the
+// progress of an unfinished statement summary reading, variableDiffsFile stores
+// the global variables synchronized to the new version cluster, journalFile
+// records the changes made on the new version cluster for cleanup, and
+// reportFilename is the rendered report. So each stage can run in a different
+// process, and an interrupted run can be resumed from the files.
 type Manager struct {
 	workDir string
 }
@@ -49,13 +75,7 @@ func NewManager(workDir string) *Manager {
 
 // WriteStmtSummary writes the statement summary to the file.
 func (m *Manager) WriteStmtSummary(s *source.StmtSummary) error {
-	dir := filepath.Join(
-		m.workDir,
-		stmtSummaryDir,
-		s.SQLDigest,
-		s.PlanDigest,
-		util.EscapePath(s.Instance),
-	)
+	dir := filepath.Join(m.workDir, stmtSummaryDir, s.SQLDigest)
 	if err := os.MkdirAll(dir, 0776); err != nil {
 		return errors.Trace(err)
 	}
@@ -63,9 +83,7 @@ func (m *Manager) WriteStmtSummary(s *source.StmtSummary) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return errors.Trace(util.AtomicWrite(filepath.Join(
-		dir, s.SummaryBeginTime.Format(time.RFC3339)+stmtSummaryExt,
-	), content))
+	return errors.Trace(util.AtomicWrite(filepath.Join(dir, s.PlanDigest+stmtSummaryExt), content))
 }
 
 // WriteDatabaseStructure writes the CREATE DATABASE statement to the file.
@@ -86,6 +104,38 @@ func (m *Manager) WriteTableStructure(db, table, createTable string) error {
 	return errors.Trace(util.AtomicWrite(filepath.Join(dir, schemaFilename), []byte(createTable)))
 }
 
+// TableOptions is the options of a table that are not in its CREATE TABLE
+// statement.
+type TableOptions struct {
+	// TiFlashReplica is the TiFlash replica count, 0 means no TiFlash replica.
+	TiFlashReplica int
+	// Cached is true if the table is cached by ALTER TABLE ... CACHE.
+	Cached bool
+}
+
+// WriteTableOptions writes the TableOptions of the table to the file.
+func (m *Manager) WriteTableOptions(db, table string, opts *TableOptions) error {
+	dir := filepath.Join(m.workDir, schemaSubDir, db, table)
+	if err := os.MkdirAll(dir, 0776); err != nil {
+		return errors.Trace(err)
+	}
+	content, err := json.Marshal(opts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(util.AtomicWrite(filepath.Join(dir, tableOptionsFile), content))
+}
+
+// WritePlacementPolicy writes the CREATE PLACEMENT POLICY statement to the
+// file.
+func (m *Manager) WritePlacementPolicy(policy, createPolicy string) error {
+	dir := filepath.Join(m.workDir, placementPolicyDir)
+	if err := os.MkdirAll(dir, 0776); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(util.AtomicWrite(filepath.Join(dir, policy+placementPolicyExt), []byte(createPolicy)))
+}
+
 // WriteTableStats writes the table stats to the file.
 func (m *Manager) WriteTableStats(db, table string, json string) error {
 	dir := filepath.Join(m.workDir, tableStatsDir, db, table)
@@ -95,16 +145,32 @@ func (m *Manager) WriteTableStats(db, table string, json string) error {
 	return errors.Trace(util.AtomicWrite(filepath.Join(dir, tableStatsFilename), []byte(json)))
 }
 
+// WritePlanReplayer writes the PLAN REPLAYER dump of the statement summary to
+// the file.
+func (m *Manager) WritePlanReplayer(s *source.StmtSummary, zip []byte) error {
+	dir := filepath.Join(m.workDir, planReplayerDir, s.SQLDigest)
+	if err := os.MkdirAll(dir, 0776); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(util.AtomicWrite(m.GetPlanReplayerPath(s), zip))
+}
+
+// GetPlanReplayerPath returns the path of the PLAN REPLAYER dump file.
+func (m *Manager) GetPlanReplayerPath(s *source.StmtSummary) string {
+	return filepath.Join(m.workDir, planReplayerDir, s.SQLDigest, s.PlanDigest+planReplayerExt)
+}
+
+// HasPlanReplayer checks if the PLAN REPLAYER dump of the statement summary is
+// written by WritePlanReplayer.
+func (m *Manager) HasPlanReplayer(s *source.StmtSummary) bool {
+	_, err := os.Stat(m.GetPlanReplayerPath(s))
+	return err == nil
+}
+
 // WriteResult writes the comparison result to the file.
 func (m *Manager) WriteResult(r *compare.PlanCmpResult) error {
 	s := r.OldVersionInfo
-	dir := filepath.Join(
-		m.workDir,
-		resultSubDir,
-		s.SQLDigest,
-		s.PlanDigest,
-		util.EscapePath(s.Instance),
-	)
+	dir := filepath.Join(m.workDir, resultSubDir, s.SQLDigest)
 	if err := os.MkdirAll(dir, 0776); err != nil {
 		return errors.Trace(err)
 	}
@@ -112,11 +178,7 @@ func (m *Manager) WriteResult(r *compare.PlanCmpResult) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return errors.Trace(util.AtomicWrite(filepath.Join(
-		dir,
-		s.SummaryBeginTime.Format(time.RFC3339)+resultExt),
-		content,
-	))
+	return errors.Trace(util.AtomicWrite(filepath.Join(dir, s.PlanDigest+resultExt), content))
 }
 
 // GetTableStatsPath returns the path of the table stats file.
@@ -124,4 +186,218 @@ func (m *Manager) GetTableStatsPath(db, table string) string {
 	return filepath.Join(m.workDir, tableStatsDir, db, table, tableStatsFilename)
 }
 
-// TODO(lance6716): recover from previous run
+// GetReportPath returns the path of the rendered report.
+func (m *Manager) GetReportPath() string {
+	return filepath.Join(m.workDir, reportFilename)
+}
+
+// ReadStmtSummaries reads all statement summaries written by WriteStmtSummary.
+func (m *Manager) ReadStmtSummaries() ([]*source.StmtSummary, error) {
+	return readJSONFiles[source.StmtSummary](filepath.Join(m.workDir, stmtSummaryDir), stmtSummaryExt)
+}
+
+// ReadResults reads all comparison results written by WriteResult.
+func (m *Manager) ReadResults() ([]*compare.PlanCmpResult, error) {
+	return readJSONFiles[compare.PlanCmpResult](filepath.Join(m.workDir, resultSubDir), resultExt)
+}
+
+// readJSONFiles unmarshals all files with the given extension under root. The
+// temporary files of util.AtomicWrite are skipped. It returns empty slice if root
+// does not exist.
+func readJSONFiles[T any](root, ext string) ([]*T, error) {
+	ret := make([]*T, 0, 128)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ext) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		v := new(T)
+		if err = json.Unmarshal(content, v); err != nil {
+			return errors.Annotatef(err, "unmarshal file %s", path)
+		}
+		ret = append(ret, v)
+		return nil
+	})
+	return ret, errors.Trace(err)
+}
+
+// ReadDatabaseStructure reads the CREATE DATABASE statement written by
+// WriteDatabaseStructure.
+func (m *Manager) ReadDatabaseStructure(db string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(m.workDir, schemaSubDir, db, schemaFilename))
+	return string(content), errors.Trace(err)
+}
+
+// ReadTableStructure reads the CREATE TABLE / VIEW statement written by
+// WriteTableStructure.
+func (m *Manager) ReadTableStructure(db, table string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(m.workDir, schemaSubDir, db, table, schemaFilename))
+	return string(content), errors.Trace(err)
+}
+
+// ReadTableOptions reads the TableOptions written by WriteTableOptions. It
+// returns the default TableOptions if the file does not exist.
+func (m *Manager) ReadTableOptions(db, table string) (*TableOptions, error) {
+	ret := &TableOptions{}
+	content, err := os.ReadFile(filepath.Join(m.workDir, schemaSubDir, db, table, tableOptionsFile))
+	if os.IsNotExist(err) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return ret, errors.Annotatef(json.Unmarshal(content, ret), "unmarshal table options of %s.%s", db, table)
+}
+
+// ReadPlacementPolicy reads the CREATE PLACEMENT POLICY statement written by
+// WritePlacementPolicy.
+func (m *Manager) ReadPlacementPolicy(policy string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(m.workDir, placementPolicyDir, policy+placementPolicyExt))
+	return string(content), errors.Trace(err)
+}
+
+// CaptureMeta is the metadata of capturing statements from the old version
+// cluster.
+type CaptureMeta struct {
+	StartTime   time.Time
+	EndTime     time.Time
+	Endpoint    string
+	User        string
+	ClusterInfo *util.ClusterInfo
+	// Interval is the polling interval of continuous capture, 0 means capture
+	// once.
+	Interval time.Duration
+	// Rounds is the number of finished capture rounds.
+	Rounds int
+	// DataSource is the description of where the statements are read from.
+	DataSource string
+	// Coverage is the capture coverage of every instance and time window.
+	Coverage []*source.WindowCoverage
+	// FilteringRules is the description of the rules to select the statements.
+	FilteringRules []string
+	// GlobalVariables is the global variables of the old version cluster to
+	// synchronize, keyed by the variable name.
+	GlobalVariables map[string]string
+	// ResourceGroups is the CREATE RESOURCE GROUP statements of the old version
+	// cluster, keyed by the resource group name.
+	ResourceGroups map[string]string
+}
+
+// CompareMeta is the metadata of comparing plans on the new version cluster.
+type CompareMeta struct {
+	StartTime   time.Time
+	EndTime     time.Time
+	ClusterInfo *util.ClusterInfo
+	// GlobalTimeLimit and PerSQLTimeLimit are the time limits used in
+	// comparing, 0 means unlimited.
+	GlobalTimeLimit time.Duration
+	PerSQLTimeLimit time.Duration
+	// TimedOut is true if the comparing is stopped by GlobalTimeLimit.
+	TimedOut bool
+	// VariableDiffs is the global variables different between the clusters.
+	VariableDiffs []*VariableDiff
+}
+
+// VariableDiff is a global variable whose value is different between the old
+// and new version clusters.
+type VariableDiff struct {
+	Name string
+	Old  string
+	// New is the value of the new version cluster before synchronizing.
+	New string
+	// Synced is true if the value of the old version cluster is set to the new
+	// version cluster.
+	Synced bool
+}
+
+// WriteCaptureMeta writes the metadata of the capture stage to the file.
+func (m *Manager) WriteCaptureMeta(meta *CaptureMeta) error {
+	return errors.Trace(m.writeJSON(captureMetaFile, meta))
+}
+
+// ReadCaptureMeta reads the metadata written by WriteCaptureMeta.
+func (m *Manager) ReadCaptureMeta() (*CaptureMeta, error) {
+	ret := &CaptureMeta{}
+	return ret, errors.Trace(m.readJSON(captureMetaFile, ret))
+}
+
+// WriteCompareMeta writes the metadata of the compare stage to the file.
+func (m *Manager) WriteCompareMeta(meta *CompareMeta) error {
+	return errors.Trace(m.writeJSON(compareMetaFile, meta))
+}
+
+// ReadCompareMeta reads the metadata written by WriteCompareMeta.
+func (m *Manager) ReadCompareMeta() (*CompareMeta, error) {
+	ret := &CompareMeta{}
+	return ret, errors.Trace(m.readJSON(compareMetaFile, ret))
+}
+
+// WriteVariableDiffs writes the global variables synchronized to the new
+// version cluster.
+func (m *Manager) WriteVariableDiffs(diffs []*VariableDiff) error {
+	return errors.Trace(m.writeJSON(variableDiffsFile, diffs))
+}
+
+// ReadVariableDiffs reads the file written by WriteVariableDiffs. It returns nil
+// if the file does not exist.
+func (m *Manager) ReadVariableDiffs() ([]*VariableDiff, error) {
+	var ret []*VariableDiff
+	err := m.readJSON(variableDiffsFile, &ret)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	return ret, errors.Trace(err)
+}
+
+// WriteReadCheckpoint writes the progress of reading statement summary.
+func (m *Manager) WriteReadCheckpoint(cp *source.ReadCheckpoint) error {
+	return errors.Trace(m.writeJSON(readCheckpointFile, cp))
+}
+
+// ReadReadCheckpoint reads the checkpoint written by WriteReadCheckpoint. It
+// returns nil if the checkpoint does not exist.
+func (m *Manager) ReadReadCheckpoint() (*source.ReadCheckpoint, error) {
+	ret := &source.ReadCheckpoint{}
+	err := m.readJSON(readCheckpointFile, ret)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil
+	}
+	return ret, errors.Trace(err)
+}
+
+// RemoveReadCheckpoint removes the checkpoint after the reading is finished.
+func (m *Manager) RemoveReadCheckpoint() error {
+	err := os.Remove(filepath.Join(m.workDir, readCheckpointFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+func (m *Manager) writeJSON(filename string, v any) error {
+	if err := os.MkdirAll(m.workDir, 0776); err != nil {
+		return errors.Trace(err)
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(util.AtomicWrite(filepath.Join(m.workDir, filename), content))
+}
+
+func (m *Manager) readJSON(filename string, v any) error {
+	content, err := os.ReadFile(filepath.Join(m.workDir, filename))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotatef(json.Unmarshal(content, v), "unmarshal file %s", filename)
+}
@@ -0,0 +1,155 @@
+package filemgr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWrite(t *testing.T) {
+	m := NewManager(t.TempDir())
+
+	summaries, err := m.ReadStmtSummaries()
+	require.NoError(t, err)
+	require.Empty(t, summaries)
+	err = m.Source().Read(context.Background(), make(chan *source.StmtSummary))
+	require.ErrorContains(t, err, "please run capture first")
+
+	beginTime := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	s1 := &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT * FROM t",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+		SQLDigest:            "sql1",
+		PlanDigest:           "plan1",
+		ExecCount:            2,
+		Instances:            []string{"127.0.0.1:10080", "127.0.0.1:10081"},
+		SummaryBeginTimes:    []time.Time{beginTime},
+	}
+	s2 := &source.StmtSummary{
+		SQLDigest:         "sql1",
+		PlanDigest:        "plan2",
+		Instances:         []string{"127.0.0.1:10081"},
+		SummaryBeginTimes: []time.Time{beginTime},
+	}
+	require.NoError(t, m.WriteStmtSummary(s1))
+	require.NoError(t, m.WriteStmtSummary(s2))
+	summaries, err = m.ReadStmtSummaries()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []*source.StmtSummary{s1, s2}, summaries)
+	ch := make(chan *source.StmtSummary, 2)
+	require.NoError(t, m.Source().Read(context.Background(), ch))
+	close(ch)
+	summaries = summaries[:0]
+	for s := range ch {
+		summaries = append(summaries, s)
+	}
+	require.ElementsMatch(t, []*source.StmtSummary{s1, s2}, summaries)
+
+	r := &compare.PlanCmpResult{Result: compare.Same, OldVersionInfo: s1, OldPlan: "TableReader_5"}
+	require.NoError(t, m.WriteResult(r))
+	results, err := m.ReadResults()
+	require.NoError(t, err)
+	require.Equal(t, []*compare.PlanCmpResult{r}, results)
+
+	require.NoError(t, m.WriteDatabaseStructure("test", "CREATE DATABASE `test`"))
+	require.NoError(t, m.WriteTableStructure("test", "t", "CREATE TABLE `t` (`a` int)"))
+	got, err := m.ReadDatabaseStructure("test")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE DATABASE `test`", got)
+	got, err = m.ReadTableStructure("test", "t")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE `t` (`a` int)", got)
+
+	opts, err := m.ReadTableOptions("test", "t")
+	require.NoError(t, err)
+	require.Equal(t, &TableOptions{}, opts)
+	opts = &TableOptions{TiFlashReplica: 2, Cached: true}
+	require.NoError(t, m.WriteTableOptions("test", "t", opts))
+	gotOpts, err := m.ReadTableOptions("test", "t")
+	require.NoError(t, err)
+	require.Equal(t, opts, gotOpts)
+
+	require.NoError(t, m.WritePlacementPolicy("p1", "CREATE PLACEMENT POLICY `p1` FOLLOWERS=4"))
+	got, err = m.ReadPlacementPolicy("p1")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE PLACEMENT POLICY `p1` FOLLOWERS=4", got)
+
+	require.False(t, m.HasPlanReplayer(s1))
+	require.NoError(t, m.WritePlanReplayer(s1, []byte("zip")))
+	require.True(t, m.HasPlanReplayer(s1))
+	require.False(t, m.HasPlanReplayer(s2))
+	content, err := os.ReadFile(m.GetPlanReplayerPath(s1))
+	require.NoError(t, err)
+	require.Equal(t, "zip", string(content))
+
+	_, err = m.ReadCaptureMeta()
+	require.Error(t, err)
+	meta := &CaptureMeta{StartTime: beginTime, EndTime: beginTime.Add(time.Hour), Endpoint: "127.0.0.1:4000", User: "root",
+		ResourceGroups: map[string]string{"rg1": "CREATE RESOURCE GROUP `rg1` RU_PER_SEC=100"}}
+	require.NoError(t, m.WriteCaptureMeta(meta))
+	gotMeta, err := m.ReadCaptureMeta()
+	require.NoError(t, err)
+	require.Equal(t, meta, gotMeta)
+
+	diffs, err := m.ReadVariableDiffs()
+	require.NoError(t, err)
+	require.Nil(t, diffs)
+	diffs = []*VariableDiff{{Name: "tidb_cost_model_version", Old: "1", New: "2", Synced: true}}
+	require.NoError(t, m.WriteVariableDiffs(diffs))
+	gotDiffs, err := m.ReadVariableDiffs()
+	require.NoError(t, err)
+	require.Equal(t, diffs, gotDiffs)
+
+	cp, err := m.ReadReadCheckpoint()
+	require.NoError(t, err)
+	require.Nil(t, cp)
+	cp = &source.ReadCheckpoint{Table: "CLUSTER_STATEMENTS_SUMMARY_HISTORY", NextPageBegin: 7200, Summaries: []*source.StmtSummary{s1}}
+	require.NoError(t, m.WriteReadCheckpoint(cp))
+	gotCp, err := m.ReadReadCheckpoint()
+	require.NoError(t, err)
+	require.Equal(t, cp, gotCp)
+	require.NoError(t, m.RemoveReadCheckpoint())
+	require.NoError(t, m.RemoveReadCheckpoint())
+	gotCp, err = m.ReadReadCheckpoint()
+	require.NoError(t, err)
+	require.Nil(t, gotCp)
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	entries, err := m.ReadJournal()
+	require.NoError(t, err)
+	require.Nil(t, entries)
+
+	e1 := &schema.JournalEntry{Kind: schema.JournalDatabase, Name: "test"}
+	e2 := &schema.JournalEntry{Kind: schema.JournalGlobalVariable, Name: "tidb_enable_auto_analyze", Value: "ON"}
+	require.NoError(t, m.AppendJournal(e1))
+	// another Manager of the same work directory appends to the same journal
+	require.NoError(t, NewManager(dir).AppendJournal(e2))
+
+	// the incomplete line written by a crash is ignored
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"kind":"tab`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	entries, err = m.ReadJournal()
+	require.NoError(t, err)
+	require.Equal(t, []*schema.JournalEntry{e1, e2}, entries)
+
+	require.NoError(t, m.RemoveJournal())
+	require.NoError(t, m.RemoveJournal())
+	entries, err = m.ReadJournal()
+	require.NoError(t, err)
+	require.Nil(t, entries)
+}
@@ -0,0 +1,40 @@
+package filemgr
+
+import (
+	"context"
+
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/pingcap/errors"
+)
+
+// Source returns the source.Source of the statement summaries written by
+// WriteStmtSummary, their bindings are already attached.
+func (m *Manager) Source() source.Source {
+	return workDirSource{m: m}
+}
+
+type workDirSource struct {
+	m *Manager
+}
+
+func (s workDirSource) Read(ctx context.Context, outCh chan<- *source.StmtSummary) error {
+	summaries, err := s.m.ReadStmtSummaries()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(summaries) == 0 {
+		return errors.New("no statement summary found in the work directory, please run capture first")
+	}
+	for _, summary := range summaries {
+		select {
+		case outCh <- summary:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (s workDirSource) Describe() string {
+	return "work directory " + s.m.workDir
+}
@@ -0,0 +1,95 @@
+package pcc
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/errno"
+	"go.uber.org/zap"
+)
+
+// Cleanup drops the objects created by pcc on the new version cluster and
+// restores the global variables changed by pcc, according to the journal in
+// the work directory. It can be run repeatedly, and the journal is removed
+// after everything is undone.
+func Cleanup(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, false, true, cleanup)
+}
+
+func cleanup(ctx context.Context, cfg *Config) error {
+	util.Logger.Info("start to cleanup", zap.Any("config", cfg))
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	entries, err := mgr.ReadJournal()
+	if err != nil {
+		return errors.Annotate(err, "failed to read cleanup journal")
+	}
+	if len(entries) == 0 {
+		util.Logger.Info("nothing to cleanup")
+		return nil
+	}
+
+	// connectNewDB is not used because it changes the new version cluster
+	newDB, err := openNewDB(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer newDB.Close()
+	failed, err := undoJournal(ctx, newDB, entries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if failed > 0 {
+		return errors.Errorf("failed to cleanup %d of %d changes, please check the log and run cleanup again", failed, len(entries))
+	}
+	util.Logger.Info("cleanup finished", zap.Int("changes", len(entries)))
+	return errors.Trace(mgr.RemoveJournal())
+}
+
+// undoJournal undoes the entries in the reverse order, so an object is dropped
+// before the objects it depends on, and a global variable changed multiple
+// times is restored to the earliest value. The failure of an entry is logged
+// and counted, and the objects already dropped are not treated as failure. It
+// only returns error when ctx is done.
+func undoJournal(ctx context.Context, db *sql.DB, entries []*schema.JournalEntry) (int, error) {
+	failed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return failed, errors.Trace(err)
+		}
+		stmt := entries[i].UndoSQL()
+		if stmt == "" {
+			util.Logger.Warn("invalid cleanup journal entry", zap.Any("entry", entries[i]))
+			failed++
+			continue
+		}
+		_, err := db.ExecContext(ctx, stmt)
+		if err != nil && !isNotExistError(err) {
+			util.Logger.Warn("failed to cleanup",
+				zap.String("sql", stmt),
+				zap.Error(err))
+			failed++
+			continue
+		}
+		util.Logger.Info("cleaned up", zap.String("sql", stmt), zap.Error(err))
+	}
+	return failed, nil
+}
+
+// isNotExistError returns true if the error means the database or table is
+// already dropped.
+func isNotExistError(err error) bool {
+	merr, ok := errors.Cause(err).(*mysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch merr.Number {
+	case errno.ErrBadDB, errno.ErrBadTable, errno.ErrNoSuchTable:
+		return true
+	}
+	return false
+}
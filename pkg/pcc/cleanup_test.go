@@ -0,0 +1,92 @@
+package pcc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/pingcap/tidb/pkg/errno"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoJournal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	entries := []*schema.JournalEntry{
+		{Kind: schema.JournalGlobalVariable, Name: "tidb_enable_auto_analyze", Value: "ON"},
+		{Kind: schema.JournalDatabase, Name: "test"},
+		{Kind: schema.JournalTable, Database: "test", Name: "t"},
+		{Kind: schema.JournalView, Database: "test", Name: "v"},
+		{Kind: schema.JournalStats, Database: "test", Name: "t"},
+		{Kind: schema.JournalGlobalVariable, Name: "tidb_enable_auto_analyze", Value: "OFF"},
+	}
+	// undo in the reverse order
+	mock.ExpectExec(regexp.QuoteMeta("SET GLOBAL tidb_enable_auto_analyze = 'OFF'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// the table is already dropped by an earlier cleanup
+	mock.ExpectExec(regexp.QuoteMeta("DROP STATS `test`.`t`")).
+		WillReturnError(&mysql.MySQLError{Number: errno.ErrNoSuchTable, Message: "Table 'test.t' doesn't exist"})
+	mock.ExpectExec(regexp.QuoteMeta("DROP VIEW IF EXISTS `test`.`v`")).
+		WillReturnError(errors.New("connection refused"))
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS `test`.`t`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DROP DATABASE IF EXISTS `test`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("SET GLOBAL tidb_enable_auto_analyze = 'ON'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	failed, err := undoJournal(context.Background(), db, entries)
+	require.NoError(t, err)
+	require.Equal(t, 1, failed)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = undoJournal(ctx, db, entries)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCleanupPreExistingTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	mgr := filemgr.NewManager(t.TempDir())
+	syncer := schema.NewSyncer(db, schema.MismatchReport, nil, mgr)
+
+	// t already exists on the new version cluster, and t2 is created by pcc
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t` (`a` int)")).WillReturnError(errors.New("table exists"))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `test`.`t`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("t", "CREATE TABLE `t` (\n  `a` int\n)"))
+	require.NoError(t, syncer.CreateTable(ctx, "test", "t", "CREATE TABLE `t` (`a` int)"))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t2` (`a` int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.CreateTable(ctx, "test", "t2", "CREATE TABLE `t2` (`a` int)"))
+	for _, table := range []string{"t", "t2"} {
+		statsPath := filepath.Join(t.TempDir(), table+".json")
+		require.NoError(t, os.WriteFile(statsPath, []byte(`{"database_name":"test"}`), 0o644))
+		mock.ExpectExec(regexp.QuoteMeta("LOAD STATS '" + statsPath + "'")).WillReturnResult(sqlmock.NewResult(0, 0))
+		require.NoError(t, syncer.LoadStats(ctx, "test", table, statsPath))
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// cleanup doesn't touch the stats of t
+	entries, err := mgr.ReadJournal()
+	require.NoError(t, err)
+	mock.ExpectExec(regexp.QuoteMeta("DROP STATS `test`.`t2`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS `test`.`t2`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	failed, err := undoJournal(ctx, db, entries)
+	require.NoError(t, err)
+	require.Equal(t, 0, failed)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,42 +1,335 @@
 package pcc
 
 import (
+	"bytes"
+	"crypto/tls"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"gopkg.in/yaml.v3"
 )
 
-// Config is a static struct for pcc's configuration.
+// Config is a static struct for pcc's configuration. It can be loaded from a
+// task file by LoadFile, the keys of the task file are the same as the struct
+// tags.
 type Config struct {
-	TaskName    string
-	Description string
+	TaskName    string `toml:"task-name" yaml:"task-name"`
+	Description string `toml:"description" yaml:"description"`
+
+	OldVersion TiDB   `toml:"old-version" yaml:"old-version"`
+	NewVersion TiDB   `toml:"new-version" yaml:"new-version"`
+	WorkDir    string `toml:"work-dir" yaml:"work-dir"`
+	Log        Log    `toml:"log" yaml:"log"`
+
+	// Source is how to read the statements from the old version cluster.
+	Source Source `toml:"source" yaml:"source"`
+	// Filter selects the statements to capture from the old version cluster.
+	Filter source.Filter `toml:"filter" yaml:"filter"`
+	// SyncBackend is how to transfer the environment of the statements from the
+	// old version cluster to the new version cluster, one of "schema" and
+	// "plan-replayer". Default is "schema".
+	SyncBackend string `toml:"sync-backend" yaml:"sync-backend"`
+	// SyncVariables is the wildcard patterns of the global variables to
+	// synchronize from the old version cluster to the new version cluster.
+	// Default is the variables affecting the optimizer, see
+	// defaultSyncVariables. Empty list means not synchronizing.
+	SyncVariables []string `toml:"sync-variables" yaml:"sync-variables"`
+	// CompareWithoutBinding also gets the plan without the binding for the
+	// statements whose plan is from a binding, to tell whether the binding is
+	// still needed in the new version cluster.
+	CompareWithoutBinding bool `toml:"compare-without-binding" yaml:"compare-without-binding"`
+	// LogicalTiFlash lets the optimizer of the new version cluster consider the
+	// TiFlash replicas of the old version cluster by hypothetical replicas, so
+	// the new version cluster doesn't need TiFlash stores. It only works with
+	// the "schema" SyncBackend.
+	LogicalTiFlash bool `toml:"logical-tiflash" yaml:"logical-tiflash"`
+	// OnSchemaMismatch is what to do when a table already exists in the new
+	// version cluster with a different structure, one of "report", "adopt" and
+	// "recreate". Default is "report", which fails the statements using the
+	// table with the differences. It only works with the "schema" SyncBackend.
+	OnSchemaMismatch schema.MismatchPolicy `toml:"on-schema-mismatch" yaml:"on-schema-mismatch"`
+	// SchemaMapping renames the databases of the old version cluster when they
+	// are synchronized to the new version cluster, like "app" -> "pcc_task1_app"
+	// or "*" -> "pcc_task1_*", so different tasks and the existing data of the
+	// new version cluster don't collide. It only works with the "schema"
+	// SyncBackend.
+	SchemaMapping schema.Mapping `toml:"schema-mapping" yaml:"schema-mapping"`
 
-	OldVersion TiDB
-	NewVersion TiDB
-	WorkDir    string
-	Log        Log
+	// Interval enables continuous capture mode when it's positive. pcc polls the
+	// statement summary of old version cluster every Interval, compares the
+	// digests not seen before and regenerates the report after each round.
+	Interval time.Duration `toml:"interval" yaml:"interval"`
+	// GlobalTimeLimit stops dispatching new statements to compare after the
+	// duration since start, and the report is marked as timed out. 0 means
+	// unlimited.
+	GlobalTimeLimit time.Duration `toml:"global-time-limit" yaml:"global-time-limit"`
+	// PerSQLTimeLimit limits the duration of synchronizing and EXPLAIN for one
+	// statement. 0 means unlimited.
+	PerSQLTimeLimit time.Duration `toml:"per-sql-time-limit" yaml:"per-sql-time-limit"`
 }
 
 type TiDB struct {
-	Host       string
-	Port       int
-	User       string
-	Password   string
-	StatusPort int
-	MaxConn    int
+	Host       string `toml:"host" yaml:"host"`
+	Port       int    `toml:"port" yaml:"port"`
+	User       string `toml:"user" yaml:"user"`
+	Password   string `toml:"password" yaml:"password"`
+	StatusPort int    `toml:"status-port" yaml:"status-port"`
+	MaxConn    int    `toml:"max-conn" yaml:"max-conn"`
+
+	Security Security `toml:"security" yaml:"security"`
+}
+
+// Security is the TLS configuration used to connect to both the SQL port and
+// the status port. When SSLMode is empty, TLS is enabled if any of CA, Cert and
+// Key is set.
+type Security struct {
+	// SSLMode is one of the util.TLSMode* constants.
+	SSLMode    string `toml:"ssl-mode" yaml:"ssl-mode"`
+	CA         string `toml:"ca" yaml:"ca"`
+	Cert       string `toml:"cert" yaml:"cert"`
+	Key        string `toml:"key" yaml:"key"`
+	ServerName string `toml:"server-name" yaml:"server-name"`
+}
+
+// tlsConfig returns nil if TLS is not enabled.
+func (s *Security) tlsConfig() (*tls.Config, error) {
+	return util.NewTLSConfig(s.SSLMode, s.CA, s.Cert, s.Key, s.ServerName)
+}
+
+// Source types.
+const (
+	SourceStmtSummary = "stmt-summary"
+	SourceSlowLog     = "slow-log"
+	SourceWorkload    = "workload"
+)
+
+type Source struct {
+	// Type is one of "stmt-summary", "slow-log" and "workload". Default is
+	// "stmt-summary".
+	Type string `toml:"type" yaml:"type"`
+	// SlowLogFiles is the slow log files to read when Type is "slow-log", glob
+	// patterns are supported. If it's empty,
+	// INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY is read.
+	SlowLogFiles []string `toml:"slow-log-files" yaml:"slow-log-files"`
+	// WorkloadFiles is the ".sql" or JSON lines files to read when Type is
+	// "workload", glob patterns are supported. The statements have no recorded
+	// plan, so they are EXPLAINed on the old version cluster.
+	WorkloadFiles []string `toml:"workload-files" yaml:"workload-files"`
+	// StmtSummaryWindow is one of "history", "current" and "both". Default is
+	// "history".
+	StmtSummaryWindow source.StmtSummaryWindow `toml:"stmt-summary-window" yaml:"stmt-summary-window"`
+	// PageInterval is the range of SUMMARY_BEGIN_TIME read by one query. Default
+	// is 1h.
+	PageInterval time.Duration `toml:"page-interval" yaml:"page-interval"`
 }
 
+// Sync backends.
+const (
+	// SyncBackendSchema dumps the CREATE statements and the stats of every table
+	// and creates them on the new version cluster.
+	SyncBackendSchema = "schema"
+	// SyncBackendPlanReplayer runs PLAN REPLAYER DUMP EXPLAIN for every
+	// statement and PLAN REPLAYER LOAD on the new version cluster, which also
+	// transfers the session variables and bindings.
+	SyncBackendPlanReplayer = "plan-replayer"
+)
+
 type Log struct {
-	Filename string
+	Filename string `toml:"filename" yaml:"filename"`
 }
 
-const defaultWorkSubDir = "plan-change-capturer"
+const (
+	defaultWorkSubDir   = "plan-change-capturer"
+	defaultPageInterval = time.Hour
+)
+
+var defaultSyncVariables = []string{
+	"tidb_opt_*",
+	"tidb_enable_index_merge",
+	"tidb_partition_prune_mode",
+	"tidb_cost_model_version",
+}
 
 func (c *Config) ensureDefaults() {
 	if c.TaskName == "" {
 		c.TaskName = "task-" + time.Now().Format(time.RFC3339)
 	}
+	if c.Source.Type == "" {
+		c.Source.Type = SourceStmtSummary
+	}
+	if c.SyncBackend == "" {
+		c.SyncBackend = SyncBackendSchema
+	}
+	if c.OnSchemaMismatch == "" {
+		c.OnSchemaMismatch = schema.MismatchReport
+	}
+	if c.SyncVariables == nil {
+		c.SyncVariables = defaultSyncVariables
+	}
+	if c.Source.StmtSummaryWindow == "" {
+		c.Source.StmtSummaryWindow = source.WindowHistory
+	}
+	if c.Source.PageInterval == 0 {
+		c.Source.PageInterval = defaultPageInterval
+	}
 	if c.WorkDir == "" {
 		c.WorkDir = filepath.Join(os.TempDir(), defaultWorkSubDir)
 	}
 }
+
+// LoadFile loads the task file into c. The format is decided by the file
+// extension, ".toml" for TOML and ".yaml" or ".yml" for YAML. Fields not present
+// in the file are left unchanged, so caller can set default values before
+// calling it. Unknown keys in the file are reported as error.
+func (c *Config) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Annotatef(err, "read task file %s", path)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".toml":
+		meta, err2 := toml.Decode(string(content), c)
+		if err2 != nil {
+			return errors.Annotatef(err2, "parse TOML task file %s", path)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			return errors.Errorf("unknown keys in task file %s: %s", path, strings.Join(keys, ", "))
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err2 := decoder.Decode(c)
+		if err2 != nil && err2 != io.EOF {
+			return errors.Annotatef(err2, "parse YAML task file %s", path)
+		}
+	default:
+		return errors.Errorf(
+			"unsupported extension %q of task file %s, should be one of .toml, .yaml, .yml",
+			ext, path,
+		)
+	}
+	return nil
+}
+
+// Validate checks if the configuration can be used to run pcc. The returned
+// error names the invalid key in the task file.
+func (c *Config) Validate() error {
+	return c.validate(true, true)
+}
+
+// validate is like Validate, but only checks the endpoints needed by the
+// caller.
+func (c *Config) validate(needOld, needNew bool) error {
+	if c.Interval < 0 {
+		return errors.Errorf("interval should not be negative, got %s", c.Interval)
+	}
+	if c.GlobalTimeLimit < 0 {
+		return errors.Errorf("global-time-limit should not be negative, got %s", c.GlobalTimeLimit)
+	}
+	if c.PerSQLTimeLimit < 0 {
+		return errors.Errorf("per-sql-time-limit should not be negative, got %s", c.PerSQLTimeLimit)
+	}
+	if needOld {
+		if err := c.OldVersion.validate("old-version"); err != nil {
+			return err
+		}
+		switch c.Source.Type {
+		case "", SourceStmtSummary, SourceSlowLog:
+		case SourceWorkload:
+			if len(c.Source.WorkloadFiles) == 0 {
+				return errors.Errorf("source.workload-files is required when source.type is %q", SourceWorkload)
+			}
+		default:
+			return errors.Errorf(
+				"source.type should be one of %q, %q and %q, got %q",
+				SourceStmtSummary, SourceSlowLog, SourceWorkload, c.Source.Type,
+			)
+		}
+		if len(c.Source.StmtSummaryWindow.Tables()) == 0 {
+			return errors.Errorf(
+				"source.stmt-summary-window should be one of %q, %q and %q, got %q",
+				source.WindowHistory, source.WindowCurrent, source.WindowBoth, c.Source.StmtSummaryWindow,
+			)
+		}
+		if c.Source.PageInterval < 0 {
+			return errors.Errorf("source.page-interval should not be negative, got %s", c.Source.PageInterval)
+		}
+		if err := c.Filter.Validate("filter"); err != nil {
+			return err
+		}
+		switch c.SyncBackend {
+		case "", SyncBackendSchema, SyncBackendPlanReplayer:
+		default:
+			return errors.Errorf(
+				"sync-backend should be one of %q and %q, got %q",
+				SyncBackendSchema, SyncBackendPlanReplayer, c.SyncBackend,
+			)
+		}
+		switch c.OnSchemaMismatch {
+		case "", schema.MismatchReport, schema.MismatchAdopt, schema.MismatchRecreate:
+		default:
+			return errors.Errorf(
+				"on-schema-mismatch should be one of %q, %q and %q, got %q",
+				schema.MismatchReport, schema.MismatchAdopt, schema.MismatchRecreate, c.OnSchemaMismatch,
+			)
+		}
+		if err := c.SchemaMapping.Validate("schema-mapping"); err != nil {
+			return err
+		}
+		if len(c.SchemaMapping) > 0 && c.SyncBackend == SyncBackendPlanReplayer {
+			return errors.Errorf("schema-mapping is not supported by sync-backend %q", SyncBackendPlanReplayer)
+		}
+	}
+	if needNew {
+		if err := c.NewVersion.validate("new-version"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TiDB) validate(prefix string) error {
+	if t.Host == "" {
+		return errors.Errorf("%s.host is required", prefix)
+	}
+	if t.Port <= 0 || t.Port > 65535 {
+		return errors.Errorf("%s.port should be in range [1, 65535], got %d", prefix, t.Port)
+	}
+	if t.StatusPort != 0 && (t.StatusPort < 0 || t.StatusPort > 65535) {
+		return errors.Errorf("%s.status-port should be in range [1, 65535], got %d", prefix, t.StatusPort)
+	}
+	if t.MaxConn <= 0 {
+		return errors.Errorf("%s.max-conn should be positive, got %d", prefix, t.MaxConn)
+	}
+	if (t.Security.Cert == "") != (t.Security.Key == "") {
+		return errors.Errorf("%s.security.cert and %s.security.key should be both set or both empty", prefix, prefix)
+	}
+	switch t.Security.SSLMode {
+	case "", util.TLSModeRequired, util.TLSModeVerifyCA, util.TLSModeVerifyIdentity:
+	case util.TLSModeDisabled:
+		if t.Security.CA != "" || t.Security.Cert != "" {
+			return errors.Errorf("%s.security.ca, cert and key should be empty when ssl-mode is %q", prefix, util.TLSModeDisabled)
+		}
+	default:
+		return errors.Errorf(
+			"%s.security.ssl-mode should be one of %q, %q, %q and %q, got %q",
+			prefix, util.TLSModeDisabled, util.TLSModeRequired, util.TLSModeVerifyCA, util.TLSModeVerifyIdentity,
+			t.Security.SSLMode,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package pcc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	tomlPath := filepath.Join(dir, "task.toml")
+	err := os.WriteFile(tomlPath, []byte(`
+task-name = "upgrade-rehearsal"
+description = "v7.5 -> v8.5"
+work-dir = "/tmp/pcc"
+interval = "10m"
+
+[old-version]
+host = "10.0.0.1"
+status-port = 10081
+
+[new-version]
+host = "10.0.0.2"
+port = 4002
+
+[log]
+filename = "/tmp/pcc.log"
+
+[filter]
+include-tables = ["app.*"]
+min-avg-latency = "100ms"
+summary-begin-time-from = 2024-01-01T00:00:00Z
+`), 0666)
+	require.NoError(t, err)
+
+	cfg := &Config{OldVersion: TiDB{Port: 4000, User: "root", MaxConn: 4}}
+	require.NoError(t, cfg.LoadFile(tomlPath))
+	require.Equal(t, "upgrade-rehearsal", cfg.TaskName)
+	require.Equal(t, "v7.5 -> v8.5", cfg.Description)
+	require.Equal(t, "/tmp/pcc", cfg.WorkDir)
+	require.Equal(t, "/tmp/pcc.log", cfg.Log.Filename)
+	require.Equal(t, 10*time.Minute, cfg.Interval)
+	require.Equal(t, &source.Filter{
+		IncludeTables:        []string{"app.*"},
+		MinAvgLatency:        100 * time.Millisecond,
+		SummaryBeginTimeFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, &cfg.Filter)
+	// values not in the file are kept
+	require.Equal(t, TiDB{Host: "10.0.0.1", Port: 4000, User: "root", StatusPort: 10081, MaxConn: 4}, cfg.OldVersion)
+	require.Equal(t, TiDB{Host: "10.0.0.2", Port: 4002}, cfg.NewVersion)
+
+	yamlPath := filepath.Join(dir, "task.yaml")
+	err = os.WriteFile(yamlPath, []byte(`
+task-name: upgrade-rehearsal
+interval: 1h
+old-version:
+  host: 10.0.0.1
+  max-conn: 8
+`), 0666)
+	require.NoError(t, err)
+
+	cfg = &Config{}
+	require.NoError(t, cfg.LoadFile(yamlPath))
+	require.Equal(t, "upgrade-rehearsal", cfg.TaskName)
+	require.Equal(t, TiDB{Host: "10.0.0.1", MaxConn: 8}, cfg.OldVersion)
+	require.Equal(t, time.Hour, cfg.Interval)
+
+	err = os.WriteFile(tomlPath, []byte(`
+[old-version]
+hots = "10.0.0.1"
+`), 0666)
+	require.NoError(t, err)
+	err = (&Config{}).LoadFile(tomlPath)
+	require.ErrorContains(t, err, "unknown keys in task file")
+	require.ErrorContains(t, err, "old-version.hots")
+
+	err = os.WriteFile(yamlPath, []byte(`
+old-version:
+  hots: 10.0.0.1
+`), 0666)
+	require.NoError(t, err)
+	err = (&Config{}).LoadFile(yamlPath)
+	require.ErrorContains(t, err, "field hots not found")
+
+	err = (&Config{}).LoadFile(filepath.Join(dir, "task.json"))
+	require.ErrorContains(t, err, "read task file")
+	jsonPath := filepath.Join(dir, "task.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte("{}"), 0666))
+	err = (&Config{}).LoadFile(jsonPath)
+	require.ErrorContains(t, err, "unsupported extension")
+}
+
+func TestValidate(t *testing.T) {
+	valid := TiDB{Host: "127.0.0.1", Port: 4000, StatusPort: 10080, MaxConn: 4}
+	cfg := &Config{OldVersion: valid, NewVersion: valid}
+	require.NoError(t, cfg.Validate())
+
+	cfg.OldVersion.Host = ""
+	require.ErrorContains(t, cfg.Validate(), "old-version.host is required")
+
+	cfg.OldVersion = valid
+	cfg.NewVersion.Port = 70000
+	require.ErrorContains(t, cfg.Validate(), "new-version.port should be in range [1, 65535], got 70000")
+
+	cfg.NewVersion = valid
+	cfg.NewVersion.MaxConn = 0
+	require.ErrorContains(t, cfg.Validate(), "new-version.max-conn should be positive, got 0")
+
+	cfg.NewVersion = valid
+	cfg.NewVersion.Security.SSLMode = "preferred"
+	require.ErrorContains(t, cfg.Validate(), `new-version.security.ssl-mode should be one of "disabled", "required", "verify-ca" and "verify-identity", got "preferred"`)
+	cfg.NewVersion.Security = Security{SSLMode: util.TLSModeDisabled, CA: "ca.pem"}
+	require.ErrorContains(t, cfg.Validate(), `new-version.security.ca, cert and key should be empty when ssl-mode is "disabled"`)
+	cfg.NewVersion.Security = Security{SSLMode: util.TLSModeRequired}
+	require.NoError(t, cfg.Validate())
+
+	cfg.NewVersion = valid
+	cfg.Interval = -time.Minute
+	require.ErrorContains(t, cfg.Validate(), "interval should not be negative, got -1m0s")
+
+	cfg.Interval = 0
+	cfg.Source.Type = "binlog"
+	require.ErrorContains(t, cfg.Validate(), `source.type should be one of "stmt-summary", "slow-log" and "workload", got "binlog"`)
+	cfg.Source.Type = SourceSlowLog
+	require.NoError(t, cfg.Validate())
+	cfg.Source.Type = SourceWorkload
+	require.ErrorContains(t, cfg.Validate(), "source.workload-files is required")
+	cfg.Source.WorkloadFiles = []string{"queries.sql"}
+	require.NoError(t, cfg.Validate())
+
+	cfg.SyncBackend = "dumpling"
+	require.ErrorContains(t, cfg.Validate(), `sync-backend should be one of "schema" and "plan-replayer", got "dumpling"`)
+	cfg.SyncBackend = SyncBackendPlanReplayer
+	require.NoError(t, cfg.Validate())
+
+	cfg.OnSchemaMismatch = "ignore"
+	require.ErrorContains(t, cfg.Validate(), `on-schema-mismatch should be one of "report", "adopt" and "recreate", got "ignore"`)
+	cfg.OnSchemaMismatch = schema.MismatchRecreate
+	require.NoError(t, cfg.Validate())
+
+	cfg.SchemaMapping = schema.Mapping{"*": "pcc"}
+	require.ErrorContains(t, cfg.Validate(), `schema-mapping of "*" should contain exactly one "*", got "pcc"`)
+	cfg.SchemaMapping = schema.Mapping{"*": "pcc_*"}
+	require.ErrorContains(t, cfg.Validate(), `schema-mapping is not supported by sync-backend "plan-replayer"`)
+	cfg.SyncBackend = SyncBackendSchema
+	require.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,107 @@
+package pcc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// deadline returns the time when GlobalTimeLimit is exceeded for a run started
+// at `start`. It returns zero time if GlobalTimeLimit is not set.
+func (c *Config) deadline(start time.Time) time.Time {
+	if c.GlobalTimeLimit <= 0 {
+		return time.Time{}
+	}
+	return start.Add(c.GlobalTimeLimit)
+}
+
+// limitDispatch forwards the StmtSummary from inCh to outCh until the deadline.
+// After the deadline, it sets timedOut and drains inCh without forwarding, so
+// the upstream can still persist the StmtSummary for a later run. Zero
+// deadline means no limit. It closes outCh when it stops forwarding.
+func limitDispatch(
+	ctx context.Context,
+	deadline time.Time,
+	inCh <-chan *source.StmtSummary,
+	outCh chan<- *source.StmtSummary,
+	timedOut *atomic.Bool,
+) error {
+	var timer <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		select {
+		case s, ok := <-inCh:
+			if !ok {
+				close(outCh)
+				return nil
+			}
+			select {
+			case outCh <- s:
+				continue
+			case <-timer:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-timer:
+		case <-ctx.Done():
+			return nil
+		}
+
+		// reach here only when the deadline is exceeded
+		util.Logger.Warn("global time limit exceeded, stop comparing new statements",
+			zap.Time("deadline", deadline))
+		timedOut.Store(true)
+		close(outCh)
+		for {
+			select {
+			case _, ok := <-inCh:
+				if !ok {
+					return nil
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// cmpWithTimeLimit calls fn with a context which is canceled after `limit`. If
+// the limit is exceeded before fn gets the comparison result, the result is
+// marked as compare.Timeout, which is final and will not be retried. Zero
+// limit means no limit.
+func cmpWithTimeLimit(
+	ctx context.Context,
+	limit time.Duration,
+	fn func(context.Context) *compare.PlanCmpResult,
+) *compare.PlanCmpResult {
+	if limit <= 0 {
+		return fn(ctx)
+	}
+
+	sqlCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	ret := fn(sqlCtx)
+	if ret.Result != compare.Unknown || ctx.Err() != nil {
+		return ret
+	}
+	if sqlCtx.Err() != context.DeadlineExceeded {
+		return ret
+	}
+	util.Logger.Warn("per-SQL time limit exceeded",
+		zap.String("sql_digest", ret.OldVersionInfo.SQLDigest),
+		zap.Duration("limit", limit))
+	ret.Result = compare.Timeout
+	ret.ErrMsg = fmt.Sprintf("exceeded per-SQL time limit %s", limit)
+	return ret
+}
@@ -0,0 +1,69 @@
+package pcc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestLimitDispatch(t *testing.T) {
+	ctx := context.Background()
+	inCh := make(chan *source.StmtSummary, 2)
+	outCh := make(chan *source.StmtSummary, 2)
+	timedOut := atomic.NewBool(false)
+	inCh <- &source.StmtSummary{SQLDigest: "a"}
+	inCh <- &source.StmtSummary{SQLDigest: "b"}
+	close(inCh)
+	require.NoError(t, limitDispatch(ctx, time.Time{}, inCh, outCh, timedOut))
+	require.False(t, timedOut.Load())
+	require.Len(t, outCh, 2)
+
+	// the deadline is exceeded, the remaining ones are drained
+	inCh = make(chan *source.StmtSummary)
+	outCh = make(chan *source.StmtSummary)
+	done := make(chan error, 1)
+	go func() {
+		done <- limitDispatch(ctx, time.Now().Add(100*time.Millisecond), inCh, outCh, timedOut)
+	}()
+	inCh <- &source.StmtSummary{SQLDigest: "a"}
+	require.Equal(t, "a", (<-outCh).SQLDigest)
+	_, ok := <-outCh
+	require.False(t, ok)
+	require.True(t, timedOut.Load())
+	inCh <- &source.StmtSummary{SQLDigest: "b"}
+	close(inCh)
+	require.NoError(t, <-done)
+}
+
+func TestCmpWithTimeLimit(t *testing.T) {
+	ctx := context.Background()
+	s := &source.StmtSummary{SQLDigest: "a"}
+	slow := func(ctx context.Context) *compare.PlanCmpResult {
+		<-ctx.Done()
+		return newPlanCmpResult(s)
+	}
+	ret := cmpWithTimeLimit(ctx, 10*time.Millisecond, slow)
+	require.Equal(t, compare.Timeout, ret.Result)
+	require.Equal(t, "exceeded per-SQL time limit 10ms", ret.ErrMsg)
+	require.True(t, isFinalResult(ret))
+
+	fast := func(context.Context) *compare.PlanCmpResult {
+		ret := newPlanCmpResult(s)
+		ret.Result = compare.Same
+		return ret
+	}
+	require.Equal(t, compare.Same, cmpWithTimeLimit(ctx, time.Minute, fast).Result)
+	require.Equal(t, compare.Same, cmpWithTimeLimit(ctx, 0, fast).Result)
+
+	// canceled by the caller is retryable
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	ret = cmpWithTimeLimit(canceledCtx, time.Minute, slow)
+	require.Equal(t, compare.Result(compare.Unknown), ret.Result)
+	require.Empty(t, ret.ErrMsg)
+}
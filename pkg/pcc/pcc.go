@@ -4,12 +4,13 @@ import (
 	"container/heap"
 	"context"
 	"database/sql"
+	"fmt"
 	"net"
 	"os"
-	"path/filepath"
 	"runtime"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lance6716/plan-change-capturer/pkg/compare"
@@ -26,15 +27,35 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-// Run is the main entry function of the pcc logic.
+// lowCoverageWarnThreshold is the capture coverage below which a warning is
+// logged.
+const lowCoverageWarnThreshold = 0.9
+
+// Run is the main entry function of the pcc logic. It captures from the old
+// version cluster, synchronizes to and compares on the new version cluster, and
+// renders the report in one pass.
 func Run(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, true, true, run)
+}
+
+// entry prepares the configuration and the logger before calling fn. needOld
+// and needNew decide which endpoints in the configuration must be valid.
+func entry(
+	ctx context.Context,
+	cfg *Config,
+	needOld, needNew bool,
+	fn func(context.Context, *Config) error,
+) error {
 	cfg.ensureDefaults()
+	if err := cfg.validate(needOld, needNew); err != nil {
+		return errors.Annotate(err, "invalid configuration")
+	}
 	if err := initLogger(&cfg.Log); err != nil {
 		util.Logger.Error("failed to initialize logger", zap.Error(err))
 	}
 	defer util.Logger.Sync()
 
-	return errors.Trace(run(ctx, cfg))
+	return errors.Trace(fn(ctx, cfg))
 }
 
 // initLogger initializes the logger for the process. The default logger writes
@@ -64,172 +85,419 @@ func run(ctx context.Context, cfg *Config) error {
 	}
 	defer oldDB.Close()
 	defer newDB.Close()
+	oldStatus, err := newStatusAPI(&cfg.OldVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	mgr := filemgr.NewManager(cfg.WorkDir)
-	syncer := schema.NewSyncer(newDB)
+	syncer := schema.NewSyncer(newDB, cfg.OnSchemaMismatch, cfg.SchemaMapping, mgr)
+	prev, err := loadPreviousRun(mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	src := newSource(cfg, oldDB, mgr)
+	oldVars, err := source.ReadGlobalVariables(ctx, oldDB, cfg.SyncVariables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	varDiffs, err := syncGlobalVariables(ctx, newDB, oldVars, cfg.SyncVariables, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resourceGroups, err := source.ReadResourceGroups(ctx, oldDB)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	syncResourceGroups(ctx, syncer, resourceGroups)
 
 	oldCfg := &cfg.OldVersion
-	maxConn := max(cfg.OldVersion.MaxConn, cfg.NewVersion.MaxConn)
-	eg, egCtx := errgroup.WithContext(ctx)
+	captureMeta := &filemgr.CaptureMeta{
+		StartTime:       start,
+		Endpoint:        net.JoinHostPort(oldCfg.Host, strconv.Itoa(oldCfg.Port)),
+		User:            oldCfg.User,
+		Interval:        cfg.Interval,
+		DataSource:      src.Describe(),
+		FilteringRules:  cfg.Filter.Rules(),
+		GlobalVariables: oldVars,
+		ResourceGroups:  resourceGroups,
+	}
+	compareMeta := &filemgr.CompareMeta{
+		StartTime:       start,
+		GlobalTimeLimit: cfg.GlobalTimeLimit,
+		PerSQLTimeLimit: cfg.PerSQLTimeLimit,
+		VariableDiffs:   varDiffs,
+	}
+	deadline := cfg.deadline(start)
 
-	var allBindings map[string]source.Binding
-	readBindingDone := make(chan struct{})
-	eg.Go(func() error {
-		var err2 error
-		allBindings, err2 = source.ReadBinding(egCtx, oldDB)
+	// when cfg.Interval is set, every round reads the statement summaries again
+	// and the finished ones are skipped like resuming from a previous run
+	for {
+		captureMeta.Rounds++
+		captureMeta.ClusterInfo = readClusterInfo(ctx, oldDB, "source")
+		captureMeta.Coverage = readCoverage(ctx, oldDB, cfg)
+		compareMeta.ClusterInfo = readClusterInfo(ctx, newDB, "target")
+
+		allResults, timedOut, err2 := runRound(ctx, cfg, deadline, src, oldDB, newDB, oldStatus, syncer, mgr, prev)
 		if err2 != nil {
 			return errors.Trace(err2)
 		}
-		close(readBindingDone)
-		return nil
-	})
+		compareMeta.TimedOut = timedOut
 
-	summFromSourceCh := make(chan *source.StmtSummary, maxConn)
-	eg.Go(func() error {
-		err2 := source.ReadStmtSummary(egCtx, oldDB, summFromSourceCh)
+		captureMeta.EndTime = time.Now()
+		compareMeta.EndTime = captureMeta.EndTime
+		if err = mgr.WriteCaptureMeta(captureMeta); err != nil {
+			return errors.Trace(err)
+		}
+		if err = mgr.WriteCompareMeta(compareMeta); err != nil {
+			return errors.Trace(err)
+		}
+		r, err2 := processResults(allResults, cfg, captureMeta, compareMeta)
 		if err2 != nil {
 			return errors.Trace(err2)
 		}
-		close(summFromSourceCh)
-		return nil
-	})
+		if err = report.Render(r, mgr.GetReportPath()); err != nil {
+			return errors.Trace(err)
+		}
 
-	summAfterPersistCh := make(chan *source.StmtSummary, maxConn)
-	eg.Go(func() error {
-		// wait binding is loaded
-		select {
-		case <-readBindingDone:
-		case <-egCtx.Done():
+		if cfg.Interval <= 0 || timedOut {
 			return nil
 		}
+		prev = newPreviousRun(allResults)
+		util.Logger.Info("round finished, wait for next round",
+			zap.Int("round", captureMeta.Rounds),
+			zap.Int("results", len(allResults)),
+			zap.Duration("interval", cfg.Interval))
+		wait := cfg.Interval
+		if !deadline.IsZero() {
+			// the next round will capture and then stop immediately, so the
+			// report is marked as timed out
+			wait = max(min(wait, time.Until(deadline)), 0)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runRound captures from src and compares the statements not finished in prev.
+// It returns the results of both, and whether the deadline is reached.
+func runRound(
+	ctx context.Context,
+	cfg *Config,
+	deadline time.Time,
+	src source.Source,
+	oldDB, newDB *sql.DB,
+	oldStatus *statusAPI,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+	prev *previousRun,
+) ([]*compare.PlanCmpResult, bool, error) {
+	maxConn := max(cfg.OldVersion.MaxConn, cfg.NewVersion.MaxConn)
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	capturedCh := make(chan *source.StmtSummary, maxConn)
+	eg.Go(func() error {
+		return captureStmtSummary(egCtx, src, mgr, capturedCh)
+	})
+	resumedCh := make(chan *source.StmtSummary, maxConn)
+	eg.Go(func() error {
+		return resumeStmtSummary(egCtx, prev, capturedCh, resumedCh)
+	})
+	summCh := make(chan *source.StmtSummary, maxConn)
+	timedOut := atomic.NewBool(false)
+	eg.Go(func() error {
+		return limitDispatch(egCtx, deadline, resumedCh, summCh, timedOut)
+	})
+
+	resultCh := make(chan *compare.PlanCmpResult, maxConn)
+	localTemps := &localTempTables{}
+	runWorkers(egCtx, eg, max(maxConn, runtime.NumCPU()), summCh,
+		func(s *source.StmtSummary) error {
+			r := cmpWithTimeLimit(ctx, cfg.PerSQLTimeLimit,
+				func(ctx context.Context) *compare.PlanCmpResult {
+					return cmpPlan(ctx, s, oldDB, newDB, syncer, mgr, oldStatus, localTemps, cfg)
+				})
+			select {
+			case resultCh <- r:
+				return nil
+			case <-egCtx.Done():
+				return errors.Trace(egCtx.Err())
+			}
+		},
+		func() { close(resultCh) },
+	)
+
+	allResults := prev.results()
+	eg.Go(func() error {
+		return collectResults(egCtx, mgr, resultCh, &allResults)
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	return allResults, timedOut.Load(), nil
+}
 
+// newSource creates the source.Source of the old version cluster selected by
+// cfg.Source.
+func newSource(cfg *Config, oldDB *sql.DB, mgr *filemgr.Manager) source.Source {
+	switch cfg.Source.Type {
+	case SourceSlowLog:
+		return source.NewSlowLogSource(oldDB, &source.SlowLogOptions{
+			Files:  cfg.Source.SlowLogFiles,
+			Filter: &cfg.Filter,
+		})
+	case SourceWorkload:
+		return source.NewWorkloadSource(oldDB, &source.WorkloadOptions{
+			Files:  cfg.Source.WorkloadFiles,
+			Filter: &cfg.Filter,
+		})
+	default:
+		return source.NewStmtSummarySource(oldDB, &source.ReadOptions{
+			Window:         cfg.Source.StmtSummaryWindow,
+			Filter:         &cfg.Filter,
+			PageInterval:   cfg.Source.PageInterval,
+			SaveCheckpoint: mgr.WriteReadCheckpoint,
+		}, mgr.ReadReadCheckpoint)
+	}
+}
+
+// captureStmtSummary reads the statement summaries from src. Every statement
+// summary is written to the work directory and emitted into outCh. It closes
+// outCh when all statement summaries are emitted.
+func captureStmtSummary(
+	ctx context.Context,
+	src source.Source,
+	mgr *filemgr.Manager,
+	outCh chan<- *source.StmtSummary,
+) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	summFromSourceCh := make(chan *source.StmtSummary, cap(outCh))
+	eg.Go(func() error {
+		return emitFromSource(egCtx, src, summFromSourceCh)
+	})
+
+	eg.Go(func() error {
 		for {
 			select {
 			case s, ok := <-summFromSourceCh:
 				if !ok {
-					close(summAfterPersistCh)
+					close(outCh)
 					return nil
 				}
-				if s.PlanInBinding {
-					b, ok2 := allBindings[s.BindingDigest]
-					if !ok2 {
-						util.Logger.Warn("binding not found", zap.String("sql_digest", s.SQLDigest))
-					} else {
-						s.Binding = b
-					}
-				}
 				err2 := mgr.WriteStmtSummary(s)
 				if err2 != nil {
 					return errors.Trace(err2)
 				}
-				summAfterPersistCh <- s
+				outCh <- s
 			case <-egCtx.Done():
 				return nil
 			}
 		}
 	})
 
-	// TODO(lance6716): aggregate the summaries with the same digest but different
-	// instance or capture window
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	// all statement summaries are written, the checkpoint is useless
+	return errors.Trace(mgr.RemoveReadCheckpoint())
+}
 
-	// TODO(lance6716): consumer should be fast enough to avoid blocking the
-	// connection and causes connection timeout
-	resultCh := make(chan *compare.PlanCmpResult, maxConn)
-	cmpWorkerConn := max(maxConn, runtime.NumCPU())
-	cmpWorkerCnt := atomic.NewInt64(int64(cmpWorkerConn))
-	for range cmpWorkerConn {
-		eg.Go(func() error {
-			// wait until binding is synced
-			select {
-			case <-readBindingDone:
-			case <-egCtx.Done():
+// emitFromSource reads src into outCh. It closes outCh when all statement
+// summaries are emitted.
+func emitFromSource(
+	ctx context.Context,
+	src source.Source,
+	outCh chan<- *source.StmtSummary,
+) error {
+	if err := src.Read(ctx, outCh); err != nil {
+		return errors.Trace(err)
+	}
+	close(outCh)
+	return nil
+}
+
+// collectResults appends the results received from resultCh to allResults
+// until resultCh is closed. The final results are also written to the work
+// directory.
+func collectResults(
+	ctx context.Context,
+	mgr *filemgr.Manager,
+	resultCh <-chan *compare.PlanCmpResult,
+	allResults *[]*compare.PlanCmpResult,
+) error {
+	for {
+		select {
+		case result, ok := <-resultCh:
+			if !ok {
 				return nil
 			}
+			*allResults = append(*allResults, result)
+			if !isFinalResult(result) {
+				continue
+			}
+			if err := mgr.WriteResult(result); err != nil {
+				return errors.Trace(err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
 
+// runWorkers starts `n` goroutines in `eg` to call fn on every StmtSummary
+// received from inCh. After inCh is closed and all workers exit, onDone is
+// called.
+func runWorkers(
+	ctx context.Context,
+	eg *errgroup.Group,
+	n int,
+	inCh <-chan *source.StmtSummary,
+	fn func(*source.StmtSummary) error,
+	onDone func(),
+) {
+	workerCnt := atomic.NewInt64(int64(n))
+	for range n {
+		eg.Go(func() error {
 			for {
 				select {
-				case s, ok := <-summAfterPersistCh:
+				case s, ok := <-inCh:
 					if !ok {
-						if cmpWorkerCnt.Dec() == 0 {
-							close(resultCh)
+						if workerCnt.Dec() == 0 {
+							onDone()
 						}
 						return nil
 					}
-					resultCh <- cmpPlan(ctx, s, oldDB, newDB, syncer, mgr, oldCfg)
-				case <-egCtx.Done():
+					if err := fn(s); err != nil {
+						return errors.Trace(err)
+					}
+				case <-ctx.Done():
 					return nil
 				}
 			}
 		})
 	}
+}
 
-	allResults := make([]*compare.PlanCmpResult, 0, 128)
-	eg.Go(func() error {
-		for {
-			select {
-			case result, ok := <-resultCh:
-				if !ok {
-					return nil
-				}
-				allResults = append(allResults, result)
-			case <-egCtx.Done():
-				return nil
-			}
-		}
-	})
-
-	metaResult := &metadataResult{
-		startTime: start,
-	}
-	sourceInfo, err := util.ReadClusterInfo(egCtx, oldDB)
-	if err != nil {
-		util.Logger.Error("read source cluster info failed", zap.Error(err))
-	} else {
-		metaResult.sourceInfo = sourceInfo
-	}
-	targetInfo, err := util.ReadClusterInfo(egCtx, newDB)
+// readClusterInfo reads the cluster information and logs the error if it
+// fails. `role` is used in the log.
+func readClusterInfo(ctx context.Context, db *sql.DB, role string) *util.ClusterInfo {
+	info, err := util.ReadClusterInfo(ctx, db)
 	if err != nil {
-		util.Logger.Error("read target cluster info failed", zap.Error(err))
-	} else {
-		metaResult.targetInfo = targetInfo
+		util.Logger.Error("read "+role+" cluster info failed", zap.Error(err))
+		return nil
 	}
+	return info
+}
 
-	if err = eg.Wait(); err != nil {
-		return errors.Trace(err)
+// readCoverage reads the capture coverage and logs the error if it fails. The
+// coverage is only available for the statement summary source.
+func readCoverage(ctx context.Context, db *sql.DB, cfg *Config) []*source.WindowCoverage {
+	if cfg.Source.Type != SourceStmtSummary {
+		return nil
 	}
-
-	r, err := processResults(allResults, cfg, mgr, metaResult)
+	coverage, err := source.ReadCoverage(ctx, db, cfg.Source.StmtSummaryWindow)
 	if err != nil {
-		return errors.Trace(err)
+		util.Logger.Warn("failed to read capture coverage", zap.Error(err))
+		return nil
 	}
-	return errors.Trace(report.Render(r, filepath.Join(cfg.WorkDir, "report.html")))
+	ratio := source.OverallCoverage(coverage)
+	if ratio < lowCoverageWarnThreshold {
+		util.Logger.Warn("many executions are evicted from statement summary and not captured, "+
+			"consider increasing tidb_stmt_summary_max_stmt_count",
+			zap.Float64("coverage", ratio))
+	}
+	return coverage
 }
 
 // prepareDBConnections creates sql.DB to the old and new version databases. For
 // the new version database, it also adjusts SQL variables for later usage.
 // Caller should close the returned DBs if it returns nil error.
 func prepareDBConnections(ctx context.Context, cfg *Config) (*sql.DB, *sql.DB, error) {
-	oldCfg := &cfg.OldVersion
-	oldDB, err := util.ConnectDB(oldCfg.Host, oldCfg.Port, oldCfg.User, oldCfg.Password)
+	oldDB, err := connectOldDB(cfg)
 	if err != nil {
 		return nil, nil, err
 	}
+	newDB, err := connectNewDB(ctx, cfg)
+	if err != nil {
+		oldDB.Close()
+		return nil, nil, err
+	}
+	return oldDB, newDB, nil
+}
+
+func connectOldDB(cfg *Config) (*sql.DB, error) {
+	oldCfg := &cfg.OldVersion
+	tlsConfig, err := oldCfg.Security.tlsConfig()
+	if err != nil {
+		return nil, errors.Annotate(err, "create TLS config for old version DB")
+	}
+	oldDB, err := util.ConnectDB("old", oldCfg.Host, oldCfg.Port, oldCfg.User, oldCfg.Password, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
 	oldDB.SetMaxOpenConns(oldCfg.MaxConn)
+	return oldDB, nil
+}
+
+func connectNewDB(ctx context.Context, cfg *Config) (*sql.DB, error) {
+	newDB, err := openNewDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err = disableAutoAnalyze(ctx, newDB, filemgr.NewManager(cfg.WorkDir)); err != nil {
+		newDB.Close()
+		return nil, err
+	}
+	return newDB, nil
+}
 
+// openNewDB creates sql.DB to the new version database without changing it.
+func openNewDB(cfg *Config) (*sql.DB, error) {
 	newCfg := &cfg.NewVersion
-	newDB, err := util.ConnectDB(newCfg.Host, newCfg.Port, newCfg.User, newCfg.Password)
+	tlsConfig, err := newCfg.Security.tlsConfig()
 	if err != nil {
-		oldDB.Close()
-		return nil, nil, err
+		return nil, errors.Annotate(err, "create TLS config for new version DB")
 	}
-	// disable auto analyze for new version DB, to avoid stats change during the process
-	_, err = newDB.ExecContext(ctx, "SET @@global.tidb_enable_auto_analyze='OFF'")
+	newDB, err := util.ConnectDB("new", newCfg.Host, newCfg.Port, newCfg.User, newCfg.Password, tlsConfig)
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "when disable auto analyze for new version DB")
+		return nil, err
 	}
 	newDB.SetMaxOpenConns(newCfg.MaxConn)
+	return newDB, nil
+}
 
-	return oldDB, newDB, nil
+// disableAutoAnalyze disables auto analyze for new version DB, to avoid stats
+// change during the process. The original value is recorded to the journal so
+// Cleanup can restore it. The session used by it is discarded, so it's not
+// reused before the global variables are synchronized, see syncGlobalVariables.
+func disableAutoAnalyze(ctx context.Context, newDB *sql.DB, mgr *filemgr.Manager) error {
+	conn, err := newDB.Conn(ctx)
+	if err != nil {
+		return errors.Annotate(err, "when connect to new version DB")
+	}
+	defer util.DiscardConn(conn)
+	var value string
+	err = conn.QueryRowContext(ctx, "SELECT @@global.tidb_enable_auto_analyze").Scan(&value)
+	if err != nil {
+		return errors.Annotate(err, "when read auto analyze for new version DB")
+	}
+	if strings.EqualFold(value, "OFF") || value == "0" {
+		return nil
+	}
+	_, err = conn.ExecContext(ctx, "SET @@global.tidb_enable_auto_analyze='OFF'")
+	if err != nil {
+		return errors.Annotate(err, "when disable auto analyze for new version DB")
+	}
+	return errors.Trace(mgr.AppendJournal(&schema.JournalEntry{
+		Kind:  schema.JournalGlobalVariable,
+		Name:  "tidb_enable_auto_analyze",
+		Value: value,
+	}))
 }
 
 // cmpPlan returns the compare result of the plan. When it meets an error, it
@@ -244,13 +512,69 @@ func cmpPlan(
 	newDB *sql.DB,
 	syncer *schema.Syncer,
 	mgr *filemgr.Manager,
-	oldCfg *TiDB,
+	oldStatus *statusAPI,
+	localTemps *localTempTables,
+	cfg *Config,
 ) *compare.PlanCmpResult {
-	ret := &compare.PlanCmpResult{
-		Result:         compare.Unknown,
-		OldVersionInfo: s,
+	ret := newPlanCmpResult(s)
+
+	err := dumpForStmt(ctx, s, oldDB, mgr, oldStatus, localTemps, cfg.SyncBackend)
+	if err != nil {
+		return fillErrMsg(ret, "dump structure and stats failed", err)
 	}
+	return replayPlan(ctx, s, newDB, syncer, mgr, ret, cfg)
+}
 
+// replayPlan synchronizes the structure, stats and binding from the work
+// directory to the new version cluster, and then compares the plans. The error
+// handling is the same as cmpPlan.
+func replayPlan(
+	ctx context.Context,
+	s *source.StmtSummary,
+	newDB *sql.DB,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+	ret *compare.PlanCmpResult,
+	cfg *Config,
+) *compare.PlanCmpResult {
+	var conn *sql.Conn
+	if s.Unsupported == "" && mgr.HasPlanReplayer(s) {
+		// the dump sets the session variables, so it's loaded by the connection
+		// running EXPLAIN
+		var err error
+		conn, err = newDB.Conn(ctx)
+		if err != nil {
+			return fillErrMsg(ret, "get connection failed", err)
+		}
+		defer util.DiscardConn(conn)
+	}
+	err := restoreForStmt(ctx, s, syncer, mgr, conn, cfg.LogicalTiFlash)
+	if err != nil {
+		return fillErrMsg(ret, "sync structure and stats failed", err)
+	}
+	return explainAndCmp(ctx, s, newDB, conn, mgr, ret, cfg)
+}
+
+// explainAndCmp gets the plan of the StmtSummary on the new version cluster and
+// compares it with the old plan. The structure and stats should be synchronized
+// before. The error handling is the same as cmpPlan. An unsupported StmtSummary
+// gets the final compare.Unsupported result directly. When
+// cfg.CompareWithoutBinding is true and the plan is from a binding, the plan
+// without the binding is also compared to fill PlanCmpResult.BindingUsage. If
+// conn is not nil, EXPLAIN runs on it.
+func explainAndCmp(
+	ctx context.Context,
+	s *source.StmtSummary,
+	newDB *sql.DB,
+	conn *sql.Conn,
+	mgr *filemgr.Manager,
+	ret *compare.PlanCmpResult,
+	cfg *Config,
+) *compare.PlanCmpResult {
+	if s.Unsupported != "" {
+		ret.Result = compare.Unsupported
+		return ret
+	}
 	oldPlan, oldPlanStr, err2 := plan.NewPlanFromStmtSummaryPlan(s.PlanStr)
 	if err2 != nil {
 		// this error is not related to network, so it must be non-retryable
@@ -259,45 +583,19 @@ func cmpPlan(
 	}
 	ret.OldPlan = oldPlanStr
 
-	err := syncForDB(ctx, oldDB, s.Schema, syncer, mgr)
-	if err != nil {
-		// TODO(lance6716): check everywhere, log error even if it is retryable
-		util.Logger.Error("sync database failed", zap.Error(err))
-		if util.IsUnretryableError(err) {
-			ret.ErrMsg = err.Error()
-		}
-		return ret
-	}
-
-	for _, table := range s.TableNamesNeedToSync {
-		err2 := syncForTable(ctx, oldDB, table, s.Schema, syncer, mgr, oldCfg)
+	opts := plan.ExplainOptions{Conn: conn}
+	if cfg.LogicalTiFlash {
+		opts.HypoTiFlashReplicas, err2 = tiflashTables(s, mgr)
 		if err2 != nil {
-			util.Logger.Error("sync table failed", zap.Error(err2))
-			if util.IsUnretryableError(err2) {
-				ret.ErrMsg = err2.Error()
-			}
-			return ret
+			return fillErrMsg(ret, "read TiFlash replicas failed", err2)
 		}
-	}
-
-	if s.Binding.BindSQL != "" {
-		err = syncer.CreateBinding(ctx, s.BindingDigest, s.Binding)
-		if err != nil {
-			util.Logger.Error("sync binding failed", zap.Error(err))
-			if util.IsUnretryableError(err) {
-				ret.ErrMsg = err.Error()
-			}
-			return ret
+		for i, t := range opts.HypoTiFlashReplicas {
+			opts.HypoTiFlashReplicas[i][0] = cfg.SchemaMapping.Target(t[0])
 		}
 	}
-
-	newPlan, newPlanStr, err2 := plan.NewPlanFromQuery(ctx, newDB, s.Schema, s.SQL)
+	newPlan, newPlanStr, err2 := explainOnNew(ctx, s, newDB, opts, cfg.SchemaMapping)
 	if err2 != nil {
-		util.Logger.Error("get new plan failed", zap.Error(err2))
-		if util.IsUnretryableError(err2) {
-			ret.ErrMsg = err2.Error()
-		}
-		return ret
+		return fillErrMsg(ret, "get new plan failed", err2)
 	}
 	ret.NewDiffPlan = newPlanStr
 
@@ -321,20 +619,113 @@ func cmpPlan(
 		zap.String("sql", s.SQL),
 	)
 
+	if cfg.CompareWithoutBinding && s.PlanInBinding && s.Binding.BindSQL != "" {
+		cmpWithoutBinding(ctx, s, newDB, opts, sql, newPlan, ret, cfg.SchemaMapping)
+	}
 	return ret
 }
 
-type metadataResult struct {
-	startTime  time.Time
-	sourceInfo *util.ClusterInfo
-	targetInfo *util.ClusterInfo
+// explainOnNew gets the plan of the StmtSummary on the new version cluster. The
+// database names in the query are mapped by mapping, and the ones in the
+// returned plan are reverted.
+func explainOnNew(
+	ctx context.Context,
+	s *source.StmtSummary,
+	newDB *sql.DB,
+	opts plan.ExplainOptions,
+	mapping schema.Mapping,
+) (*plan.Op, string, error) {
+	query, err := mapping.RewriteSQL(s.SQL)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	op, planStr, err := plan.NewPlanFromQuery(ctx, newDB, mapping.Target(s.Schema), query, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	unmapPlan(op, mapping)
+	return op, planStr, nil
+}
+
+// unmapPlan reverts the database names of the tables in the plan by the
+// mapping, so they can be compared with the plan of the old version cluster.
+func unmapPlan(op *plan.Op, mapping schema.Mapping) {
+	if len(mapping) == 0 {
+		return
+	}
+	if o := op.AccessObject; o != nil {
+		if dbName, table, ok := strings.Cut(o.Table, "."); ok {
+			o.Table = mapping.Source(dbName) + "." + table
+		}
+	}
+	for _, child := range op.Children {
+		unmapPlan(child, mapping)
+	}
+}
+
+// cmpWithoutBinding gets the plan of the StmtSummary without the binding and
+// compares it with newPlan, which is the plan with the binding and got by opts
+// and mapping. The failure is only logged because the binding usage is an
+// optional information of ret.
+func cmpWithoutBinding(
+	ctx context.Context,
+	s *source.StmtSummary,
+	newDB *sql.DB,
+	opts plan.ExplainOptions,
+	sql string,
+	newPlan *plan.Op,
+	ret *compare.PlanCmpResult,
+	mapping schema.Mapping,
+) {
+	opts.WithoutBinding = true
+	noBindingPlan, noBindingPlanStr, err := explainOnNew(ctx, s, newDB, opts, mapping)
+	if err != nil {
+		util.Logger.Warn("get new plan without binding failed",
+			zap.String("sql", s.SQL),
+			zap.Error(err))
+		return
+	}
+	usage, err := compare.CmpBinding(sql, ret.Result, newPlan, noBindingPlan)
+	if err != nil {
+		util.Logger.Warn("compare plan without binding failed",
+			zap.String("sql", s.SQL),
+			zap.Error(err))
+		return
+	}
+	ret.BindingUsage = usage
+	if usage != compare.BindingRedundant {
+		ret.NoBindingPlan = noBindingPlanStr
+	}
+}
+
+func newPlanCmpResult(s *source.StmtSummary) *compare.PlanCmpResult {
+	return &compare.PlanCmpResult{
+		Result:         compare.Unknown,
+		OldVersionInfo: s,
+	}
 }
 
+// fillErrMsg logs the error and fills it into ret.ErrMsg if it's unretryable.
+func fillErrMsg(ret *compare.PlanCmpResult, msg string, err error) *compare.PlanCmpResult {
+	util.Logger.Error(msg, zap.Error(err))
+	if util.IsUnretryableError(err) {
+		ret.ErrMsg = err.Error()
+	}
+	return ret
+}
+
+// isFinalResult returns false if the result is caused by a retryable error.
+func isFinalResult(r *compare.PlanCmpResult) bool {
+	return r.Result != compare.Unknown || r.ErrMsg != ""
+}
+
+// processResults classifies the results and builds the report. captureMeta and
+// compareMeta are used to fill the metadata of the report.
 func processResults(
 	allResults []*compare.PlanCmpResult,
 	cfg *Config,
-	mgr *filemgr.Manager,
-	m *metadataResult,
+	captureMeta *filemgr.CaptureMeta,
+	compareMeta *filemgr.CompareMeta,
 ) (*report.Report, error) {
 	waitRetry := make([]*compare.PlanCmpResult, 0, len(allResults))
 	waitRetryExecCount := 0
@@ -344,6 +735,10 @@ func processResults(
 	cmpSamerResultsExecCount := 0
 	cmpDiffResults := make([]*compare.PlanCmpResult, 0, len(allResults))
 	cmpDiffResultsExecCount := 0
+	timedOutResults := make([]*compare.PlanCmpResult, 0, len(allResults))
+	timedOutResultsExecCount := 0
+	unsupportedResults := make([]*compare.PlanCmpResult, 0, len(allResults))
+	unsupportedResultsExecCount := 0
 	for _, result := range allResults {
 		s := result.OldVersionInfo
 		switch result.Result {
@@ -362,11 +757,12 @@ func processResults(
 		case compare.Diff:
 			cmpDiffResults = append(cmpDiffResults, result)
 			cmpDiffResultsExecCount += s.ExecCount
-		}
-
-		err := mgr.WriteResult(result)
-		if err != nil {
-			return nil, errors.Trace(err)
+		case compare.Timeout:
+			timedOutResults = append(timedOutResults, result)
+			timedOutResultsExecCount += s.ExecCount
+		case compare.Unsupported:
+			unsupportedResults = append(unsupportedResults, result)
+			unsupportedResultsExecCount += s.ExecCount
 		}
 	}
 
@@ -374,17 +770,44 @@ func processResults(
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	lastUpdated := time.Now()
-	oldCfg := &cfg.OldVersion
+	sourceInfo := captureMeta.ClusterInfo
+	if sourceInfo == nil {
+		sourceInfo = &util.ClusterInfo{}
+	}
+	targetInfo := compareMeta.ClusterInfo
+	if targetInfo == nil {
+		targetInfo = &util.ClusterInfo{}
+	}
+	lastUpdated := compareMeta.EndTime
+	interval := "N/A"
+	if captureMeta.Interval > 0 {
+		interval = fmt.Sprintf("%s (%d rounds)", captureMeta.Interval, captureMeta.Rounds)
+	}
+	globalTimeLimit := "UNLIMITED"
+	if compareMeta.GlobalTimeLimit > 0 {
+		globalTimeLimit = compareMeta.GlobalTimeLimit.String()
+	}
+	perSQLTimeLimit := "UNUSED"
+	if compareMeta.PerSQLTimeLimit > 0 {
+		perSQLTimeLimit = compareMeta.PerSQLTimeLimit.String()
+	}
+	status := "Completed"
+	if compareMeta.TimedOut {
+		status = "Timed out"
+	}
+	coverage := "N/A"
+	if len(captureMeta.Coverage) > 0 {
+		coverage = formatPercent(source.OverallCoverage(captureMeta.Coverage)) + " of executions"
+	}
 	r := &report.Report{
 		Deployments: report.TableWithColRowHeader{
 			ColHeader: []string{"", "Source", "Target"},
 			RowHeader: []string{"# of TiDB", "TiDB version", "PD version", "TiKV version"},
 			Data: [][]string{
-				{strconv.Itoa(m.sourceInfo.TiDBCnt), strconv.Itoa(m.targetInfo.TiDBCnt)},
-				{m.sourceInfo.TiDBVersion, m.targetInfo.TiDBVersion},
-				{m.sourceInfo.PDVersion, m.targetInfo.PDVersion},
-				{m.sourceInfo.TiKVVersion, m.targetInfo.TiKVVersion},
+				{strconv.Itoa(sourceInfo.TiDBCnt), strconv.Itoa(targetInfo.TiDBCnt)},
+				{sourceInfo.TiDBVersion, targetInfo.TiDBVersion},
+				{sourceInfo.PDVersion, targetInfo.PDVersion},
+				{sourceInfo.TiKVVersion, targetInfo.TiKVVersion},
 			},
 		},
 		TaskInfoItems: [][2]string{
@@ -394,29 +817,33 @@ func processResults(
 			{"Description", cfg.Description},
 		},
 		CaptureInfoItems: [][2]string{
-			{"Capture task started", m.startTime.Format(time.RFC3339)},
+			{"Capture task started", captureMeta.StartTime.Format(time.RFC3339)},
 			{"Capture task completed", lastUpdated.Format(time.RFC3339)},
-			{"Total seconds captured", strconv.FormatFloat(lastUpdated.Sub(m.startTime).Seconds(), 'f', 2, 64)},
-			{"Interval", "N/A"},
-			{"Endpoint", net.JoinHostPort(oldCfg.Host, strconv.Itoa(oldCfg.Port))},
-			{"User", oldCfg.User},
-			{"Data Source", "system table"},
-			{"Filtering Rules", "EXEC_COUNT > 1"},
+			{"Total seconds captured", strconv.FormatFloat(lastUpdated.Sub(captureMeta.StartTime).Seconds(), 'f', 2, 64)},
+			{"Interval", interval},
+			{"Endpoint", captureMeta.Endpoint},
+			{"User", captureMeta.User},
+			{"Data Source", captureMeta.DataSource},
+			{"Capture Coverage", coverage},
+			{"Filtering Rules", strings.Join(captureMeta.FilteringRules, "; ")},
 			{"Total SQL Statement Count", strconv.Itoa(len(allResults))},
 		},
 		ExecutionInfoItems: [][2]string{
 
-			{"Global Time Limit", "UNLIMITED"},
-			{"Per-SQL Time Limit", "UNUSED"},
-			{"Status", "Completed"},
-			{"Number of Unsupported SQLs", "0"},
+			{"Global Time Limit", globalTimeLimit},
+			{"Per-SQL Time Limit", perSQLTimeLimit},
+			{"Status", status},
+			{"Number of Unsupported SQLs", strconv.Itoa(len(unsupportedResults))},
 			{"Number of Error", strconv.Itoa(len(errResults) + len(waitRetry))},
+			{"Number of Timed Out", strconv.Itoa(len(timedOutResults))},
 			{"Number of Successful", strconv.Itoa(len(cmpSameResults) + len(cmpDiffResults))},
 		},
 		Summary: report.Summary{
 			Overall: report.ChangeCount{
-				SQL:  waitRetryExecCount + errResultsExecCount + cmpSamerResultsExecCount + cmpDiffResultsExecCount,
-				Plan: len(waitRetry) + len(errResults) + len(cmpSameResults) + len(cmpDiffResults),
+				SQL: waitRetryExecCount + errResultsExecCount + cmpSamerResultsExecCount + cmpDiffResultsExecCount +
+					timedOutResultsExecCount + unsupportedResultsExecCount,
+				Plan: len(waitRetry) + len(errResults) + len(cmpSameResults) + len(cmpDiffResults) +
+					len(timedOutResults) + len(unsupportedResults),
 			},
 			Unchanged: report.ChangeCount{
 				SQL:  cmpSamerResultsExecCount,
@@ -430,8 +857,43 @@ func processResults(
 				SQL:  errResultsExecCount + waitRetryExecCount,
 				Plan: len(errResults) + len(waitRetry),
 			},
+			TimedOut: report.ChangeCount{
+				SQL:  timedOutResultsExecCount,
+				Plan: len(timedOutResults),
+			},
+			Unsupported: report.ChangeCount{
+				SQL:  unsupportedResultsExecCount,
+				Plan: len(unsupportedResults),
+			},
 		},
 	}
+	r.Coverage = report.Table{
+		Header: []string{"Instance", "Window Begin", "Window End", "EXEC_COUNT", "Evicted EXEC_COUNT", "Evicted Digests", "Coverage"},
+		Data:   make([][]string, 0, len(captureMeta.Coverage)),
+	}
+	for _, c := range captureMeta.Coverage {
+		r.Coverage.Data = append(r.Coverage.Data, []string{
+			c.Instance,
+			c.BeginTime.Format(time.RFC3339),
+			c.EndTime.Format(time.RFC3339),
+			strconv.FormatInt(c.ExecCount, 10),
+			strconv.FormatInt(c.EvictedExecCount, 10),
+			strconv.FormatInt(c.EvictedDigests, 10),
+			formatPercent(c.Ratio()),
+		})
+	}
+	r.VariableDiffs = report.Table{
+		Header: []string{"Variable", "Source", "Target", "Synced"},
+		Data:   make([][]string, 0, len(compareMeta.VariableDiffs)),
+	}
+	for _, d := range compareMeta.VariableDiffs {
+		r.VariableDiffs.Data = append(r.VariableDiffs.Data, []string{
+			d.Name,
+			d.Old,
+			d.New,
+			strconv.FormatBool(d.Synced),
+		})
+	}
 	topSQLs := topNSumLatencyPlans(allResults, 500)
 	r.TopSQLs = report.Table{
 		Header: []string{"DIGEST", "DIGEST_TEXT", "Source AVG_LATENCY", "Source EXEC_COUNT", "Target AVG_LATENCY", "Target EXEC_COUNT", "Plan change"},
@@ -442,7 +904,7 @@ func processResults(
 		r.TopSQLs.Data = append(r.TopSQLs.Data, []string{
 			s.SQLDigest,
 			s.SQL,
-			(s.SumLatency / time.Duration(s.ExecCount)).String(),
+			avgLatency(s),
 			strconv.Itoa(s.ExecCount),
 			"",
 			"",
@@ -456,25 +918,42 @@ func processResults(
 			Labels: [][2]string{
 				{"Schema Name", result.OldVersionInfo.Schema},
 				{"SQL Text", result.OldVersionInfo.SQL},
-				{"Source AVG_LATENCY", (result.OldVersionInfo.SumLatency / time.Duration(result.OldVersionInfo.ExecCount)).String()},
+				{"Source AVG_LATENCY", avgLatency(result.OldVersionInfo)},
 				{"Source EXEC_COUNT", strconv.Itoa(result.OldVersionInfo.ExecCount)},
+				{"Source Instances", strings.Join(result.OldVersionInfo.Instances, ", ")},
+				{"Capture Windows", strconv.Itoa(len(result.OldVersionInfo.SummaryBeginTimes))},
 				{"Plan Change", string(result.Result)},
 			},
 			Source: &report.Plan{
 				Text: result.OldPlan,
 			},
 		}
+		if result.OldVersionInfo.Unsupported != "" {
+			r.Details[i].Labels = append(r.Details[i].Labels, [2]string{"Unsupported Reason", result.OldVersionInfo.Unsupported})
+		}
+		if result.BindingUsage != "" {
+			r.Details[i].Labels = append(r.Details[i].Labels, [2]string{"Binding Usage", string(result.BindingUsage)})
+		}
 
 		if result.NewDiffPlan != "" {
 			r.Details[i].Target = &report.Plan{
 				Text: result.NewDiffPlan,
 			}
 		}
+		if result.NoBindingPlan != "" {
+			r.Details[i].TargetWithoutBinding = &report.Plan{
+				Text: result.NoBindingPlan,
+			}
+		}
 	}
 
 	return r, nil
 }
 
+func formatPercent(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 2, 64) + "%"
+}
+
 type ResultHeap []*compare.PlanCmpResult
 
 func (r ResultHeap) Len() int {
@@ -501,6 +980,14 @@ func (r *ResultHeap) Pop() any {
 	return x
 }
 
+// avgLatency returns the average latency of the statement to be rendered.
+func avgLatency(s *source.StmtSummary) string {
+	if s.LatencyUnavailable || s.ExecCount == 0 {
+		return "N/A"
+	}
+	return (s.SumLatency / time.Duration(s.ExecCount)).String()
+}
+
 // topNSumLatencyPlans will not modify the input results, and return the sorted
 // results with the top N sum latency.
 func topNSumLatencyPlans(results []*compare.PlanCmpResult, n int) []*compare.PlanCmpResult {
@@ -1,11 +1,26 @@
 package pcc
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
 	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestTopNSumLatencyPlans(t *testing.T) {
@@ -27,3 +42,499 @@ func TestTopNSumLatencyPlans(t *testing.T) {
 	got = topNSumLatencyPlans([]*compare.PlanCmpResult{r1, r3, r2, r5, r4}, 3)
 	require.Equal(t, []*compare.PlanCmpResult{r5, r4, r3}, got)
 }
+
+func TestMergeResults(t *testing.T) {
+	s1 := &source.StmtSummary{SQLDigest: "s1", PlanDigest: "p1"}
+	s2 := &source.StmtSummary{SQLDigest: "s2", PlanDigest: "p2"}
+	s3 := &source.StmtSummary{SQLDigest: "s3", PlanDigest: "p3"}
+	r1 := &compare.PlanCmpResult{Result: compare.Same, OldVersionInfo: s1}
+	r3 := &compare.PlanCmpResult{Result: compare.Unknown, ErrMsg: "err", OldVersionInfo: s3}
+
+	got := mergeResults([]*source.StmtSummary{s1, s2, s3}, []*compare.PlanCmpResult{r1, r3})
+	require.Equal(t, []*compare.PlanCmpResult{
+		r1,
+		r3,
+		{Result: compare.Unknown, OldVersionInfo: s2},
+	}, got)
+}
+
+func TestAvgLatency(t *testing.T) {
+	require.Equal(t, "1.5s", avgLatency(&source.StmtSummary{ExecCount: 2, SumLatency: 3 * time.Second}))
+	require.Equal(t, "N/A", avgLatency(&source.StmtSummary{ExecCount: 2, LatencyUnavailable: true}))
+}
+
+func TestReplayPlan(t *testing.T) {
+	mgr := filemgr.NewManager(t.TempDir())
+	require.NoError(t, mgr.WriteDatabaseStructure("test", "CREATE DATABASE `test`"))
+	require.NoError(t, mgr.WriteTableStructure("test", "t", "CREATE TABLE `t` (`a` int)"))
+	require.NoError(t, mgr.WriteTableStats("test", "t", "null"))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE DATABASE `test`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t` (`a` int)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT * FROM t")).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("TableReader_7", "10", "root", "", "data:TableFullScan_6").
+			AddRow("└─TableFullScan_6", "10", "cop[tikv]", "table:t", "keep order:false"),
+	)
+
+	s := &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT * FROM t",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+		PlanStr: "\tid                \ttask     \testRows\toperator info\n" +
+			"\tTableReader_5     \troot     \t10     \tdata:TableFullScan_4\n" +
+			"\t└─TableFullScan_4\tcop[tikv]\t10     \ttable:t, keep order:false",
+	}
+	ret := replayPlan(context.Background(), s, db, schema.NewSyncer(db, schema.MismatchReport, nil, nil), mgr, newPlanCmpResult(s), &Config{})
+	require.Equal(t, "", ret.ErrMsg)
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.Equal(t, "TableReader_5\n└─TableFullScan_4", ret.OldPlan)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the unsupported statement is not synchronized or compared
+	s = &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT * FROM t WHERE a IN (1, 2(len:100)",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+		Unsupported:          source.UnsupportedTruncated,
+	}
+	ret = replayPlan(context.Background(), s, db, schema.NewSyncer(db, schema.MismatchReport, nil, nil), mgr, newPlanCmpResult(s), &Config{})
+	require.EqualValues(t, compare.Unsupported, ret.Result)
+	require.True(t, isFinalResult(ret))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayPlanWithSchemaMapping(t *testing.T) {
+	mgr := filemgr.NewManager(t.TempDir())
+	require.NoError(t, mgr.WriteDatabaseStructure("test", "CREATE DATABASE `test`"))
+	require.NoError(t, mgr.WriteTableStructure("test", "t", "CREATE TABLE `t` (`a` int)"))
+	require.NoError(t, mgr.WriteTableStats("test", "t", "null"))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE DATABASE `pcc-test`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `pcc-test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t` (`a` INT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `pcc-test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT * FROM `pcc-test`.`t` JOIN `t` AS `t2`")).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("HashJoin_7", "100", "root", "", "").
+			AddRow("├─TableReader_9", "10", "root", "", "data:TableFullScan_8").
+			AddRow("│ └─TableFullScan_8", "10", "cop[tikv]", "table:pcc-test.t", "keep order:false").
+			AddRow("└─TableReader_11", "10", "root", "", "data:TableFullScan_10").
+			AddRow("  └─TableFullScan_10", "10", "cop[tikv]", "table:t2", "keep order:false"),
+	)
+
+	s := &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT * FROM test.t JOIN t AS t2",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+		PlanStr: "\tid                   \ttask     \testRows\toperator info\n" +
+			"\tHashJoin_5           \troot     \t100    \t\n" +
+			"\t├─TableReader_7      \troot     \t10     \tdata:TableFullScan_6\n" +
+			"\t│ └─TableFullScan_6 \tcop[tikv]\t10     \ttable:test.t, keep order:false\n" +
+			"\t└─TableReader_9      \troot     \t10     \tdata:TableFullScan_8\n" +
+			"\t  └─TableFullScan_8 \tcop[tikv]\t10     \ttable:t2, keep order:false",
+	}
+	cfg := &Config{SchemaMapping: schema.Mapping{"test": "pcc-test"}}
+	syncer := schema.NewSyncer(db, schema.MismatchReport, cfg.SchemaMapping, nil)
+	ret := replayPlan(context.Background(), s, db, syncer, mgr, newPlanCmpResult(s), cfg)
+	require.Equal(t, "", ret.ErrMsg)
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCmpWithoutBinding(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	indexPlan := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("IndexReader_6", "10", "root", "", "index:IndexFullScan_5").
+			AddRow("└─IndexFullScan_5", "10", "cop[tikv]", "table:t, index:idx(a)", "keep order:false")
+	}
+	tablePlan := sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+		AddRow("TableReader_7", "10", "root", "", "data:TableFullScan_6").
+		AddRow("└─TableFullScan_6", "10", "cop[tikv]", "table:t", "keep order:false")
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT a FROM t")).WillReturnRows(indexPlan())
+	mock.ExpectExec(regexp.QuoteMeta("SET @@session.tidb_use_plan_baselines = OFF")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT a FROM t")).WillReturnRows(tablePlan)
+	mock.ExpectExec(regexp.QuoteMeta("SET @@session.tidb_use_plan_baselines = DEFAULT")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s := &source.StmtSummary{
+		Schema:        "test",
+		SQL:           "SELECT a FROM t",
+		PlanInBinding: true,
+		Binding: source.Binding{
+			OriginalSQL: "select `a` from `test` . `t`",
+			BindSQL:     "SELECT a FROM test.t USE INDEX (idx)",
+		},
+		PlanStr: "\tid                \ttask     \testRows\toperator info\n" +
+			"\tIndexReader_6     \troot     \t10     \tindex:IndexFullScan_5\n" +
+			"\t└─IndexFullScan_5\tcop[tikv]\t10     \ttable:t, index:idx(a), keep order:false",
+	}
+	ret := explainAndCmp(context.Background(), s, db, nil, nil, newPlanCmpResult(s), &Config{CompareWithoutBinding: true})
+	require.Equal(t, "", ret.ErrMsg)
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.Equal(t, compare.BindingNeeded, ret.BindingUsage)
+	require.Equal(t, "TableReader_7\n└─TableFullScan_6", ret.NoBindingPlan)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the plan without binding is not compared when the option is off
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT a FROM t")).WillReturnRows(indexPlan())
+	ret = explainAndCmp(context.Background(), s, db, nil, nil, newPlanCmpResult(s), &Config{})
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.Equal(t, compare.BindingUsage(""), ret.BindingUsage)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPlanReplayerBackend(t *testing.T) {
+	var dump bytes.Buffer
+	zw := zip.NewWriter(&dump)
+	f, err := zw.Create("schema/test.t.schema.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("create database if not exists `test`; use `test`;CREATE TABLE `t` (`a` int)"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plan_replayer/dump/replayer_abc.zip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(dump.Bytes())
+	}))
+	defer server.Close()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("PLAN REPLAYER DUMP EXPLAIN SELECT * FROM t")).
+		WillReturnRows(sqlmock.NewRows([]string{"File_token"}).AddRow("replayer_abc.zip"))
+
+	mgr := filemgr.NewManager(t.TempDir())
+	s := &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT * FROM t",
+		SQLDigest:            "sql1",
+		PlanDigest:           "plan1",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+	}
+	status := &statusAPI{client: server.Client(), url: server.URL}
+	require.NoError(t, dumpForStmt(context.Background(), s, db, mgr, status, &localTempTables{}, SyncBackendPlanReplayer))
+	require.True(t, mgr.HasPlanReplayer(s))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the dump is loaded by the connection running EXPLAIN
+	path := mgr.GetPlanReplayerPath(s)
+	mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+		WithArgs("test", "t").WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}))
+	mock.ExpectQuery("SELECT 1 FROM INFORMATION_SCHEMA.SCHEMATA").
+		WithArgs("test").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("PLAN REPLAYER LOAD 'Reader::" + path + "'")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT * FROM t")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("TableReader_7", "10", "root", "", "data:TableFullScan_6").
+			AddRow("└─TableFullScan_6", "10", "cop[tikv]", "table:t", "keep order:false"))
+	s.PlanStr = "\tid                \ttask     \testRows\toperator info\n" +
+		"\tTableReader_7     \troot     \t10     \tdata:TableFullScan_6\n" +
+		"\t└─TableFullScan_6\tcop[tikv]\t10     \ttable:t, keep order:false"
+	syncer := schema.NewSyncer(db, schema.MismatchReport, nil, nil)
+	ret := replayPlan(context.Background(), s, db, syncer, mgr, newPlanCmpResult(s), &Config{})
+	require.Equal(t, "", ret.ErrMsg)
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSyncTableOptionsAndPlacementPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("null"))
+	}))
+	defer server.Close()
+
+	createTable := "CREATE TABLE `t` (`a` int) /*T![placement] PLACEMENT POLICY=`p1` */"
+	createPolicy := "CREATE PLACEMENT POLICY `p1` FOLLOWERS=4"
+	oldDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer oldDB.Close()
+	for range 2 {
+		mock.ExpectQuery("SHOW CREATE DATABASE `test`").
+			WillReturnRows(sqlmock.NewRows([]string{"Database", "Create Database"}).AddRow("test", "CREATE DATABASE `test`"))
+	}
+	mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+		WithArgs("test", "t").
+		WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}).AddRow("cached=on"))
+	mock.ExpectQuery("SELECT REPLICA_COUNT FROM INFORMATION_SCHEMA.TIFLASH_REPLICA").
+		WithArgs("test", "t").
+		WillReturnRows(sqlmock.NewRows([]string{"REPLICA_COUNT"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `test`.`t`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("t", createTable))
+	mock.ExpectQuery("SHOW CREATE PLACEMENT POLICY `p1`").
+		WillReturnRows(sqlmock.NewRows([]string{"Policy", "Create Policy"}).AddRow("p1", createPolicy))
+
+	mgr := filemgr.NewManager(t.TempDir())
+	s := &source.StmtSummary{
+		Schema:               "test",
+		SQL:                  "SELECT count(*) FROM t",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+		PlanStr: "\tid                \ttask        \testRows\toperator info\n" +
+			"\tTableReader_5     \troot        \t1      \tdata:TableFullScan_4\n" +
+			"\t└─TableFullScan_4\tcop[tiflash]\t10     \ttable:t, keep order:false",
+	}
+	status := &statusAPI{client: server.Client(), url: server.URL}
+	require.NoError(t, dumpForStmt(context.Background(), s, oldDB, mgr, status, &localTempTables{}, SyncBackendSchema))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	expectCreate := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(regexp.QuoteMeta("CREATE DATABASE `test`")).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(createPolicy)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(createTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	newDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer newDB.Close()
+	expectCreate(mock)
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` SET TIFLASH REPLICA 2")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` CACHE")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, restoreForStmt(context.Background(), s, schema.NewSyncer(newDB, schema.MismatchReport, nil, nil), mgr, nil, false))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// logical TiFlash uses hypothetical replicas instead
+	newDB2, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer newDB2.Close()
+	expectCreate(mock)
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` CACHE")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` SET HYPO TIFLASH REPLICA 1")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT count(*) FROM t")).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("TableReader_7", "1", "root", "", "data:TableFullScan_6").
+			AddRow("└─TableFullScan_6", "10", "mpp[tiflash]", "table:t", "keep order:false"),
+	)
+	// the hypothetical replicas are removed before the session is put back
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` SET HYPO TIFLASH REPLICA 0")).WillReturnResult(sqlmock.NewResult(0, 0))
+	cfg := &Config{LogicalTiFlash: true}
+	ret := replayPlan(context.Background(), s, newDB2, schema.NewSyncer(newDB2, schema.MismatchReport, nil, nil), mgr, newPlanCmpResult(s), cfg)
+	require.Equal(t, "", ret.ErrMsg)
+	require.EqualValues(t, compare.Same, ret.Result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDumpTemporaryTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mgr := filemgr.NewManager(t.TempDir())
+	// the status port is not needed by temporary tables
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	status := &statusAPI{client: server.Client(), url: server.URL}
+	localTemps := &localTempTables{}
+
+	// the stats of a global temporary table are not read
+	createTable := "CREATE GLOBAL TEMPORARY TABLE `t` (`a` int) ON COMMIT DELETE ROWS"
+	expectCreateDatabase := func() {
+		mock.ExpectQuery("SHOW CREATE DATABASE `test`").
+			WillReturnRows(sqlmock.NewRows([]string{"Database", "Create Database"}).AddRow("test", "CREATE DATABASE `test`"))
+	}
+	expectCreateDatabase()
+	mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+		WithArgs("test", "t").
+		WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}).AddRow(""))
+	mock.ExpectQuery("SELECT REPLICA_COUNT FROM INFORMATION_SCHEMA.TIFLASH_REPLICA").
+		WithArgs("test", "t").
+		WillReturnRows(sqlmock.NewRows([]string{"REPLICA_COUNT"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `test`.`t`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("t", createTable))
+	s := &source.StmtSummary{
+		SQL:                  "SELECT * FROM test.t",
+		SQLDigest:            "sql1",
+		PlanDigest:           "plan1",
+		TableNamesNeedToSync: [][2]string{{"test", "t"}},
+	}
+	require.NoError(t, dumpForStmt(context.Background(), s, db, mgr, status, localTemps, SyncBackendSchema))
+	require.Equal(t, "", s.Unsupported)
+	stats, err := os.ReadFile(mgr.GetTableStatsPath("test", "t"))
+	require.NoError(t, err)
+	require.Equal(t, "null", string(stats))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	expectMissing := func(table string) {
+		expectCreateDatabase()
+		mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+			WithArgs("test", table).
+			WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}))
+	}
+
+	// the table is not found without the evidence of a local temporary table
+	expectMissing("tmp2")
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY").WillReturnRows(
+		sqlmock.NewRows([]string{"SCHEMA_NAME", "QUERY_SAMPLE_TEXT"}).
+			AddRow("test", "CREATE GLOBAL TEMPORARY TABLE tmp2 (a int) ON COMMIT DELETE ROWS").
+			AddRow("other", "CREATE TEMPORARY TABLE tmp2 (a int)").
+			AddRow("test", "CREATE TEMPORARY TABLE `TMP` (`a` int)"),
+	)
+	s = &source.StmtSummary{
+		SQL:                  "SELECT * FROM test.tmp2",
+		SQLDigest:            "sql2",
+		PlanDigest:           "plan2",
+		TableNamesNeedToSync: [][2]string{{"test", "tmp2"}},
+	}
+	err = dumpForStmt(context.Background(), s, db, mgr, status, localTemps, SyncBackendSchema)
+	require.ErrorContains(t, err, "table `test`.`tmp2` is not found in the old version cluster")
+	require.True(t, util.IsUnretryableError(err))
+	require.Equal(t, "", s.Unsupported)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// a local temporary table can't be found by other sessions, and the
+	// statement summary is not read again during the sync
+	expectMissing("tmp")
+	s = &source.StmtSummary{
+		SQL:                  "SELECT * FROM test.tmp",
+		SQLDigest:            "sql3",
+		PlanDigest:           "plan3",
+		TableNamesNeedToSync: [][2]string{{"test", "tmp"}},
+	}
+	require.NoError(t, dumpForStmt(context.Background(), s, db, mgr, status, localTemps, SyncBackendSchema))
+	require.Equal(t, "table `test`.`tmp` is not found, it's created as a local temporary table", s.Unsupported)
+	summaries, err := mgr.ReadStmtSummaries()
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	require.Equal(t, s.Unsupported, summaries[0].Unsupported)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	ret := replayPlan(context.Background(), s, db, schema.NewSyncer(db, schema.MismatchReport, nil, nil), mgr, newPlanCmpResult(s), &Config{})
+	require.EqualValues(t, compare.Unsupported, ret.Result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSyncGlobalVariables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mgr := filemgr.NewManager(t.TempDir())
+	patterns := []string{"tidb_opt_*", "tidb_cost_model_version", "tidb_enable_auto_analyze"}
+	oldVars := map[string]string{
+		"tidb_cost_model_version":  "1",
+		"tidb_opt_agg_push_down":   "ON",
+		"tidb_opt_bad = 1, x":      "ON",
+		"tidb_opt_removed":         "OFF",
+		"tidb_opt_same":            "1",
+		"tidb_enable_auto_analyze": "ON",
+	}
+
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.GLOBAL_VARIABLES").
+		WithArgs("tidb\\_opt\\_%", "tidb\\_cost\\_model\\_version", "tidb\\_enable\\_auto\\_analyze").
+		WillReturnRows(sqlmock.NewRows([]string{"VARIABLE_NAME", "VARIABLE_VALUE"}).
+			AddRow("tidb_cost_model_version", "2").
+			AddRow("tidb_opt_agg_push_down", "OFF").
+			AddRow("tidb_opt_bad = 1, x", "OFF").
+			AddRow("tidb_opt_same", "1").
+			AddRow("tidb_enable_auto_analyze", "OFF"))
+	mock.ExpectExec(regexp.QuoteMeta("SET GLOBAL tidb_cost_model_version = '1'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("SET GLOBAL tidb_opt_agg_push_down = 'ON'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	diffs, err := syncGlobalVariables(context.Background(), db, oldVars, patterns, mgr)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	expected := []*filemgr.VariableDiff{
+		{Name: "tidb_cost_model_version", Old: "1", New: "2", Synced: true},
+		{Name: "tidb_opt_agg_push_down", Old: "ON", New: "OFF", Synced: true},
+		// the invalid name is not concatenated into SET GLOBAL
+		{Name: "tidb_opt_bad = 1, x", Old: "ON", New: "OFF"},
+		{Name: "tidb_opt_removed", Old: "OFF", New: ""},
+	}
+	require.Equal(t, expected, diffs)
+	// the original values are recorded for cleanup
+	entries, err := mgr.ReadJournal()
+	require.NoError(t, err)
+	require.Equal(t, []*schema.JournalEntry{
+		{Kind: schema.JournalGlobalVariable, Name: "tidb_cost_model_version", Value: "2"},
+		{Kind: schema.JournalGlobalVariable, Name: "tidb_opt_agg_push_down", Value: "OFF"},
+	}, entries)
+
+	// the later stage still reports the value before synchronizing. The session
+	// is discarded after synchronizing, so use a new mock DB
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.GLOBAL_VARIABLES").
+		WillReturnRows(sqlmock.NewRows([]string{"VARIABLE_NAME", "VARIABLE_VALUE"}).
+			AddRow("tidb_cost_model_version", "1").
+			AddRow("tidb_opt_agg_push_down", "ON").
+			AddRow("tidb_opt_bad = 1, x", "OFF").
+			AddRow("tidb_opt_same", "1").
+			AddRow("tidb_enable_auto_analyze", "OFF"))
+	diffs, err = syncGlobalVariables(context.Background(), db, oldVars, patterns, mgr)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, expected, diffs)
+	entries, err = mgr.ReadJournal()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestReplayStmtsStopWithCollector(t *testing.T) {
+	notDirPath := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(notDirPath, nil, 0o644))
+	// the worker picks the next statement or exits randomly after the collector
+	// exits, so run several times to make sure it's not blocked
+	for range 20 {
+		for _, writeFail := range []bool{false, true} {
+			summCh := make(chan *source.StmtSummary, 10)
+			for i := range cap(summCh) {
+				summCh <- &source.StmtSummary{SQLDigest: fmt.Sprintf("d%d", i), PlanDigest: "p"}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			eg, egCtx := errgroup.WithContext(ctx)
+			mgr := filemgr.NewManager(t.TempDir())
+			if writeFail {
+				mgr = filemgr.NewManager(notDirPath)
+			}
+			var allResults []*compare.PlanCmpResult
+			replayStmts(egCtx, eg, 1, summCh, mgr, &allResults,
+				func(s *source.StmtSummary) *compare.PlanCmpResult {
+					// the collector exits after the first result is canceled or
+					// failed to write, the later results are sent after that
+					if s.SQLDigest == "d0" {
+						if !writeFail {
+							cancel()
+						}
+					} else {
+						<-egCtx.Done()
+					}
+					return &compare.PlanCmpResult{OldVersionInfo: s, Result: compare.Same}
+				})
+
+			done := make(chan error)
+			go func() { done <- eg.Wait() }()
+			select {
+			case err := <-done:
+				if writeFail {
+					require.ErrorContains(t, err, "not a directory")
+				} else {
+					require.NoError(t, err)
+				}
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "replay workers are blocked after the collector exits")
+			}
+			cancel()
+		}
+	}
+}
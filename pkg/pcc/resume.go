@@ -0,0 +1,136 @@
+package pcc
+
+import (
+	"context"
+	"slices"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// previousRun is the progress of an interrupted run, which is loaded from the
+// work directory.
+type previousRun struct {
+	// finished is the final results keyed by the ID of StmtSummary.
+	finished map[string]*compare.PlanCmpResult
+	// unfinished is the persisted StmtSummary without a final result.
+	unfinished []*source.StmtSummary
+}
+
+// loadPreviousRun reads the persisted statement summaries and results from the
+// work directory. If the work directory is new, it returns an empty
+// previousRun.
+func loadPreviousRun(mgr *filemgr.Manager) (*previousRun, error) {
+	results, err := mgr.ReadResults()
+	if err != nil {
+		return nil, errors.Annotate(err, "read results of previous run")
+	}
+	summaries, err := mgr.ReadStmtSummaries()
+	if err != nil {
+		return nil, errors.Annotate(err, "read statement summaries of previous run")
+	}
+
+	// the not final results should not be persisted, ignore them in case of
+	// that, the StmtSummary will be added to unfinished below
+	results = slices.DeleteFunc(results, func(r *compare.PlanCmpResult) bool {
+		return !isFinalResult(r)
+	})
+	ret := newPreviousRun(results)
+	for _, s := range summaries {
+		if _, ok := ret.finished[s.ID()]; !ok {
+			ret.unfinished = append(ret.unfinished, s)
+		}
+	}
+	if len(ret.finished) > 0 || len(ret.unfinished) > 0 {
+		util.Logger.Info("found previous run in work directory, will resume from it",
+			zap.Int("finished", len(ret.finished)),
+			zap.Int("unfinished", len(ret.unfinished)))
+	}
+	return ret, nil
+}
+
+// newPreviousRun builds a previousRun from the results. The results not final
+// are treated as unfinished.
+func newPreviousRun(results []*compare.PlanCmpResult) *previousRun {
+	ret := &previousRun{
+		finished: make(map[string]*compare.PlanCmpResult, len(results)),
+	}
+	for _, r := range results {
+		s := r.OldVersionInfo
+		if !isFinalResult(r) {
+			ret.unfinished = append(ret.unfinished, s)
+			continue
+		}
+		ret.finished[s.ID()] = r
+	}
+	return ret
+}
+
+// results returns the final results of the previous run.
+func (p *previousRun) results() []*compare.PlanCmpResult {
+	ret := make([]*compare.PlanCmpResult, 0, len(p.finished))
+	for _, r := range p.finished {
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// isFinished returns true if the StmtSummary has a final result in the previous
+// run.
+func (p *previousRun) isFinished(s *source.StmtSummary) bool {
+	_, ok := p.finished[s.ID()]
+	return ok
+}
+
+// resumeStmtSummary forwards the StmtSummary from inCh to outCh, skipping the
+// ones that are finished in the previous run. After inCh is closed, the
+// unfinished StmtSummary of the previous run that are not received from inCh
+// are also forwarded, because they may be rotated out of the source. It closes
+// outCh at last.
+func resumeStmtSummary(
+	ctx context.Context,
+	p *previousRun,
+	inCh <-chan *source.StmtSummary,
+	outCh chan<- *source.StmtSummary,
+) error {
+	received := make(map[string]struct{}, len(p.unfinished))
+	send := func(s *source.StmtSummary) bool {
+		select {
+		case outCh <- s:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case s, ok := <-inCh:
+			if !ok {
+				for _, s2 := range p.unfinished {
+					if _, ok2 := received[s2.ID()]; ok2 {
+						continue
+					}
+					if !send(s2) {
+						return nil
+					}
+				}
+				close(outCh)
+				return nil
+			}
+			received[s.ID()] = struct{}{}
+			if p.isFinished(s) {
+				continue
+			}
+			if !send(s) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
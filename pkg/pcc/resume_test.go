@@ -0,0 +1,67 @@
+package pcc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeStmtSummary(t *testing.T) {
+	mgr := filemgr.NewManager(t.TempDir())
+	prev, err := loadPreviousRun(mgr)
+	require.NoError(t, err)
+	require.Empty(t, prev.finished)
+	require.Empty(t, prev.unfinished)
+
+	finished := &source.StmtSummary{SQLDigest: "finished"}
+	retryable := &source.StmtSummary{SQLDigest: "retryable"}
+	rotated := &source.StmtSummary{SQLDigest: "rotated"}
+	for _, s := range []*source.StmtSummary{finished, retryable, rotated} {
+		require.NoError(t, mgr.WriteStmtSummary(s))
+	}
+	finishedResult := &compare.PlanCmpResult{Result: compare.Same, OldVersionInfo: finished}
+	require.NoError(t, mgr.WriteResult(finishedResult))
+	// a retryable result is not final, normally it will not be written
+	require.NoError(t, mgr.WriteResult(&compare.PlanCmpResult{Result: compare.Unknown, OldVersionInfo: retryable}))
+
+	prev, err = loadPreviousRun(mgr)
+	require.NoError(t, err)
+	require.Equal(t, []*compare.PlanCmpResult{finishedResult}, prev.results())
+	require.ElementsMatch(t, []*source.StmtSummary{retryable, rotated}, prev.unfinished)
+
+	newCaptured := &source.StmtSummary{SQLDigest: "new"}
+	inCh := make(chan *source.StmtSummary, 8)
+	outCh := make(chan *source.StmtSummary, 8)
+	inCh <- finished
+	inCh <- retryable
+	inCh <- newCaptured
+	close(inCh)
+	require.NoError(t, resumeStmtSummary(context.Background(), prev, inCh, outCh))
+
+	got := make([]string, 0, 4)
+	for s := range outCh {
+		got = append(got, s.SQLDigest)
+	}
+	require.Equal(t, []string{"retryable", "new", "rotated"}, got)
+}
+
+func TestNextRound(t *testing.T) {
+	finished := &source.StmtSummary{SQLDigest: "s1", PlanDigest: "p1"}
+	retryable := &source.StmtSummary{SQLDigest: "s2", PlanDigest: "p2"}
+	finishedResult := &compare.PlanCmpResult{Result: compare.Same, OldVersionInfo: finished}
+	prev := newPreviousRun([]*compare.PlanCmpResult{
+		finishedResult,
+		{Result: compare.Unknown, OldVersionInfo: retryable},
+	})
+	require.Equal(t, []*compare.PlanCmpResult{finishedResult}, prev.results())
+	require.Equal(t, []*source.StmtSummary{retryable}, prev.unfinished)
+
+	// same digests captured in next round are finished
+	require.True(t, prev.isFinished(&source.StmtSummary{SQLDigest: "s1", PlanDigest: "p1"}))
+	require.False(t, prev.isFinished(retryable))
+	require.False(t, prev.isFinished(&source.StmtSummary{SQLDigest: "s1", PlanDigest: "p3"}))
+}
@@ -0,0 +1,406 @@
+package pcc
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/compare"
+	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
+	"github.com/lance6716/plan-change-capturer/pkg/report"
+	"github.com/lance6716/plan-change-capturer/pkg/schema"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// Below functions run one stage of Run, the stages exchange data through the
+// work directory. So user can capture from the old version cluster once, and
+// synchronize, compare and report on different new version clusters later.
+
+// Capture reads the statements from the old version cluster, and writes them
+// along with the needed structure and stats to the work directory.
+func Capture(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, true, false, capture)
+}
+
+// Sync creates the structure, stats and bindings written by Capture on the new
+// version cluster.
+func Sync(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, false, true, syncFromWorkDir)
+}
+
+// Compare gets the plans of the statements written by Capture on the new
+// version cluster, and writes the comparison results to the work directory. Sync
+// should be done before.
+func Compare(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, false, true, compareFromWorkDir)
+}
+
+// Report renders the report from the work directory without accessing any
+// cluster.
+func Report(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, false, false, reportFromWorkDir)
+}
+
+// Replay synchronizes, compares and reports in one pass from a work directory
+// written by Capture, which can be copied from another environment. It only
+// accesses the new version cluster, so the production data of the old version
+// cluster is not needed.
+func Replay(ctx context.Context, cfg *Config) error {
+	return entry(ctx, cfg, false, true, replay)
+}
+
+func capture(ctx context.Context, cfg *Config) error {
+	util.Logger.Info("start to capture", zap.Any("config", cfg))
+	start := time.Now()
+	oldDB, err := connectOldDB(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer oldDB.Close()
+	oldStatus, err := newStatusAPI(&cfg.OldVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	src := newSource(cfg, oldDB, mgr)
+	oldVars, err := source.ReadGlobalVariables(ctx, oldDB, cfg.SyncVariables)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resourceGroups, err := source.ReadResourceGroups(ctx, oldDB)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	oldCfg := &cfg.OldVersion
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	summCh := make(chan *source.StmtSummary, oldCfg.MaxConn)
+	eg.Go(func() error {
+		return captureStmtSummary(egCtx, src, mgr, summCh)
+	})
+
+	failedCnt := atomic.NewInt64(0)
+	localTemps := &localTempTables{}
+	runWorkers(egCtx, eg, oldCfg.MaxConn, summCh,
+		func(s *source.StmtSummary) error {
+			err2 := dumpForStmt(egCtx, s, oldDB, mgr, oldStatus, localTemps, cfg.SyncBackend)
+			if err2 != nil {
+				util.Logger.Error("dump structure and stats failed",
+					zap.String("sql_digest", s.SQLDigest),
+					zap.Error(err2))
+				failedCnt.Inc()
+			}
+			return nil
+		},
+		func() {},
+	)
+
+	meta := &filemgr.CaptureMeta{
+		StartTime:       start,
+		Endpoint:        net.JoinHostPort(oldCfg.Host, strconv.Itoa(oldCfg.Port)),
+		User:            oldCfg.User,
+		ClusterInfo:     readClusterInfo(egCtx, oldDB, "source"),
+		Coverage:        readCoverage(egCtx, oldDB, cfg),
+		DataSource:      src.Describe(),
+		FilteringRules:  cfg.Filter.Rules(),
+		GlobalVariables: oldVars,
+		ResourceGroups:  resourceGroups,
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	meta.EndTime = time.Now()
+	util.Logger.Info("capture finished", zap.Int64("dump_failed", failedCnt.Load()))
+	return errors.Trace(mgr.WriteCaptureMeta(meta))
+}
+
+func syncFromWorkDir(ctx context.Context, cfg *Config) error {
+	util.Logger.Info("start to sync", zap.Any("config", cfg))
+	newDB, err := connectNewDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer newDB.Close()
+
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	captureMeta, err := mgr.ReadCaptureMeta()
+	if err != nil {
+		return errors.Annotate(err, "failed to read capture metadata, please run capture first")
+	}
+	_, err = syncGlobalVariables(ctx, newDB, captureMeta.GlobalVariables, cfg.SyncVariables, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	syncer := schema.NewSyncer(newDB, cfg.OnSchemaMismatch, cfg.SchemaMapping, mgr)
+	syncResourceGroups(ctx, syncer, captureMeta.ResourceGroups)
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	summCh := make(chan *source.StmtSummary, cfg.NewVersion.MaxConn)
+	eg.Go(func() error {
+		return emitFromSource(egCtx, mgr.Source(), summCh)
+	})
+
+	failedCnt := atomic.NewInt64(0)
+	runWorkers(egCtx, eg, cfg.NewVersion.MaxConn, summCh,
+		func(s *source.StmtSummary) error {
+			err2 := restoreForStmt(egCtx, s, syncer, mgr, nil, cfg.LogicalTiFlash)
+			if err2 != nil {
+				util.Logger.Error("sync structure and stats failed",
+					zap.String("sql_digest", s.SQLDigest),
+					zap.Error(err2))
+				failedCnt.Inc()
+			}
+			return nil
+		},
+		func() {},
+	)
+
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	util.Logger.Info("sync finished", zap.Int64("failed", failedCnt.Load()))
+	return nil
+}
+
+func compareFromWorkDir(ctx context.Context, cfg *Config) error {
+	util.Logger.Info("start to compare", zap.Any("config", cfg))
+	start := time.Now()
+	newDB, err := connectNewDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer newDB.Close()
+
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	prev, err := loadPreviousRun(mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	varDiffs, err := syncGlobalVariablesFromWorkDir(ctx, newDB, cfg, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// only used to load the PLAN REPLAYER dumps again, whose session variables
+	// are needed by EXPLAIN
+	syncer := schema.NewSyncer(newDB, cfg.OnSchemaMismatch, cfg.SchemaMapping, mgr)
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	emittedCh := make(chan *source.StmtSummary, cfg.NewVersion.MaxConn)
+	eg.Go(func() error {
+		return emitFromSource(egCtx, mgr.Source(), emittedCh)
+	})
+	summCh := make(chan *source.StmtSummary, cfg.NewVersion.MaxConn)
+	timedOut := atomic.NewBool(false)
+	eg.Go(func() error {
+		return limitDispatch(egCtx, cfg.deadline(start), emittedCh, summCh, timedOut)
+	})
+
+	runWorkers(egCtx, eg, cfg.NewVersion.MaxConn, summCh,
+		func(s *source.StmtSummary) error {
+			if prev.isFinished(s) {
+				return nil
+			}
+			result := cmpWithTimeLimit(egCtx, cfg.PerSQLTimeLimit,
+				func(ctx context.Context) *compare.PlanCmpResult {
+					if mgr.HasPlanReplayer(s) {
+						return replayPlan(ctx, s, newDB, syncer, mgr, newPlanCmpResult(s), cfg)
+					}
+					return explainAndCmp(ctx, s, newDB, nil, mgr, newPlanCmpResult(s), cfg)
+				})
+			if !isFinalResult(result) {
+				return nil
+			}
+			return errors.Trace(mgr.WriteResult(result))
+		},
+		func() {},
+	)
+
+	meta := &filemgr.CompareMeta{
+		StartTime:       start,
+		ClusterInfo:     readClusterInfo(egCtx, newDB, "target"),
+		GlobalTimeLimit: cfg.GlobalTimeLimit,
+		PerSQLTimeLimit: cfg.PerSQLTimeLimit,
+		VariableDiffs:   varDiffs,
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	meta.EndTime = time.Now()
+	meta.TimedOut = timedOut.Load()
+	return errors.Trace(mgr.WriteCompareMeta(meta))
+}
+
+func replay(ctx context.Context, cfg *Config) error {
+	util.Logger.Info("start to replay", zap.Any("config", cfg))
+	start := time.Now()
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	captureMeta, err := mgr.ReadCaptureMeta()
+	if err != nil {
+		return errors.Annotate(err, "failed to read capture metadata, is the work directory written by capture?")
+	}
+	prev, err := loadPreviousRun(mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	newDB, err := connectNewDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer newDB.Close()
+	varDiffs, err := syncGlobalVariables(ctx, newDB, captureMeta.GlobalVariables, cfg.SyncVariables, mgr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	syncer := schema.NewSyncer(newDB, cfg.OnSchemaMismatch, cfg.SchemaMapping, mgr)
+	syncResourceGroups(ctx, syncer, captureMeta.ResourceGroups)
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	maxConn := cfg.NewVersion.MaxConn
+	emittedCh := make(chan *source.StmtSummary, maxConn)
+	eg.Go(func() error {
+		return emitFromSource(egCtx, mgr.Source(), emittedCh)
+	})
+	summCh := make(chan *source.StmtSummary, maxConn)
+	timedOut := atomic.NewBool(false)
+	eg.Go(func() error {
+		return limitDispatch(egCtx, cfg.deadline(start), emittedCh, summCh, timedOut)
+	})
+
+	allResults := prev.results()
+	replayStmts(egCtx, eg, maxConn, summCh, mgr, &allResults,
+		func(s *source.StmtSummary) *compare.PlanCmpResult {
+			if prev.isFinished(s) {
+				return nil
+			}
+			return cmpWithTimeLimit(egCtx, cfg.PerSQLTimeLimit,
+				func(ctx context.Context) *compare.PlanCmpResult {
+					return replayPlan(ctx, s, newDB, syncer, mgr, newPlanCmpResult(s), cfg)
+				})
+		})
+
+	compareMeta := &filemgr.CompareMeta{
+		StartTime:       start,
+		ClusterInfo:     readClusterInfo(egCtx, newDB, "target"),
+		GlobalTimeLimit: cfg.GlobalTimeLimit,
+		PerSQLTimeLimit: cfg.PerSQLTimeLimit,
+		VariableDiffs:   varDiffs,
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	compareMeta.EndTime = time.Now()
+	compareMeta.TimedOut = timedOut.Load()
+	if err = mgr.WriteCompareMeta(compareMeta); err != nil {
+		return errors.Trace(err)
+	}
+
+	r, err := processResults(allResults, cfg, captureMeta, compareMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(report.Render(r, mgr.GetReportPath()))
+}
+
+// replayStmts starts maxConn workers in eg to call fn on every StmtSummary
+// received from summCh, and a goroutine to collect the non-nil results into
+// allResults.
+func replayStmts(
+	egCtx context.Context,
+	eg *errgroup.Group,
+	maxConn int,
+	summCh <-chan *source.StmtSummary,
+	mgr *filemgr.Manager,
+	allResults *[]*compare.PlanCmpResult,
+	fn func(*source.StmtSummary) *compare.PlanCmpResult,
+) {
+	resultCh := make(chan *compare.PlanCmpResult, maxConn)
+	runWorkers(egCtx, eg, maxConn, summCh,
+		func(s *source.StmtSummary) error {
+			r := fn(s)
+			if r == nil {
+				return nil
+			}
+			select {
+			case resultCh <- r:
+				return nil
+			case <-egCtx.Done():
+				return nil
+			}
+		},
+		func() { close(resultCh) },
+	)
+	eg.Go(func() error {
+		return collectResults(egCtx, mgr, resultCh, allResults)
+	})
+}
+
+func reportFromWorkDir(_ context.Context, cfg *Config) error {
+	mgr := filemgr.NewManager(cfg.WorkDir)
+	captureMeta, err := mgr.ReadCaptureMeta()
+	if err != nil {
+		return errors.Annotate(err, "failed to read capture metadata, please run capture first")
+	}
+	compareMeta, err := mgr.ReadCompareMeta()
+	if err != nil {
+		return errors.Annotate(err, "failed to read compare metadata, please run compare first")
+	}
+	summaries, err := mgr.ReadStmtSummaries()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	results, err := mgr.ReadResults()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	r, err := processResults(mergeResults(summaries, results), cfg, captureMeta, compareMeta)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(report.Render(r, mgr.GetReportPath()))
+}
+
+// syncGlobalVariablesFromWorkDir is like syncGlobalVariables, the global
+// variables of the old version cluster are read from the capture metadata.
+func syncGlobalVariablesFromWorkDir(
+	ctx context.Context,
+	newDB *sql.DB,
+	cfg *Config,
+	mgr *filemgr.Manager,
+) ([]*filemgr.VariableDiff, error) {
+	captureMeta, err := mgr.ReadCaptureMeta()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read capture metadata, please run capture first")
+	}
+	return syncGlobalVariables(ctx, newDB, captureMeta.GlobalVariables, cfg.SyncVariables, mgr)
+}
+
+// mergeResults returns all results, and for the statement summaries that don't
+// have a result, a result waiting for retry is added.
+func mergeResults(
+	summaries []*source.StmtSummary,
+	results []*compare.PlanCmpResult,
+) []*compare.PlanCmpResult {
+	ret := make([]*compare.PlanCmpResult, 0, max(len(summaries), len(results)))
+	hasResult := make(map[string]struct{}, len(results))
+	for _, r := range results {
+		hasResult[r.OldVersionInfo.ID()] = struct{}{}
+		ret = append(ret, r)
+	}
+	for _, s := range summaries {
+		if _, ok := hasResult[s.ID()]; ok {
+			continue
+		}
+		ret = append(ret, newPlanCmpResult(s))
+	}
+	return ret
+}
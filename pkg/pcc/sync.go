@@ -3,9 +3,14 @@ package pcc
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lance6716/plan-change-capturer/pkg/filemgr"
@@ -14,13 +19,83 @@ import (
 	"github.com/lance6716/plan-change-capturer/pkg/util"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"go.uber.org/zap"
 )
 
-func syncForDB(
+// statusAPI is used to access the status port of TiDB.
+type statusAPI struct {
+	client *http.Client
+	// url contains the scheme, like "http://127.0.0.1:10080"
+	url string
+}
+
+func newStatusAPI(cfg *TiDB) (*statusAPI, error) {
+	tlsConfig, err := cfg.Security.tlsConfig()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	return &statusAPI{
+		client: util.NewHTTPClient(tlsConfig),
+		url:    scheme + "://" + net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.StatusPort)),
+	}, nil
+}
+
+// dumpForStmt reads the structure and stats of the database and tables needed
+// by the StmtSummary from the old version cluster, and writes them to the work
+// directory. When backend is SyncBackendPlanReplayer, they are read by PLAN
+// REPLAYER DUMP instead. Nothing is needed by an unsupported StmtSummary, and
+// the StmtSummary is marked unsupported if it needs an object that can't be
+// reproduced. localTemps should be shared during one sync.
+func dumpForStmt(
+	ctx context.Context,
+	s *source.StmtSummary,
+	oldDB *sql.DB,
+	mgr *filemgr.Manager,
+	status *statusAPI,
+	localTemps *localTempTables,
+	backend string,
+) error {
+	if s.Unsupported != "" {
+		return nil
+	}
+	if backend == SyncBackendPlanReplayer {
+		zip, err := source.ReadPlanReplayerDump(ctx, oldDB, status.client, status.url, s.Schema, s.SQL)
+		if err != nil {
+			if merr, ok := errors.Cause(err).(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
+				err = util.WrapUnretryableError(err)
+			}
+			return errors.Trace(err)
+		}
+		return errors.Trace(mgr.WritePlanReplayer(s, zip))
+	}
+	if err := dumpForDB(ctx, oldDB, s.Schema, mgr); err != nil {
+		return errors.Trace(err)
+	}
+	for _, table := range s.TableNamesNeedToSync {
+		err := dumpForTable(ctx, oldDB, table, mgr, status, localTemps)
+		if uerr, ok := errors.Cause(err).(*unsupportedError); ok {
+			util.Logger.Warn("statement is not supported",
+				zap.String("sql_digest", s.SQLDigest),
+				zap.String("reason", uerr.reason))
+			s.Unsupported = uerr.reason
+			return errors.Trace(mgr.WriteStmtSummary(s))
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func dumpForDB(
 	ctx context.Context,
 	oldDB *sql.DB,
 	dbName string,
-	syncer *schema.Syncer,
 	mgr *filemgr.Manager,
 ) error {
 	if dbName == "" {
@@ -38,95 +113,490 @@ func syncForDB(
 		}
 		return errors.Trace(err2)
 	}
-	err2 = mgr.WriteDatabaseStructure(dbName, createDatabase)
-	if err2 != nil {
-		return errors.Trace(err2)
+	if err := dumpPlacementPolicies(ctx, oldDB, createDatabase, mgr); err != nil {
+		return errors.Trace(err)
 	}
-
-	err2 = syncer.CreateDatabase(ctx, dbName, createDatabase)
-	if err2 != nil {
-		return errors.Trace(err2)
-	}
-
-	return nil
+	return errors.Trace(mgr.WriteDatabaseStructure(dbName, createDatabase))
 }
 
-// TODO(lance6716): test sync user TEMPORARY, CACHE (plan will be different if
-// not ALTER CACHE) table
-func syncForTable(
+// dumpForTable writes the structure, stats and TableOptions of the table and
+// its dependent tables. It returns unsupportedError if the table can't be
+// reproduced on the new version cluster.
+func dumpForTable(
 	ctx context.Context,
 	oldDB *sql.DB,
 	table [2]string,
-	currDB string,
-	syncer *schema.Syncer,
 	mgr *filemgr.Manager,
-	oldCfg *TiDB,
+	status *statusAPI,
+	localTemps *localTempTables,
 ) error {
-	createDatabase, err2 := util.ReadCreateDatabase(ctx, oldDB, table[0])
-	if err2 != nil {
-		if merr, ok := err2.(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
-			err2 = util.WrapUnretryableError(err2)
-		}
-		return errors.Trace(err2)
+	if err := dumpForDB(ctx, oldDB, table[0], mgr); err != nil {
+		return errors.Trace(err)
 	}
-	err2 = mgr.WriteDatabaseStructure(table[0], createDatabase)
-	if err2 != nil {
-		return errors.Trace(err2)
+
+	opts := &filemgr.TableOptions{}
+	if !util.IsMemOrSysTable(table) {
+		tableStatus, err := util.ReadTableStatus(ctx, oldDB, table[0], table[1])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !tableStatus.Exists {
+			return missingTableError(ctx, oldDB, table, localTemps)
+		}
+		opts.Cached = tableStatus.Cached
+		opts.TiFlashReplica, err = util.ReadTiFlashReplicaCount(ctx, oldDB, table[0], table[1])
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
 
 	createTable, err2 := util.ReadCreateTableViewSeq(ctx, oldDB, table[0], table[1])
 	if err2 != nil {
 		return errors.Trace(err2)
 	}
+	if err := dumpPlacementPolicies(ctx, oldDB, createTable, mgr); err != nil {
+		return errors.Trace(err)
+	}
 	err2 = mgr.WriteTableStructure(table[0], table[1], createTable)
 	if err2 != nil {
 		return errors.Trace(err2)
 	}
+	if err2 = mgr.WriteTableOptions(table[0], table[1], opts); err2 != nil {
+		return errors.Trace(err2)
+	}
 
+	tableNames, err := dependentTables(createTable, table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, t := range tableNames {
+		err = dumpForTable(ctx, oldDB, t, mgr, status, localTemps)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// a global temporary table has no stats, and its data is only visible to
+	// the transaction
+	if isGlobalTemporaryTable(createTable) {
+		return errors.Trace(mgr.WriteTableStats(table[0], table[1], "null"))
+	}
+	tableStats, err2 := source.ReadTableStats(ctx, status.client, status.url, table[0], table[1])
+	if err2 != nil {
+		return errors.Trace(err2)
+	}
+	return errors.Trace(mgr.WriteTableStats(table[0], table[1], tableStats))
+}
+
+// missingTableError returns the error for the table that is not found. It's an
+// unsupportedError only if the table is created as a local temporary table,
+// otherwise the table may be dropped.
+func missingTableError(
+	ctx context.Context,
+	oldDB *sql.DB,
+	table [2]string,
+	localTemps *localTempTables,
+) error {
+	name := util.EscapeIdentifier(table[0]) + "." + util.EscapeIdentifier(table[1])
+	local, err := localTemps.contains(ctx, oldDB, table)
+	if err != nil {
+		return errors.Annotatef(err, "table %s is not found", name)
+	}
+	if local {
+		return &unsupportedError{reason: fmt.Sprintf(
+			"table %s is not found, it's created as a local temporary table", name,
+		)}
+	}
+	return util.WrapUnretryableError(errors.Errorf("table %s is not found in the old version cluster", name))
+}
+
+// localTempTables caches source.ReadLocalTemporaryTables during one sync,
+// because it scans the whole statement summary. It's read when the first
+// missing table is met, and read again if it fails.
+type localTempTables struct {
+	mu     sync.Mutex
+	tables map[[2]string]struct{}
+}
+
+func (l *localTempTables) contains(ctx context.Context, oldDB *sql.DB, table [2]string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tables == nil {
+		tables, err := source.ReadLocalTemporaryTables(ctx, oldDB)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		l.tables = tables
+	}
+	_, ok := l.tables[[2]string{strings.ToLower(table[0]), strings.ToLower(table[1])}]
+	return ok, nil
+}
+
+// unsupportedError means the StmtSummary needs an object that can't be
+// reproduced on the new version cluster. dumpForStmt records the reason in
+// source.StmtSummary.Unsupported.
+type unsupportedError struct {
+	reason string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.reason
+}
+
+// dumpPlacementPolicies writes the placement policies used by the CREATE
+// DATABASE / TABLE statement.
+func dumpPlacementPolicies(
+	ctx context.Context,
+	oldDB *sql.DB,
+	createStmt string,
+	mgr *filemgr.Manager,
+) error {
+	policies, err := placementPolicies(createStmt)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, policy := range policies {
+		createPolicy, err2 := util.ReadCreatePlacementPolicy(ctx, oldDB, policy)
+		if err2 != nil {
+			return errors.Trace(err2)
+		}
+		if err2 = mgr.WritePlacementPolicy(policy, createPolicy); err2 != nil {
+			return errors.Trace(err2)
+		}
+	}
+	return nil
+}
+
+// placementPolicies returns the placement policies used by the CREATE DATABASE
+// / TABLE statement.
+func placementPolicies(createStmt string) ([]string, error) {
+	p := util.ParserPool.Get().(*parser.Parser)
+	stmt, err := p.ParseOneStmt(createStmt, "", "")
+	util.ParserPool.Put(p)
+	if err != nil {
+		return nil, util.WrapUnretryableError(
+			errors.Annotatef(err, "parse create statement %s", createStmt),
+		)
+	}
+	return util.ExtractPlacementPolicies(stmt), nil
+}
+
+// isGlobalTemporaryTable checks the SHOW CREATE TABLE result, because
+// INFORMATION_SCHEMA.TABLES doesn't show the temporary type.
+func isGlobalTemporaryTable(createTable string) bool {
 	p := util.ParserPool.Get().(*parser.Parser)
 	stmt, err := p.ParseOneStmt(createTable, "", "")
 	util.ParserPool.Put(p)
 	if err != nil {
-		return errors.Annotatef(err, "parse create table statement for %s.%s", table[0], table[1])
+		return false
+	}
+	create, ok := stmt.(*ast.CreateTableStmt)
+	return ok && create.TemporaryKeyword == ast.TemporaryGlobal
+}
+
+// dependentTables returns the tables referenced by the CREATE TABLE / VIEW /
+// SEQUENCE statement of `table`, excluding `table` itself.
+func dependentTables(createTable string, table [2]string) ([][2]string, error) {
+	p := util.ParserPool.Get().(*parser.Parser)
+	stmt, err := p.ParseOneStmt(createTable, "", "")
+	util.ParserPool.Put(p)
+	if err != nil {
+		return nil, util.WrapUnretryableError(
+			errors.Annotatef(err, "parse create table statement for %s.%s", table[0], table[1]),
+		)
 	}
 	tableNames := util.ExtractTableNames(stmt, table[0])
+	ret := make([][2]string, 0, len(tableNames))
 	for _, t := range tableNames {
 		if t == table {
 			continue
 		}
-		err = syncForTable(ctx, oldDB, t, currDB, syncer, mgr, oldCfg)
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+// restoreForStmt creates the database, tables, stats and binding needed by the
+// StmtSummary on the new version cluster, using the files written by
+// dumpForStmt. If the PLAN REPLAYER dump is written, it's loaded instead.
+// Nothing is needed by an unsupported StmtSummary. When logicalTiFlash is true,
+// the TiFlash replicas are not created, see Config.LogicalTiFlash. conn is used
+// to load the PLAN REPLAYER dump, see Syncer.LoadPlanReplayer.
+func restoreForStmt(
+	ctx context.Context,
+	s *source.StmtSummary,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+	conn *sql.Conn,
+	logicalTiFlash bool,
+) error {
+	if s.Unsupported != "" {
+		return nil
+	}
+	if mgr.HasPlanReplayer(s) {
+		return errors.Annotate(
+			syncer.LoadPlanReplayer(ctx, conn, mgr.GetPlanReplayerPath(s)),
+			"sync by plan replayer failed",
+		)
+	}
+	if err := restoreForDB(ctx, s.Schema, syncer, mgr); err != nil {
+		return errors.Annotate(err, "sync database failed")
+	}
+	for _, table := range s.TableNamesNeedToSync {
+		if err := restoreForTable(ctx, table, syncer, mgr, logicalTiFlash); err != nil {
+			return errors.Annotate(err, "sync table failed")
+		}
+	}
+	if s.Binding.BindSQL != "" {
+		if err := syncer.CreateBinding(ctx, s.BindingDigest, s.Binding); err != nil {
+			return errors.Annotate(err, "sync binding failed")
+		}
+	}
+	return nil
+}
+
+func restoreForDB(
+	ctx context.Context,
+	dbName string,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+) error {
+	if dbName == "" {
+		return nil
+	}
+	if util.IsMemOrSysTable([2]string{dbName, ""}) {
+		return nil
+	}
+
+	createDatabase, err := mgr.ReadDatabaseStructure(dbName)
+	if err != nil {
+		// the work directory is not changed by other process, so it's not
+		// retryable
+		return util.WrapUnretryableError(err)
+	}
+	if err = restorePlacementPolicies(ctx, createDatabase, syncer, mgr); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(syncer.CreateDatabase(ctx, dbName, createDatabase))
+}
+
+func restoreForTable(
+	ctx context.Context,
+	table [2]string,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+	logicalTiFlash bool,
+) error {
+	if err := restoreForDB(ctx, table[0], syncer, mgr); err != nil {
+		return errors.Trace(err)
+	}
+
+	createTable, err := mgr.ReadTableStructure(table[0], table[1])
+	if err != nil {
+		return util.WrapUnretryableError(err)
+	}
+	tableNames, err := dependentTables(createTable, table)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, t := range tableNames {
+		err = restoreForTable(ctx, t, syncer, mgr, logicalTiFlash)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	}
 
-	tableStats, err2 := source.ReadTableStats(
-		ctx,
-		http.DefaultClient,
-		net.JoinHostPort(oldCfg.Host, strconv.Itoa(oldCfg.StatusPort)),
-		table[0],
-		table[1],
-	)
-	if err2 != nil {
-		return errors.Trace(err2)
+	if err = restorePlacementPolicies(ctx, createTable, syncer, mgr); err != nil {
+		return errors.Trace(err)
 	}
-	err2 = mgr.WriteTableStats(table[0], table[1], tableStats)
-	if err2 != nil {
-		return errors.Trace(err2)
+	err = syncer.CreateTable(ctx, table[0], table[1], createTable)
+	if err != nil {
+		return errors.Trace(err)
 	}
-
-	err2 = syncer.CreateDatabase(ctx, table[0], createDatabase)
-	if err2 != nil {
-		return errors.Trace(err2)
+	err = syncer.LoadStats(ctx, table[0], table[1], mgr.GetTableStatsPath(table[0], table[1]))
+	if err != nil {
+		return errors.Trace(err)
 	}
-	err2 = syncer.CreateTable(ctx, table[0], table[1], createTable)
-	if err2 != nil {
-		return errors.Trace(err2)
+	opts, err := mgr.ReadTableOptions(table[0], table[1])
+	if err != nil {
+		return util.WrapUnretryableError(err)
 	}
-	err2 = syncer.LoadStats(ctx, mgr.GetTableStatsPath(table[0], table[1]))
-	if err2 != nil {
-		return errors.Trace(err2)
+	if opts.TiFlashReplica > 0 && !logicalTiFlash {
+		err = syncer.SetTiFlashReplica(ctx, table[0], table[1], opts.TiFlashReplica)
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
+	if opts.Cached {
+		return errors.Trace(syncer.CacheTable(ctx, table[0], table[1]))
+	}
+	return nil
+}
 
+// restorePlacementPolicies creates the placement policies used by the CREATE
+// DATABASE / TABLE statement, using the files written by dumpPlacementPolicies.
+func restorePlacementPolicies(
+	ctx context.Context,
+	createStmt string,
+	syncer *schema.Syncer,
+	mgr *filemgr.Manager,
+) error {
+	policies, err := placementPolicies(createStmt)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, policy := range policies {
+		createPolicy, err2 := mgr.ReadPlacementPolicy(policy)
+		if err2 != nil {
+			return util.WrapUnretryableError(err2)
+		}
+		if err2 = syncer.CreatePlacementPolicy(ctx, policy, createPolicy); err2 != nil {
+			return errors.Annotate(err2, "sync placement policy failed")
+		}
+	}
 	return nil
 }
+
+// tiflashTables returns the tables needed by the StmtSummary that have TiFlash
+// replicas in the old version cluster, including the ones referenced by views.
+func tiflashTables(s *source.StmtSummary, mgr *filemgr.Manager) ([][2]string, error) {
+	var ret [][2]string
+	visited := make(map[[2]string]struct{}, len(s.TableNamesNeedToSync))
+	var visit func(table [2]string) error
+	visit = func(table [2]string) error {
+		if _, ok := visited[table]; ok {
+			return nil
+		}
+		visited[table] = struct{}{}
+
+		opts, err := mgr.ReadTableOptions(table[0], table[1])
+		if err != nil {
+			return util.WrapUnretryableError(err)
+		}
+		if opts.TiFlashReplica > 0 {
+			ret = append(ret, table)
+		}
+		createTable, err := mgr.ReadTableStructure(table[0], table[1])
+		if err != nil {
+			return util.WrapUnretryableError(err)
+		}
+		tableNames, err := dependentTables(createTable, table)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, t := range tableNames {
+			if err = visit(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, table := range s.TableNamesNeedToSync {
+		if err := visit(table); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return ret, nil
+}
+
+// syncResourceGroups creates the resource groups of the old version cluster on
+// the new version cluster. The failure is only logged because the resource
+// groups rarely affect the plans.
+func syncResourceGroups(
+	ctx context.Context,
+	syncer *schema.Syncer,
+	groups map[string]string,
+) {
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		if err := syncer.CreateResourceGroup(ctx, name, groups[name]); err != nil {
+			util.Logger.Warn("failed to synchronize resource group",
+				zap.String("name", name),
+				zap.Error(err))
+		}
+	}
+}
+
+// syncGlobalVariables sets the global variables of the new version cluster to
+// oldVars, and returns the variables different between the clusters. The value
+// of the new version cluster before the first synchronization is remembered in
+// the work directory, so the differences are still reported by later stages.
+//
+// The global variables only take effect on the new sessions, so it should be
+// called before newDB opens any session to compare, and the session used by it
+// is discarded.
+func syncGlobalVariables(
+	ctx context.Context,
+	newDB *sql.DB,
+	oldVars map[string]string,
+	patterns []string,
+	mgr *filemgr.Manager,
+) ([]*filemgr.VariableDiff, error) {
+	conn, err := newDB.Conn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer util.DiscardConn(conn)
+	newVars, err := source.ReadGlobalVariables(ctx, conn, patterns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	prevDiffs, err := mgr.ReadVariableDiffs()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	originalNew := make(map[string]string, len(prevDiffs))
+	for _, d := range prevDiffs {
+		originalNew[d.Name] = d.New
+	}
+
+	diffs := make([]*filemgr.VariableDiff, 0, len(oldVars))
+	for _, name := range slices.Sorted(maps.Keys(oldVars)) {
+		// it's always disabled on the new version cluster, see connectNewDB
+		if name == "tidb_enable_auto_analyze" {
+			continue
+		}
+		oldValue := oldVars[name]
+		newValue, exists := newVars[name]
+		d := &filemgr.VariableDiff{Name: name, Old: oldValue, New: newValue}
+		if v, ok := originalNew[name]; ok {
+			d.New = v
+		}
+		if d.Old == d.New {
+			continue
+		}
+		diffs = append(diffs, d)
+		if !exists {
+			util.Logger.Warn("global variable does not exist in new version cluster",
+				zap.String("name", name))
+			continue
+		}
+		if newValue == oldValue {
+			d.Synced = true
+			continue
+		}
+		stmt, err := util.SetGlobalVariableSQL(name, oldValue)
+		if err != nil {
+			util.Logger.Warn("failed to synchronize global variable", zap.Error(err))
+			continue
+		}
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			util.Logger.Warn("failed to synchronize global variable",
+				zap.String("sql", stmt),
+				zap.Error(err))
+			continue
+		}
+		d.Synced = true
+		err = mgr.AppendJournal(&schema.JournalEntry{
+			Kind:  schema.JournalGlobalVariable,
+			Name:  name,
+			Value: newValue,
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err = mgr.WriteVariableDiffs(diffs); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return diffs, nil
+}
@@ -3,6 +3,7 @@ package plan
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"slices"
 	"strings"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/pingcap/tidb/pkg/util/texttree"
 )
 
+const accessObjectCol = "access object"
+
 // newPlanFromSQLResultRow parses the result from SQL query into an Op tree. It
 // also returns a string representing the plan tree.
 //
@@ -96,6 +99,10 @@ func NewPlanFromStmtSummaryPlan(planStr string) (*Op, string, error) {
 		return nil, "", errors.Errorf("column `task` not found in the header: %s", lines[0])
 	}
 	opInfoColIdx := slices.Index(columnNames, "operator info")
+	if opInfoColIdx == -1 {
+		// written by ExplainAsStmtSummaryPlan
+		opInfoColIdx = slices.Index(columnNames, accessObjectCol)
+	}
 	if opInfoColIdx == -1 {
 		return nil, "", errors.Errorf("column `operator info` not found in the header: %s", lines[0])
 	}
@@ -123,55 +130,139 @@ func NewPlanFromStmtSummaryPlan(planStr string) (*Op, string, error) {
 	return op, planStr, nil
 }
 
+// ExplainOptions changes the session that runs EXPLAIN.
+type ExplainOptions struct {
+	// WithoutBinding disables the bindings to generate the plan.
+	WithoutBinding bool
+	// HypoTiFlashReplicas are the tables considered to have TiFlash replicas by
+	// the optimizer, though the replicas are not created.
+	HypoTiFlashReplicas [][2]string
+	// Conn is the session to run EXPLAIN, like the one that loads a PLAN REPLAYER
+	// dump. A connection of the database is used if it's nil.
+	Conn *sql.Conn
+}
+
 func NewPlanFromQuery(
 	ctx context.Context,
 	db *sql.DB,
 	dbName string,
 	query string,
+	opts ExplainOptions,
 ) (*Op, string, error) {
-	conn, err := db.Conn(ctx)
+	result, err := explain(ctx, db, dbName, query, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	op, planStr, err := newPlanFromSQLResultRow(result)
+	if err != nil {
+		return nil, "", util.WrapUnretryableError(
+			errors.Annotatef(err, "failed to create plan for database: %s, query: %s", dbName, query),
+		)
+	}
+	return op, planStr, nil
+}
+
+// ExplainAsStmtSummaryPlan runs EXPLAIN for the query and formats the result
+// like the PLAN column of statement summary, so it can be stored as
+// source.StmtSummary.PlanStr and parsed by NewPlanFromStmtSummaryPlan. It's used
+// when the statement has no recorded plan.
+func ExplainAsStmtSummaryPlan(
+	ctx context.Context,
+	db *sql.DB,
+	dbName string,
+	query string,
+) (string, error) {
+	result, err := explain(ctx, db, dbName, query, ExplainOptions{})
 	if err != nil {
-		return nil, "", errors.Annotatef(err, "failed to get connection for database: %s, query: %s", dbName, query)
+		return "", err
+	}
+	lines := make([]string, 0, len(result)+1)
+	lines = append(lines, "\tid\ttask\t"+accessObjectCol)
+	for _, fields := range result {
+		lines = append(lines, "\t"+strings.Join(fields[:], "\t"))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// explain returns the [id, task, access object] fields of the EXPLAIN result.
+// The session is changed by opts during EXPLAIN.
+func explain(
+	ctx context.Context,
+	db *sql.DB,
+	dbName string,
+	query string,
+	opts ExplainOptions,
+) ([][3]string, error) {
+	var err error
+	conn := opts.Conn
+	if conn == nil {
+		conn, err = db.Conn(ctx)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to get connection for database: %s, query: %s", dbName, query)
+		}
+		defer conn.Close()
+	}
+
+	if opts.WithoutBinding {
+		_, err = conn.ExecContext(ctx, "SET @@session.tidb_use_plan_baselines = OFF")
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to disable binding for database: %s, query: %s", dbName, query)
+		}
+		defer func() {
+			_, err2 := conn.ExecContext(context.Background(), "SET @@session.tidb_use_plan_baselines = DEFAULT")
+			if err2 != nil {
+				// don't put the connection back to the pool
+				_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+		}()
 	}
-	defer conn.Close()
 
 	if dbName != "" {
-		_, err = conn.ExecContext(ctx, "USE "+dbName)
+		_, err = conn.ExecContext(ctx, "USE "+util.EscapeIdentifier(dbName))
 		if err != nil {
-			return nil, "", errors.Annotatef(err, "failed to execute USE for database: %s, query: %s", dbName, query)
+			return nil, errors.Annotatef(err, "failed to execute USE for database: %s, query: %s", dbName, query)
 		}
 	}
 
+	for _, t := range opts.HypoTiFlashReplicas {
+		stmt := "ALTER TABLE " + util.EscapeIdentifier(t[0]) + "." + util.EscapeIdentifier(t[1]) + " SET HYPO TIFLASH REPLICA "
+		_, err = conn.ExecContext(ctx, stmt+"1")
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to set hypothetical TiFlash replica for database: %s, query: %s", dbName, query)
+		}
+		defer func() {
+			_, err2 := conn.ExecContext(context.Background(), stmt+"0")
+			if err2 != nil {
+				// don't put the connection back to the pool
+				_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+		}()
+	}
+
 	rows, err := conn.QueryContext(ctx, "EXPLAIN "+query)
 	if err != nil {
-		return nil, "", errors.Annotatef(err, "failed to execute EXPLAIN for database: %s, query: %s", dbName, query)
+		return nil, errors.Annotatef(err, "failed to execute EXPLAIN for database: %s, query: %s", dbName, query)
 	}
 	defer rows.Close()
 
-	fields, allFound, err := util.ReadStrRowsByColumnName(rows, []string{"id", "task", "access object"})
+	fields, allFound, err := util.ReadStrRowsByColumnName(rows, []string{"id", "task", accessObjectCol})
 	if err != nil {
-		return nil, "", errors.Annotatef(err, "failed to read rows for database: %s, query: %s", dbName, query)
+		return nil, errors.Annotatef(err, "failed to read rows for database: %s, query: %s", dbName, query)
 	}
 	if !allFound {
 		columnNames, err2 := rows.Columns()
 		if err2 != nil {
-			return nil, "", errors.Annotatef(err2, "failed to get columns for database: %s, query: %s", dbName, query)
+			return nil, errors.Annotatef(err2, "failed to get columns for database: %s, query: %s", dbName, query)
 		}
-		return nil, "", errors.Errorf("not all columns are found in the result. we need [id, task, access object], but got %v", columnNames)
+		return nil, errors.Errorf("not all columns are found in the result. we need [id, task, access object], but got %v", columnNames)
 	}
 	if err = rows.Close(); err != nil {
-		return nil, "", errors.Annotatef(err, "failed to close rows for database: %s, query: %s", dbName, query)
+		return nil, errors.Annotatef(err, "failed to close rows for database: %s, query: %s", dbName, query)
 	}
 
 	result := make([][3]string, 0, len(fields))
 	for _, field := range fields {
 		result = append(result, [3]string{field[0], field[1], field[2]})
 	}
-	op, planStr, err := newPlanFromSQLResultRow(result)
-	if err != nil {
-		return nil, "", util.WrapUnretryableError(
-			errors.Annotatef(err, "failed to create plan for database: %s, query: %s", dbName, query),
-		)
-	}
-	return op, planStr, nil
+	return result, nil
 }
@@ -1,10 +1,12 @@
 package plan
 
 import (
+	"context"
 	"slices"
 	"strings"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,7 +49,7 @@ HashJoin_23	15609.38	root		inner join, equal:[eq(test.t1.c1, test.t3.c1)], other
     └─IndexFullScan_41	9990.00	cop[tikv]	table:t2, index:idx(c2)	keep order:false, stats:pseudo`
 	result := parseBatchModeResult(t, sqlResult)
 
-	p, err := newPlanFromSQLResultRow(result)
+	p, _, err := newPlanFromSQLResultRow(result)
 	require.NoError(t, err)
 
 	expected := &Op{
@@ -99,7 +101,7 @@ HashJoin_23	15609.38	root		inner join, equal:[eq(test.t1.c1, test.t3.c1)], other
 func TestFromStmtSummaryPlan(t *testing.T) {
 	// This is a real example from plan-change-capturer/stmt-summary file.
 	input := "\tid                  \ttask     \testRows\toperator info                                                                                                                                                                                                                                                                                                                                                                                             \tactRows\texecution info                                                                                                                                                            \tmemory \tdisk\n\tProjection_4        \troot     \t3333.33\tinformation_schema.cluster_statements_summary_history.schema_name, information_schema.cluster_statements_summary_history.query_sample_text, information_schema.cluster_statements_summary_history.table_names, information_schema.cluster_statements_summary_history.plan, information_schema.cluster_statements_summary_history.digest, information_schema.cluster_statements_summary_history.plan_digest\t4      \ttime:1.38ms, loops:2, Concurrency:5                                                                                                                                       \t47.1 KB\tN/A\n\t└─TableReader_7     \troot     \t3333.33\tdata:Selection_6                                                                                                                                                                                                                                                                                                                                                                                          \t4      \ttime:1.32ms, loops:2, cop_task: {num: 1, max: 1.27ms, proc_keys: 0, copr_cache_hit_ratio: 0.00, max_distsql_concurrency: 1}, rpc_info:{Cop:{num_rpc:1, total_time:1.25ms}}\t9.07 KB\tN/A\n\t  └─Selection_6     \tcop[tidb]\t3333.33\tgt(information_schema.cluster_statements_summary_history.exec_count, 1)                                                                                                                                                                                                                                                                                                                                   \t0      \t                                                                                                                                                                          \tN/A    \tN/A\n\t    └─MemTableScan_5\tcop[tidb]\t10000  \ttable:CLUSTER_STATEMENTS_SUMMARY_HISTORY,                                                                                                                                                                                                                                                                                                                                                                 \t0      \t                                                                                                                                                                          \tN/A    \tN/A"
-	op, err := NewPlanFromStmtSummaryPlan(input)
+	op, _, err := NewPlanFromStmtSummaryPlan(input)
 	require.NoError(t, err)
 	expected := &Op{
 		Type: "Projection", ID: "4", Task: "root",
@@ -123,3 +125,36 @@ func TestFromStmtSummaryPlan(t *testing.T) {
 
 	require.Equal(t, expected, op)
 }
+
+func TestExplainAsStmtSummaryPlan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("EXPLAIN SELECT \\* FROM t WHERE a = 1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("IndexLookUp_10", "10.00", "root", "", "").
+			AddRow("├─IndexRangeScan_8(Build)", "10.00", "cop[tikv]", "table:t, index:idx_a(a)", "range:[1,1]").
+			AddRow("└─TableRowIDScan_9(Probe)", "10.00", "cop[tikv]", "table:t", "keep order:false"))
+	planStr, err := ExplainAsStmtSummaryPlan(context.Background(), db, "test", "SELECT * FROM t WHERE a = 1")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	op, _, err := NewPlanFromStmtSummaryPlan(planStr)
+	require.NoError(t, err)
+	expected := &Op{
+		Type: "IndexLookUp", ID: "10", Task: "root",
+		Children: []*Op{
+			{
+				Type: "IndexRangeScan", ID: "8", Label: "(Build)", Task: "cop[tikv]",
+				AccessObject: &AccessObject{Table: "t", Index: "idx_a(a)"},
+			},
+			{
+				Type: "TableRowIDScan", ID: "9", Label: "(Probe)", Task: "cop[tikv]",
+				AccessObject: &AccessObject{Table: "t"},
+			},
+		},
+	}
+	require.Equal(t, expected, op)
+}
@@ -13,6 +13,8 @@ type Report struct {
 	CaptureInfoItems   [][2]string
 	ExecutionInfoItems [][2]string
 	Summary            Summary
+	Coverage           Table
+	VariableDiffs      Table
 	TopSQLs            Table
 	Details            []Details
 }
@@ -23,6 +25,7 @@ type Summary struct {
 	Unchanged   ChangeCount
 	MayDegraded ChangeCount
 	Errors      ChangeCount
+	TimedOut    ChangeCount
 	Unsupported ChangeCount
 }
 
@@ -47,6 +50,9 @@ type Details struct {
 	Labels [][2]string
 	Source *Plan
 	Target *Plan
+	// TargetWithoutBinding is the plan of target cluster when the binding is not
+	// used.
+	TargetWithoutBinding *Plan
 }
 
 type Plan struct {
@@ -38,6 +38,12 @@ func TestRender(t *testing.T) {
 				Plan: 1,
 			},
 		},
+		VariableDiffs: Table{
+			Header: []string{"Variable", "Source", "Target", "Synced"},
+			Data: [][]string{
+				{"tidb_cost_model_version", "1", "2", "true"},
+			},
+		},
 		TopSQLs: Table{
 			Header: []string{"SQLDigest", "SumLatency"},
 			Data: [][]string{
@@ -0,0 +1,191 @@
+package schema
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/auth"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"github.com/pingcap/tidb/pkg/parser/model"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// ignoredTableOptions are the table options that don't affect the plans, so
+// they are ignored by DiffCreateStmt.
+var ignoredTableOptions = []ast.TableOptionType{
+	ast.TableOptionAutoIncrement,
+	ast.TableOptionAutoIdCache,
+	ast.TableOptionAutoRandomBase,
+	ast.TableOptionComment,
+	ast.TableOptionShardRowID,
+	ast.TableOptionPreSplitRegion,
+	ast.TableOptionPlacementPolicy,
+}
+
+const diffRestoreFlags = format.DefaultRestoreFlags |
+	format.RestoreNameLowercase |
+	format.SkipPlacementRuleForRestore |
+	format.RestoreWithoutSchemaName
+
+// DiffCreateStmt compares two CREATE TABLE / VIEW / SEQUENCE statements by
+// their structure, and returns the human-readable differences. The formatting
+// and the options that don't affect the plans, like AUTO_INCREMENT and
+// comments, are ignored. Empty result means the structures are the same.
+func DiffCreateStmt(expected, actual string) ([]string, error) {
+	p := util.ParserPool.Get().(*parser.Parser)
+	defer util.ParserPool.Put(p)
+	a, err := p.ParseOneStmt(expected, "", "")
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse create statement %s", expected)
+	}
+	b, err := p.ParseOneStmt(actual, "", "")
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse create statement %s", actual)
+	}
+	normalizeCreateStmt(a)
+	normalizeCreateStmt(b)
+
+	var diffs []string
+	tableA, okA := a.(*ast.CreateTableStmt)
+	tableB, okB := b.(*ast.CreateTableStmt)
+	if okA && okB {
+		diffs, err = diffNamedParts("column", columnsByName(tableA.Cols), columnsByName(tableB.Cols))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		indexDiffs, err2 := diffNamedParts("index", indexesByName(tableA.Constraints), indexesByName(tableB.Constraints))
+		if err2 != nil {
+			return nil, errors.Trace(err2)
+		}
+		diffs = append(diffs, indexDiffs...)
+		tableA.Cols, tableA.Constraints = nil, nil
+		tableB.Cols, tableB.Constraints = nil, nil
+	}
+
+	restoredA, err := restoreNode(a)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	restoredB, err := restoreNode(b)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if restoredA != restoredB {
+		what := "definition"
+		if okA && okB {
+			what = "table options"
+		}
+		diffs = append(diffs, what+" mismatch: expected "+restoredA+", got "+restoredB)
+	}
+	return diffs, nil
+}
+
+// normalizeCreateStmt removes the parts of the statement that don't affect the
+// plans in-place.
+func normalizeCreateStmt(stmt ast.StmtNode) {
+	switch n := stmt.(type) {
+	case *ast.CreateTableStmt:
+		n.IfNotExists = false
+		n.Options = slices.DeleteFunc(n.Options, func(opt *ast.TableOption) bool {
+			return slices.Contains(ignoredTableOptions, opt.Tp)
+		})
+		for _, col := range n.Cols {
+			col.Options = slices.DeleteFunc(col.Options, func(opt *ast.ColumnOption) bool {
+				return opt.Tp == ast.ColumnOptionComment
+			})
+		}
+		for _, c := range n.Constraints {
+			if c.Option != nil {
+				c.Option.Comment = ""
+			}
+		}
+	case *ast.CreateViewStmt:
+		n.OrReplace = false
+		n.Definer = &auth.UserIdentity{CurrentUser: true}
+		n.Algorithm = model.AlgorithmUndefined
+		n.Security = model.SecurityDefiner
+	case *ast.CreateSequenceStmt:
+		n.IfNotExists = false
+	}
+}
+
+func columnsByName(cols []*ast.ColumnDef) map[string]ast.Node {
+	ret := make(map[string]ast.Node, len(cols))
+	for _, col := range cols {
+		ret[col.Name.Name.L] = col
+	}
+	return ret
+}
+
+func indexesByName(constraints []*ast.Constraint) map[string]ast.Node {
+	ret := make(map[string]ast.Node, len(constraints))
+	for _, c := range constraints {
+		name := strings.ToLower(c.Name)
+		if c.Tp == ast.ConstraintPrimaryKey {
+			name = "primary"
+		}
+		ret[name] = c
+	}
+	return ret
+}
+
+// diffNamedParts compares the columns or indexes with the same name.
+func diffNamedParts(kind string, expected, actual map[string]ast.Node) ([]string, error) {
+	var diffs []string
+	for _, name := range slices.Sorted(maps.Keys(expected)) {
+		a := expected[name]
+		b, ok := actual[name]
+		if !ok {
+			diffs = append(diffs, kind+" "+util.EscapeIdentifier(name)+" is missing")
+			continue
+		}
+		restoredA, err := restoreNode(a)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		restoredB, err := restoreNode(b)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if restoredA != restoredB {
+			diffs = append(diffs, kind+" "+util.EscapeIdentifier(name)+" mismatch: expected "+restoredA+", got "+restoredB)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(actual)) {
+		if _, ok := expected[name]; !ok {
+			diffs = append(diffs, kind+" "+util.EscapeIdentifier(name)+" is unexpected")
+		}
+	}
+	return diffs, nil
+}
+
+func restoreNode(n ast.Node) (string, error) {
+	var sb strings.Builder
+	if err := n.Restore(format.NewRestoreCtx(diffRestoreFlags, &sb)); err != nil {
+		return "", errors.Annotate(err, "restore create statement")
+	}
+	return sb.String(), nil
+}
+
+// objectKind returns the JournalKind of the object created by createSQL.
+func objectKind(createSQL string) (JournalKind, error) {
+	p := util.ParserPool.Get().(*parser.Parser)
+	stmt, err := p.ParseOneStmt(createSQL, "", "")
+	util.ParserPool.Put(p)
+	if err != nil {
+		return "", errors.Annotatef(err, "parse create statement %s", createSQL)
+	}
+	switch stmt.(type) {
+	case *ast.CreateViewStmt:
+		return JournalView, nil
+	case *ast.CreateSequenceStmt:
+		return JournalSequence, nil
+	default:
+		return JournalTable, nil
+	}
+}
@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCreateStmt(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+		actual   string
+		diffs    []string
+	}{
+		{
+			name:     "same",
+			expected: "CREATE TABLE `t` (`a` int, KEY `idx` (`a`))",
+			actual:   "CREATE TABLE `t` (`a` int, KEY `idx` (`a`))",
+		},
+		{
+			name:     "formatting and ignored options",
+			expected: "CREATE TABLE `t` (\n  `id` bigint NOT NULL AUTO_INCREMENT,\n  `a` int DEFAULT NULL COMMENT 'x',\n  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */,\n  KEY `idx` (`a`) COMMENT 'y'\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin AUTO_INCREMENT=30001 COMMENT='old'",
+			actual:   "create table t (ID bigint not null auto_increment, a int default null, key idx (a), primary key (id) clustered) engine=InnoDB default charset=utf8mb4 collate=utf8mb4_bin auto_increment=1",
+		},
+		{
+			name:     "column and index mismatch",
+			expected: "CREATE TABLE `t` (`a` int, `b` int, KEY `idx` (`a`), KEY `idx2` (`b`))",
+			actual:   "CREATE TABLE `t` (`a` bigint, `c` int, KEY `idx` (`a`, `c`) /*!80000 INVISIBLE */)",
+			diffs: []string{
+				"column `a` mismatch: expected `a` INT, got `a` BIGINT",
+				"column `b` is missing",
+				"column `c` is unexpected",
+				"index `idx` mismatch: expected INDEX `idx`(`a`), got INDEX `idx`(`a`, `c`) INVISIBLE",
+				"index `idx2` is missing",
+			},
+		},
+		{
+			name:     "table options mismatch",
+			expected: "CREATE TABLE `t` (`a` varchar(10)) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin",
+			actual:   "CREATE TABLE `t` (`a` varchar(10)) DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci",
+			diffs: []string{
+				"table options mismatch: expected CREATE TABLE `t` DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_BIN, got CREATE TABLE `t` DEFAULT CHARACTER SET = UTF8MB4 DEFAULT COLLATE = UTF8MB4_GENERAL_CI",
+			},
+		},
+		{
+			name:     "view definer is ignored",
+			expected: "CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v` (`a`) AS SELECT `a` FROM `test`.`t`",
+			actual:   "CREATE ALGORITHM=UNDEFINED DEFINER=`admin`@`127.0.0.1` SQL SECURITY DEFINER VIEW `v` (`a`) AS SELECT `a` FROM `test`.`t`",
+		},
+	}
+
+	for _, c := range cases {
+		diffs, err := DiffCreateStmt(c.expected, c.actual)
+		require.NoError(t, err, c.name)
+		require.Equal(t, c.diffs, diffs, c.name)
+	}
+}
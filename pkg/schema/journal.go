@@ -0,0 +1,86 @@
+package schema
+
+import "github.com/lance6716/plan-change-capturer/pkg/util"
+
+// JournalKind is the kind of the change recorded by JournalEntry.
+type JournalKind string
+
+const (
+	// JournalDatabase is a database created by pcc.
+	JournalDatabase JournalKind = "database"
+	// JournalTable is a table created by pcc.
+	JournalTable JournalKind = "table"
+	// JournalView is a view created by pcc.
+	JournalView JournalKind = "view"
+	// JournalSequence is a sequence created by pcc.
+	JournalSequence JournalKind = "sequence"
+	// JournalStats is the stats loaded into a table created by pcc. The stats of
+	// an existing table are not recorded, because DROP STATS can't restore them.
+	JournalStats JournalKind = "stats"
+	// JournalBinding is a global binding created by pcc, Name is its original
+	// SQL.
+	JournalBinding JournalKind = "binding"
+	// JournalPlacementPolicy is a placement policy created by pcc.
+	JournalPlacementPolicy JournalKind = "placement-policy"
+	// JournalResourceGroup is a resource group created by pcc.
+	JournalResourceGroup JournalKind = "resource-group"
+	// JournalTiFlashReplica is the TiFlash replica count changed by pcc on a
+	// table not created by pcc, Value is the original count.
+	JournalTiFlashReplica JournalKind = "tiflash-replica"
+	// JournalCache is a table not created by pcc that is cached by pcc.
+	JournalCache JournalKind = "cache"
+	// JournalGlobalVariable is a global variable changed by pcc, Value is the
+	// original value.
+	JournalGlobalVariable JournalKind = "global-variable"
+)
+
+// JournalEntry records an object created or a setting changed by pcc on the
+// new version cluster, so it can be dropped or restored after the task. The
+// names are the ones in the new version cluster.
+type JournalEntry struct {
+	Kind     JournalKind `json:"kind"`
+	Database string      `json:"database,omitempty"`
+	Name     string      `json:"name"`
+	Value    string      `json:"value,omitempty"`
+}
+
+// Journal persists the JournalEntry after the change is made. It should be
+// concurrent safe.
+type Journal interface {
+	AppendJournal(entry *JournalEntry) error
+}
+
+// UndoSQL returns the idempotent statement to drop or restore the change. It
+// returns empty string if the entry is invalid.
+func (e *JournalEntry) UndoSQL() string {
+	dbDotName := util.EscapeIdentifier(e.Database) + "." + util.EscapeIdentifier(e.Name)
+	switch e.Kind {
+	case JournalDatabase:
+		return "DROP DATABASE IF EXISTS " + util.EscapeIdentifier(e.Name)
+	case JournalTable:
+		return "DROP TABLE IF EXISTS " + dbDotName
+	case JournalView:
+		return "DROP VIEW IF EXISTS " + dbDotName
+	case JournalSequence:
+		return "DROP SEQUENCE IF EXISTS " + dbDotName
+	case JournalStats:
+		return "DROP STATS " + dbDotName
+	case JournalBinding:
+		return "DROP GLOBAL BINDING FOR " + e.Name
+	case JournalPlacementPolicy:
+		return "DROP PLACEMENT POLICY IF EXISTS " + util.EscapeIdentifier(e.Name)
+	case JournalResourceGroup:
+		return "DROP RESOURCE GROUP IF EXISTS " + util.EscapeIdentifier(e.Name)
+	case JournalTiFlashReplica:
+		return "ALTER TABLE " + dbDotName + " SET TIFLASH REPLICA " + e.Value
+	case JournalCache:
+		return "ALTER TABLE " + dbDotName + " NOCACHE"
+	case JournalGlobalVariable:
+		stmt, err := util.SetGlobalVariableSQL(e.Name, e.Value)
+		if err != nil {
+			return ""
+		}
+		return stmt
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package schema
+
+import (
+	"strings"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	"github.com/pingcap/tidb/pkg/parser/model"
+)
+
+// Mapping maps the database names of the old version cluster to the database
+// names of the new version cluster, so the synchronized databases don't
+// collide with the existing ones. The key "*" matches the databases not listed,
+// and the "*" in its value is replaced by the database name, like
+// "*" -> "pcc_task1_*". The system databases are never mapped. Database names
+// are case-insensitive.
+type Mapping map[string]string
+
+const mappingWildcard = "*"
+
+// Validate checks the mapping. prefix is used in the error message.
+func (m Mapping) Validate(prefix string) error {
+	sources := make(map[string]string, len(m))
+	targets := make(map[string]string, len(m))
+	for source, target := range m {
+		if other, ok := sources[strings.ToLower(source)]; ok {
+			return errors.Errorf("%s contains both %q and %q", prefix, other, source)
+		}
+		sources[strings.ToLower(source)] = source
+		if source == "" || target == "" {
+			return errors.Errorf("%s should not contain empty database name, got %q -> %q", prefix, source, target)
+		}
+		if util.IsMemOrSysTable([2]string{source, ""}) || util.IsMemOrSysTable([2]string{target, ""}) {
+			return errors.Errorf("%s should not contain system database, got %q -> %q", prefix, source, target)
+		}
+		wildcards := strings.Count(target, mappingWildcard)
+		if source == mappingWildcard && wildcards != 1 {
+			return errors.Errorf("%s of %q should contain exactly one %q, got %q", prefix, mappingWildcard, mappingWildcard, target)
+		}
+		if source != mappingWildcard && (wildcards > 0 || strings.Contains(source, mappingWildcard)) {
+			return errors.Errorf("%s only supports %q as the whole source database, got %q -> %q", prefix, mappingWildcard, source, target)
+		}
+		lower := strings.ToLower(target)
+		if other, ok := targets[lower]; ok {
+			return errors.Errorf("%s maps both %q and %q to %q", prefix, other, source, target)
+		}
+		targets[lower] = source
+	}
+	return nil
+}
+
+// Target returns the database name in the new version cluster.
+func (m Mapping) Target(dbName string) string {
+	if len(m) == 0 || dbName == "" || util.IsMemOrSysTable([2]string{dbName, ""}) {
+		return dbName
+	}
+	for source, target := range m {
+		if source != mappingWildcard && strings.EqualFold(source, dbName) {
+			return target
+		}
+	}
+	if target, ok := m[mappingWildcard]; ok {
+		return strings.Replace(target, mappingWildcard, dbName, 1)
+	}
+	return dbName
+}
+
+// Source is the reverse of Target.
+func (m Mapping) Source(dbName string) string {
+	if len(m) == 0 || dbName == "" || util.IsMemOrSysTable([2]string{dbName, ""}) {
+		return dbName
+	}
+	for source, target := range m {
+		if source != mappingWildcard && strings.EqualFold(target, dbName) {
+			return source
+		}
+	}
+	if target, ok := m[mappingWildcard]; ok {
+		prefix, suffix, _ := strings.Cut(strings.ToLower(target), mappingWildcard)
+		lower := strings.ToLower(dbName)
+		if len(lower) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(lower, prefix) &&
+			strings.HasSuffix(lower, suffix) {
+			return dbName[len(prefix) : len(dbName)-len(suffix)]
+		}
+	}
+	return dbName
+}
+
+// RewriteSQL replaces the database names in the SQL by Target, including the
+// ones in the CREATE DATABASE statement, the view definition, the column
+// names and the optimizer hints. The SQL is returned unchanged if the mapping
+// is empty, otherwise it's parsed and restored so the formatting may change.
+func (m Mapping) RewriteSQL(sql string) (string, error) {
+	if len(m) == 0 {
+		return sql, nil
+	}
+	p := util.ParserPool.Get().(*parser.Parser)
+	stmt, err := p.ParseOneStmt(sql, "", "")
+	util.ParserPool.Put(p)
+	if err != nil {
+		return "", util.WrapUnretryableError(
+			errors.Annotatef(err, "parse SQL to map database names: %s", sql),
+		)
+	}
+	if n, ok := stmt.(*ast.CreateDatabaseStmt); ok {
+		n.Name = model.NewCIStr(m.Target(n.Name.O))
+	}
+	stmt.Accept(&mappingVisitor{m: m})
+
+	var sb strings.Builder
+	err = stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb))
+	if err != nil {
+		return "", util.WrapUnretryableError(
+			errors.Annotatef(err, "restore SQL to map database names: %s", sql),
+		)
+	}
+	return sb.String(), nil
+}
+
+type mappingVisitor struct {
+	m Mapping
+}
+
+func (v *mappingVisitor) Enter(in ast.Node) (ast.Node, bool) {
+	switch n := in.(type) {
+	case *ast.TableName:
+		n.Schema = v.target(n.Schema)
+	case *ast.ColumnName:
+		n.Schema = v.target(n.Schema)
+	case *ast.SelectField:
+		// the wildcard is not visited by Accept
+		if n.WildCard != nil {
+			n.WildCard.Schema = v.target(n.WildCard.Schema)
+		}
+	case *ast.TableOptimizerHint:
+		for i := range n.Tables {
+			n.Tables[i].DBName = v.target(n.Tables[i].DBName)
+		}
+	}
+	return in, false
+}
+
+func (v *mappingVisitor) Leave(in ast.Node) (ast.Node, bool) {
+	return in, true
+}
+
+func (v *mappingVisitor) target(dbName model.CIStr) model.CIStr {
+	if dbName.O == "" {
+		return dbName
+	}
+	return model.NewCIStr(v.m.Target(dbName.O))
+}
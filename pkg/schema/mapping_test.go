@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMappingName(t *testing.T) {
+	m := Mapping{"app": "pcc_app", "*": "pcc_task1_*_db"}
+	require.NoError(t, m.Validate("schema-mapping"))
+
+	require.Equal(t, "pcc_app", m.Target("app"))
+	require.Equal(t, "pcc_app", m.Target("APP"))
+	require.Equal(t, "pcc_task1_test_db", m.Target("test"))
+	require.Equal(t, "mysql", m.Target("mysql"))
+	require.Equal(t, "", m.Target(""))
+
+	require.Equal(t, "app", m.Source("pcc_app"))
+	require.Equal(t, "test", m.Source("pcc_task1_test_db"))
+	require.Equal(t, "Test", m.Source("PCC_TASK1_Test_DB"))
+	require.Equal(t, "pcc_task1__db", m.Source("pcc_task1__db"))
+	require.Equal(t, "other", m.Source("other"))
+
+	var empty Mapping
+	require.NoError(t, empty.Validate("schema-mapping"))
+	require.Equal(t, "app", empty.Target("app"))
+	require.Equal(t, "app", empty.Source("app"))
+
+	require.ErrorContains(t, Mapping{"app": ""}.Validate("schema-mapping"), "should not contain empty database name")
+	require.ErrorContains(t, Mapping{"mysql": "m"}.Validate("schema-mapping"), "should not contain system database")
+	require.ErrorContains(t, Mapping{"*": "pcc"}.Validate("schema-mapping"), `should contain exactly one "*"`)
+	require.ErrorContains(t, Mapping{"app*": "pcc_*"}.Validate("schema-mapping"), `only supports "*" as the whole source database`)
+	require.ErrorContains(t, Mapping{"a": "pcc", "b": "PCC"}.Validate("schema-mapping"), "maps both")
+}
+
+func TestMappingRewriteSQL(t *testing.T) {
+	sql := "CREATE DATABASE `app` /*!40100 DEFAULT CHARACTER SET utf8mb4 */"
+	var empty Mapping
+	got, err := empty.RewriteSQL(sql)
+	require.NoError(t, err)
+	require.Equal(t, sql, got)
+
+	m := Mapping{"app": "pcc_app", "other": "pcc_other"}
+	cases := []struct {
+		sql      string
+		expected string
+	}{
+		{
+			sql:      sql,
+			expected: "CREATE DATABASE `pcc_app` CHARACTER SET = utf8mb4",
+		},
+		{
+			sql:      "CREATE TABLE `t` (`id` bigint DEFAULT nextval(`app`.`seq`), `a` int, FOREIGN KEY (`a`) REFERENCES `other`.`t2` (`a`))",
+			expected: "CREATE TABLE `t` (`id` BIGINT DEFAULT (NEXTVAL(`pcc_app`.`seq`)),`a` INT,CONSTRAINT FOREIGN KEY (`a`) REFERENCES `pcc_other`.`t2`(`a`))",
+		},
+		{
+			sql:      "CREATE VIEW `v` (`a`) AS SELECT `app`.`t`.`a` AS `a` FROM `app`.`t` JOIN `mysql`.`user`",
+			expected: "CREATE ALGORITHM = UNDEFINED DEFINER = CURRENT_USER SQL SECURITY DEFINER VIEW `v` (`a`) AS SELECT `pcc_app`.`t`.`a` AS `a` FROM `pcc_app`.`t` JOIN `mysql`.`user`",
+		},
+		{
+			sql:      "SELECT /*+ use_index(@`sel_1` `app`.`t` `idx`) */ `app`.`t`.* FROM `app` . `t` WHERE `a` = ?",
+			expected: "SELECT /*+ USE_INDEX(@`sel_1` `pcc_app`.`t` `idx`)*/ `pcc_app`.`t`.* FROM `pcc_app`.`t` WHERE `a`=?",
+		},
+		{
+			sql:      "select * from t where a = 1",
+			expected: "SELECT * FROM `t` WHERE `a`=1",
+		},
+	}
+	for _, c := range cases {
+		got, err = m.RewriteSQL(c.sql)
+		require.NoError(t, err, c.sql)
+		require.Equal(t, c.expected, got, c.sql)
+	}
+
+	_, err = m.RewriteSQL("select * fro t")
+	require.ErrorContains(t, err, "parse SQL to map database names")
+}
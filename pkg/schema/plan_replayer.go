@@ -0,0 +1,232 @@
+package schema
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
+
+// the files in the zip of PLAN REPLAYER DUMP, see the domain package of TiDB.
+const (
+	replayerSchemaDir         = "schema/"
+	replayerViewDir           = "view/"
+	replayerStatsDir          = "stats/"
+	replayerSchemaMetaFile    = "schema/schema_meta.txt"
+	replayerTiFlashFile       = "table_tiflash_replica.txt"
+	replayerGlobalBindingFile = "global_bindings.sql"
+)
+
+// replayerObject is a table, view or sequence created by a PLAN REPLAYER dump.
+type replayerObject struct {
+	// file is the name of the file in the zip that creates the object.
+	file      string
+	dbName    string
+	tableName string
+	createSQL string
+}
+
+// replayerDump is the zip file of PLAN REPLAYER DUMP. The objects can be
+// removed from it before PLAN REPLAYER LOAD, because the load stops at the
+// first error and the objects already existing cause errors.
+type replayerDump struct {
+	reader  *zip.Reader
+	objects []*replayerObject
+	// removed is the names of the files removed from the zip.
+	removed map[string]struct{}
+	// removedTiFlash is the {dbName}\t{tableName} whose TiFlash replica is not
+	// set.
+	removedTiFlash map[string]struct{}
+}
+
+// readReplayerDump parses the zip file of PLAN REPLAYER DUMP.
+func readReplayerDump(content []byte) (*replayerDump, error) {
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	d := &replayerDump{
+		reader:         r,
+		removed:        make(map[string]struct{}),
+		removedTiFlash: make(map[string]struct{}),
+	}
+	for _, f := range r.File {
+		if f.Name == replayerSchemaMetaFile || !f.Mode().IsRegular() {
+			continue
+		}
+		name, ok := strings.CutPrefix(f.Name, replayerSchemaDir)
+		if !ok {
+			name, ok = strings.CutPrefix(f.Name, replayerViewDir)
+		}
+		if !ok || strings.Contains(name, "/") {
+			continue
+		}
+		o, err := readReplayerObject(f)
+		if err != nil {
+			return nil, errors.Annotatef(err, "read %s", f.Name)
+		}
+		d.objects = append(d.objects, o)
+	}
+	return d, nil
+}
+
+// readReplayerObject parses the file of the schema or view directory, which is
+// "create database if not exists `db`; use `db`;" followed by the SHOW CREATE
+// result.
+func readReplayerObject(f *zip.File) (*replayerObject, error) {
+	content, err := readZipFile(f)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := util.ParserPool.Get().(*parser.Parser)
+	stmts, _, err := p.Parse(string(content), "", "")
+	util.ParserPool.Put(p)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(stmts) != 3 {
+		return nil, errors.Errorf("expect 3 statements, got %d", len(stmts))
+	}
+	o := &replayerObject{file: f.Name, createSQL: strings.TrimSpace(stmts[2].Text())}
+	if use, ok := stmts[1].(*ast.UseStmt); ok {
+		o.dbName = use.DBName
+	}
+	switch n := stmts[2].(type) {
+	case *ast.CreateTableStmt:
+		o.tableName = n.Table.Name.O
+	case *ast.CreateViewStmt:
+		o.tableName = n.ViewName.Name.O
+	case *ast.CreateSequenceStmt:
+		o.tableName = n.Name.Name.O
+	}
+	if o.dbName == "" || o.tableName == "" {
+		return nil, errors.Errorf("unexpected statements: %s", content)
+	}
+	return o, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	return content, errors.Trace(err)
+}
+
+// remove removes the creation and the TiFlash replica of the object from the
+// dump. The stats are also removed if withStats is true.
+func (d *replayerDump) remove(o *replayerObject, withStats bool) {
+	d.removed[o.file] = struct{}{}
+	d.removedTiFlash[o.dbName+"\t"+o.tableName] = struct{}{}
+	if withStats {
+		d.removed[statsFile(o)] = struct{}{}
+	}
+}
+
+// statsFile returns the name of the stats file of the object in the zip.
+func statsFile(o *replayerObject) string {
+	return replayerStatsDir + o.dbName + "." + o.tableName + ".json"
+}
+
+// isRemoved returns true if the object is removed by remove.
+func (d *replayerDump) isRemoved(o *replayerObject) bool {
+	_, ok := d.removed[o.file]
+	return ok
+}
+
+// hasStats returns true if the stats of the object are loaded by the dump.
+func (d *replayerDump) hasStats(o *replayerObject) bool {
+	name := statsFile(o)
+	if _, ok := d.removed[name]; ok {
+		return false
+	}
+	return slices.ContainsFunc(d.reader.File, func(f *zip.File) bool {
+		return f.Name == name
+	})
+}
+
+// databases returns the databases of the objects not removed.
+func (d *replayerDump) databases() []string {
+	var ret []string
+	for _, o := range d.objects {
+		if !d.isRemoved(o) && !slices.Contains(ret, o.dbName) {
+			ret = append(ret, o.dbName)
+		}
+	}
+	return ret
+}
+
+// globalBindings returns the original SQL of the global bindings created by the
+// dump. The rows are "{originalSQL}\t{bindSQL}\t{db}\t{status}\t..." and only
+// the enabled ones are created.
+func (d *replayerDump) globalBindings() ([]string, error) {
+	var ret []string
+	for _, f := range d.reader.File {
+		if f.Name != replayerGlobalBindingFile {
+			continue
+		}
+		content, err := readZipFile(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, row := range strings.Split(string(content), "\n") {
+			cols := strings.Split(row, "\t")
+			if len(cols) > 3 && cols[3] == "enabled" {
+				ret = append(ret, cols[0])
+			}
+		}
+	}
+	return ret, nil
+}
+
+// zip returns the content of the zip file after removing.
+func (d *replayerDump) zip() ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range d.reader.File {
+		if _, ok := d.removed[f.Name]; ok {
+			continue
+		}
+		if f.Name != replayerTiFlashFile || len(d.removedTiFlash) == 0 {
+			if err := w.Copy(f); err != nil {
+				return nil, errors.Trace(err)
+			}
+			continue
+		}
+		content, err := readZipFile(f)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// the rows are "{dbName}\t{tableName}\t{count}"
+		rows := strings.Split(string(content), "\n")
+		kept := rows[:0]
+		for _, row := range rows {
+			cols := strings.Split(row, "\t")
+			if len(cols) >= 2 {
+				if _, ok := d.removedTiFlash[cols[0]+"\t"+cols[1]]; ok {
+					continue
+				}
+			}
+			kept = append(kept, row)
+		}
+		fw, err := w.Create(f.Name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if _, err = fw.Write([]byte(strings.Join(kept, "\n"))); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
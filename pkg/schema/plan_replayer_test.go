@@ -0,0 +1,200 @@
+package schema
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplayerZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func readReplayerZip(t *testing.T, content []byte) map[string]string {
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	ret := make(map[string]string, len(r.File))
+	for _, f := range r.File {
+		c, err := readZipFile(f)
+		require.NoError(t, err)
+		ret[f.Name] = string(c)
+	}
+	return ret
+}
+
+func TestReplayerDump(t *testing.T) {
+	content := newReplayerZip(t, map[string]string{
+		"schema/schema_meta.txt":    "test.t1;test.v1",
+		"schema/test.t1.schema.txt": "create database if not exists `test`; use `test`;CREATE TABLE `t1` (\n  `a` int\n)",
+		"schema/test.t2.schema.txt": "create database if not exists `test`; use `test`;CREATE TABLE `t2` (\n  `a` int\n)",
+		"view/test.v1.view.txt":     "create database if not exists `test`; use `test`;CREATE VIEW `v1` AS SELECT * FROM `t1`",
+		"stats/test.t1.json":        "{}",
+		"stats/test.t2.json":        "{}",
+		"table_tiflash_replica.txt": "test\tt1\t1\ntest\tt2\t1\n",
+		"variables.toml":            "tidb_opt_index_merge = 'ON'",
+	})
+	d, err := readReplayerDump(content)
+	require.NoError(t, err)
+	require.Len(t, d.objects, 3)
+	require.Equal(t, &replayerObject{
+		file:      "schema/test.t1.schema.txt",
+		dbName:    "test",
+		tableName: "t1",
+		createSQL: "CREATE TABLE `t1` (\n  `a` int\n)",
+	}, d.objects[0])
+	require.Equal(t, "t2", d.objects[1].tableName)
+	require.Equal(t, "v1", d.objects[2].tableName)
+	require.Equal(t, "CREATE VIEW `v1` AS SELECT * FROM `t1`", d.objects[2].createSQL)
+
+	d.remove(d.objects[0], true)
+	d.remove(d.objects[2], false)
+	require.True(t, d.isRemoved(d.objects[0]))
+	require.False(t, d.isRemoved(d.objects[1]))
+	require.False(t, d.hasStats(d.objects[0]))
+	require.True(t, d.hasStats(d.objects[1]))
+	require.False(t, d.hasStats(d.objects[2]))
+	require.Equal(t, []string{"test"}, d.databases())
+	content, err = d.zip()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"schema/schema_meta.txt":    "test.t1;test.v1",
+		"schema/test.t2.schema.txt": "create database if not exists `test`; use `test`;CREATE TABLE `t2` (\n  `a` int\n)",
+		"stats/test.t2.json":        "{}",
+		"table_tiflash_replica.txt": "test\tt2\t1\n",
+		"variables.toml":            "tidb_opt_index_merge = 'ON'",
+	}, readReplayerZip(t, content))
+
+	_, err = readReplayerDump([]byte("not a zip"))
+	require.Error(t, err)
+}
+
+func TestLoadPlanReplayer(t *testing.T) {
+	ctx := context.Background()
+	// the path is quoted in the statement
+	path := filepath.Join(t.TempDir(), "it's.zip")
+	require.NoError(t, os.WriteFile(path, newReplayerZip(t, map[string]string{
+		"schema/test.t1.schema.txt": "create database if not exists `test`; use `test`;CREATE TABLE `t1` (`a` int)",
+		"schema/test.t2.schema.txt": "create database if not exists `test`; use `test`;CREATE TABLE `t2` (`a` int)",
+		"stats/test.t1.json":        "{}",
+		"stats/test.t2.json":        "{}",
+		"global_bindings.sql": "select * from `test` . `t2`\tSELECT /*+ use_index(`t2` )*/ * FROM `test`.`t2`\ttest\tenabled\n" +
+			"select * from `test` . `t1`\tSELECT * FROM `test`.`t1`\ttest\tdisabled\n",
+	}), 0o644))
+	loadSQL := regexp.QuoteMeta("PLAN REPLAYER LOAD '" + util.EscapeStringLiteral("Reader::"+path) + "'")
+	expectStatus := func(mock sqlmock.Sqlmock, table string, existing string) {
+		rows := sqlmock.NewRows([]string{"CREATE_OPTIONS"})
+		if existing != "" {
+			rows.AddRow("")
+		}
+		mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+			WithArgs("test", table).WillReturnRows(rows)
+		if existing != "" {
+			mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `test`.`" + table + "`")).
+				WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow(table, existing))
+		}
+	}
+
+	expectDatabase := func(mock sqlmock.Sqlmock, exists bool) {
+		rows := sqlmock.NewRows([]string{"1"})
+		if exists {
+			rows.AddRow(1)
+		}
+		mock.ExpectQuery("SELECT 1 FROM INFORMATION_SCHEMA.SCHEMATA").WithArgs("test").WillReturnRows(rows)
+	}
+
+	// t1 already exists with the same structure, so it's removed from the dump
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	expectStatus(mock, "t1", "CREATE TABLE `t1` (\n  `a` int\n)")
+	expectStatus(mock, "t2", "")
+	expectDatabase(mock, true)
+	mock.ExpectExec(loadSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	journal := &mockJournal{}
+	syncer := NewSyncer(db, MismatchReport, nil, journal)
+	require.NoError(t, syncer.LoadPlanReplayer(ctx, nil, path))
+	require.False(t, syncer.isCreated("test", "t1"))
+	require.True(t, syncer.isCreated("test", "t2"))
+	// the dump is loaded again for the session variables, and t2 created by the
+	// last load is not checked
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	expectStatus(mock, "t1", "CREATE TABLE `t1` (\n  `a` int\n)")
+	mock.ExpectExec(loadSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.LoadPlanReplayer(ctx, conn, path))
+	require.NoError(t, conn.Close())
+	require.NoError(t, mock.ExpectationsWereMet())
+	// the stats of the existing t1 are not recorded, and the binding is only
+	// recorded once
+	require.Equal(t, []*JournalEntry{
+		{Kind: JournalTable, Database: "test", Name: "t2"},
+		{Kind: JournalStats, Database: "test", Name: "t2"},
+		{Kind: JournalBinding, Name: "select * from `test` . `t2`"},
+	}, journal.entries)
+
+	// the mismatch is reported before loading
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectStatus(mock, "t1", "CREATE TABLE `t1` (\n  `a` bigint\n)")
+	err = NewSyncer(db, MismatchReport, nil, nil).LoadPlanReplayer(ctx, nil, path)
+	require.ErrorContains(t, err, "table test.t1 already exists with a different structure")
+	require.True(t, util.IsUnretryableError(err))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the mismatched table is dropped and created by the dump
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectStatus(mock, "t1", "CREATE TABLE `t1` (\n  `a` bigint\n)")
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS `test`.`t1`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatus(mock, "t2", "")
+	expectDatabase(mock, true)
+	mock.ExpectExec(loadSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	syncer = NewSyncer(db, MismatchRecreate, nil, nil)
+	require.NoError(t, syncer.LoadPlanReplayer(ctx, nil, path))
+	require.True(t, syncer.isCreated("test", "t1"))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the objects created before the load fails are recorded
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectStatus(mock, "t1", "")
+	expectStatus(mock, "t2", "")
+	expectDatabase(mock, false)
+	mock.ExpectExec(loadSQL).WillReturnError(errors.New("load failed"))
+	expectDatabase(mock, true)
+	mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+		WithArgs("test", "t1").WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}).AddRow(""))
+	mock.ExpectQuery("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES").
+		WithArgs("test", "t2").WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}))
+	journal = &mockJournal{}
+	err = NewSyncer(db, MismatchReport, nil, journal).LoadPlanReplayer(ctx, nil, path)
+	require.ErrorContains(t, err, "load failed")
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, []*JournalEntry{
+		{Kind: JournalDatabase, Name: "test"},
+		{Kind: JournalTable, Database: "test", Name: "t1"},
+	}, journal.entries)
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/go-sql-driver/mysql"
@@ -16,7 +20,8 @@ import (
 
 // Syncer is used to synchronize the database / table structure and stats to the
 // target database. It's concurrent safe and the same object will only be
-// synchronized once.
+// synchronized once. The methods accept the database names of the old version
+// cluster, and the objects are created under the names given by the Mapping.
 type Syncer struct {
 	db *sql.DB
 
@@ -28,14 +33,75 @@ type Syncer struct {
 	statsErr     sync.Map // statsPath -> execution error
 	bindingOnce  sync.Map // bindingDigest -> sync.Once
 	bindingErr   sync.Map // bindingDigest -> execution error
+	policyOnce   sync.Map // policyName -> sync.Once
+	policyErr    sync.Map // policyName -> execution error
+	tiflashOnce  sync.Map // {dbName}.{tableName} -> sync.Once
+	tiflashErr   sync.Map // {dbName}.{tableName} -> execution error
+	cacheOnce    sync.Map // {dbName}.{tableName} -> sync.Once
+	cacheErr     sync.Map // {dbName}.{tableName} -> execution error
+	groupOnce    sync.Map // resourceGroupName -> sync.Once
+	groupErr     sync.Map // resourceGroupName -> execution error
+	// replayerMu serializes LoadPlanReplayer, because the dumps of the statements
+	// accessing the same table all create it.
+	replayerMu sync.Mutex
+	// replayerBindings is the global bindings created by LoadPlanReplayer and
+	// recorded to the journal, guarded by replayerMu.
+	replayerBindings map[string]struct{}
+	// createdTables is the tables created by this Syncer, the changes of them
+	// are not recorded to the journal because they will be dropped.
+	createdTables sync.Map // {dbName}.{tableName} in target database -> struct{}
+
+	mismatchPolicy MismatchPolicy
+	mapping        Mapping
+	journal        Journal
 }
 
-func NewSyncer(db *sql.DB) *Syncer {
+// MismatchPolicy decides what to do when the table / view / sequence to create
+// already exists in the target database with a different structure, see
+// DiffCreateStmt.
+type MismatchPolicy string
+
+const (
+	// MismatchReport fails the statements using the table, and the differences
+	// are reported in their results.
+	MismatchReport MismatchPolicy = "report"
+	// MismatchAdopt uses the existing table as is.
+	MismatchAdopt MismatchPolicy = "adopt"
+	// MismatchRecreate drops the existing table and creates it again.
+	MismatchRecreate MismatchPolicy = "recreate"
+)
+
+// NewSyncer creates a Syncer. Empty policy means MismatchReport, and empty
+// mapping means the databases are created under their original names. The
+// changes made by Syncer are recorded to journal if it's not nil.
+func NewSyncer(db *sql.DB, policy MismatchPolicy, mapping Mapping, journal Journal) *Syncer {
+	if policy == "" {
+		policy = MismatchReport
+	}
 	return &Syncer{
-		db: db,
+		db:               db,
+		replayerBindings: make(map[string]struct{}),
+		mismatchPolicy:   policy,
+		mapping:          mapping,
+		journal:          journal,
 	}
 }
 
+// record appends the entry to the journal if it's set.
+func (s *Syncer) record(entry *JournalEntry) error {
+	if s.journal == nil {
+		return nil
+	}
+	return errors.Annotatef(s.journal.AppendJournal(entry), "record %s %s to journal", entry.Kind, entry.Name)
+}
+
+// isCreated returns true if the table in target database is created by this
+// Syncer.
+func (s *Syncer) isCreated(dbName, tableName string) bool {
+	_, ok := s.createdTables.Load(util.EscapeIdentifier(dbName) + "." + util.EscapeIdentifier(tableName))
+	return ok
+}
+
 func (s *Syncer) CreateDatabase(
 	ctx context.Context,
 	dbName string,
@@ -58,9 +124,14 @@ func (s *Syncer) createDatabase(
 	dbName string,
 	sql string,
 ) (err error) {
+	dbName = s.mapping.Target(dbName)
+	sql, err = s.mapping.RewriteSQL(sql)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	_, err = s.db.ExecContext(ctx, sql)
 	if err == nil {
-		return nil
+		return s.record(&JournalEntry{Kind: JournalDatabase, Name: dbName})
 	}
 
 	// when error happens, we check if the database is created before
@@ -75,6 +146,13 @@ func (s *Syncer) createDatabase(
 	if sql == database {
 		return nil
 	}
+	// the rewritten SQL has a different format from SHOW CREATE DATABASE
+	if len(s.mapping) > 0 {
+		diffs, err2 := DiffCreateStmt(sql, database)
+		if err2 == nil && len(diffs) == 0 {
+			return nil
+		}
+	}
 	return errors.Annotatef(err,
 		"create database failed and the same database is not created before. sql: %s",
 		sql,
@@ -92,7 +170,7 @@ func (s *Syncer) CreateTable(
 	once.(*sync.Once).Do(func() {
 		s.tableErr.Store(dbDotTable, s.createTable(ctx, dbName, tableName, sql))
 	})
-	errLoaded, _ := s.tableErr.Load(dbName)
+	errLoaded, _ := s.tableErr.Load(dbDotTable)
 	if errLoaded == nil {
 		return nil
 	}
@@ -104,23 +182,28 @@ func (s *Syncer) createTable(
 	dbName, tableName string,
 	sql string,
 ) (err error) {
+	dbName = s.mapping.Target(dbName)
+	sql, err = s.mapping.RewriteSQL(sql)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	conn, err := s.db.Conn(ctx)
 	if err != nil {
 		return errors.Annotatef(err, "create table for %s.%s", dbName, tableName)
 	}
 	defer conn.Close()
 
-	_, err = conn.ExecContext(ctx, "USE "+dbName)
+	_, err = conn.ExecContext(ctx, "USE "+util.EscapeIdentifier(dbName))
 	if err != nil {
 		return errors.Annotatef(err, "create table for %s.%s", dbName, tableName)
 	}
 
 	_, err = conn.ExecContext(ctx, sql)
 	if err == nil {
-		return nil
+		return s.recordTable(dbName, tableName, sql)
 	}
 
-	// when error happens, we check if the table is created before
+	// when error happens, we check if the same table is created before
 	util.Logger.Warn(
 		"create table failed, will check if the same table is created before",
 		zap.String("database", dbName),
@@ -131,23 +214,91 @@ func (s *Syncer) createTable(
 	if err2 != nil {
 		return errors.Trace(err2)
 	}
-	if sql == sql2 {
+	diffs, err2 := DiffCreateStmt(sql, sql2)
+	if err2 != nil {
+		return errors.Trace(err2)
+	}
+	if len(diffs) == 0 {
 		return nil
 	}
-	return errors.Annotatef(err,
-		"create table failed and the same table is not created before. database: %s, table: %s, sql: %s",
-		dbName, tableName, sql,
-	)
+
+	dropped, err2 := s.resolveMismatch(ctx, conn, dbName, tableName, sql, diffs)
+	if err2 != nil || !dropped {
+		return err2
+	}
+	if _, err2 = conn.ExecContext(ctx, sql); err2 != nil {
+		return errors.Annotatef(err2, "recreate table for %s.%s", dbName, tableName)
+	}
+	return s.recordTable(dbName, tableName, sql)
+}
+
+// resolveMismatch handles the existing table whose structure is different from
+// sql by the MismatchPolicy. It returns true if the existing table is dropped so
+// sql should be executed again.
+func (s *Syncer) resolveMismatch(
+	ctx context.Context,
+	conn *sql.Conn,
+	dbName, tableName string,
+	sql string,
+	diffs []string,
+) (dropped bool, err error) {
+	switch s.mismatchPolicy {
+	case MismatchAdopt:
+		util.Logger.Warn(
+			"table already exists with a different structure, adopt it",
+			zap.String("database", dbName),
+			zap.String("table", tableName),
+			zap.Strings("differences", diffs))
+		return false, nil
+	case MismatchRecreate:
+		util.Logger.Warn(
+			"table already exists with a different structure, recreate it",
+			zap.String("database", dbName),
+			zap.String("table", tableName),
+			zap.Strings("differences", diffs))
+		kind, err := objectKind(sql)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		drop := &JournalEntry{Kind: kind, Database: dbName, Name: tableName}
+		if _, err = conn.ExecContext(ctx, drop.UndoSQL()); err != nil {
+			return false, errors.Annotatef(err, "recreate table for %s.%s", dbName, tableName)
+		}
+		return true, nil
+	default:
+		return false, util.WrapUnretryableError(errors.Errorf(
+			"table %s.%s already exists with a different structure: %s",
+			dbName, tableName, strings.Join(diffs, "; "),
+		))
+	}
 }
 
+// recordTable records the table / view / sequence created by sql.
+func (s *Syncer) recordTable(dbName, tableName, sql string) error {
+	s.createdTables.Store(util.EscapeIdentifier(dbName)+"."+util.EscapeIdentifier(tableName), struct{}{})
+	kind, err := objectKind(sql)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return s.record(&JournalEntry{Kind: kind, Database: dbName, Name: tableName})
+}
+
+// LoadStats loads the stats file of the table written by the old version
+// cluster. The stats of an existing table are overwritten and not recorded to
+// the journal.
 func (s *Syncer) LoadStats(
 	ctx context.Context,
+	dbName, tableName string,
 	statsPath string,
 ) (err error) {
 	o := new(sync.Once)
 	once, _ := s.statsOnce.LoadOrStore(statsPath, o)
 	once.(*sync.Once).Do(func() {
-		s.statsErr.Store(statsPath, s.loadStats(ctx, statsPath))
+		err2 := s.loadStats(ctx, statsPath)
+		if err2 == nil {
+			err2 = s.recordStats(s.mapping.Target(dbName), tableName)
+		}
+		s.statsErr.Store(statsPath, err2)
 	})
 	errLoaded, _ := s.statsErr.Load(statsPath)
 	if errLoaded == nil {
@@ -156,6 +307,17 @@ func (s *Syncer) LoadStats(
 	return errLoaded.(error)
 }
 
+// recordStats records the stats loaded into the table, see JournalStats.
+func (s *Syncer) recordStats(dbName, tableName string) error {
+	if !s.isCreated(dbName, tableName) {
+		util.Logger.Warn("stats of existing table are overwritten, they are not restored by cleanup",
+			zap.String("database", dbName),
+			zap.String("table", tableName))
+		return nil
+	}
+	return s.record(&JournalEntry{Kind: JournalStats, Database: dbName, Name: tableName})
+}
+
 func (s *Syncer) loadStats(
 	ctx context.Context,
 	statsPath string,
@@ -167,12 +329,50 @@ func (s *Syncer) loadStats(
 	if bytes.Equal(content, []byte("null")) {
 		return nil
 	}
+	if len(s.mapping) > 0 {
+		content, err = s.mapStatsDatabase(content)
+		if err != nil {
+			return errors.Annotatef(err, "map database name of stats file %s", statsPath)
+		}
+		// the content is changed, so it's sent by a reader instead of the file
+		name := "Reader::" + statsPath
+		mysql.RegisterReaderHandler(statsPath, func() io.Reader {
+			return bytes.NewReader(content)
+		})
+		defer mysql.DeregisterReaderHandler(statsPath)
+		_, err = s.db.ExecContext(ctx, "LOAD STATS '"+name+"'")
+		return errors.Annotatef(err, "load stats from %s", statsPath)
+	}
 	mysql.RegisterLocalFile(statsPath)
 	defer mysql.DeregisterLocalFile(statsPath)
 	_, err = s.db.ExecContext(ctx, "LOAD STATS '"+statsPath+"'")
 	return errors.Annotatef(err, "load stats from %s", statsPath)
 }
 
+// mapStatsDatabase replaces the database_name field of the JSON stats by the
+// Mapping.
+func (s *Syncer) mapStatsDatabase(content []byte) ([]byte, error) {
+	var stats map[string]json.RawMessage
+	if err := json.Unmarshal(content, &stats); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var dbName string
+	if err := json.Unmarshal(stats["database_name"], &dbName); err != nil {
+		return nil, errors.Trace(err)
+	}
+	target := s.mapping.Target(dbName)
+	if target == dbName {
+		return content, nil
+	}
+	newName, err := json.Marshal(target)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	stats["database_name"] = newName
+	content, err = json.Marshal(stats)
+	return content, errors.Trace(err)
+}
+
 func (s *Syncer) CreateBinding(
 	ctx context.Context,
 	sqlDigest string,
@@ -194,13 +394,400 @@ func (s *Syncer) createBinding(
 	ctx context.Context,
 	binding source.Binding,
 ) (err error) {
-	sql := "CREATE GLOBAL BINDING FOR " + binding.OriginalSQL + " USING " + binding.BindSQL
+	originalSQL, err := s.mapping.RewriteSQL(binding.OriginalSQL)
+	if err != nil {
+		return errors.Annotatef(err, "sync binding %s", binding.OriginalSQL)
+	}
+	bindSQL, err := s.mapping.RewriteSQL(binding.BindSQL)
+	if err != nil {
+		return errors.Annotatef(err, "sync binding %s", binding.OriginalSQL)
+	}
+	sql := "CREATE GLOBAL BINDING FOR " + originalSQL + " USING " + bindSQL
 	_, err = s.db.ExecContext(ctx, sql)
 	if err != nil {
 		if merr, ok := err.(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
 			err = util.WrapUnretryableError(err)
 		}
-		return errors.Annotatef(err, "sync binding %s", s)
+		return errors.Annotatef(err, "sync binding %s", binding.OriginalSQL)
+	}
+	return s.record(&JournalEntry{Kind: JournalBinding, Name: originalSQL})
+}
+
+// LoadPlanReplayer loads the zip file of PLAN REPLAYER DUMP by conn, which
+// creates the structure, stats and bindings of the statement and sets the
+// session variables of conn. So conn should be the one to run EXPLAIN, and a
+// temporary connection is used if it's nil. Unlike other methods, the dump is
+// loaded every time to set the session variables, and the tables already
+// existing are removed from it before loading.
+func (s *Syncer) LoadPlanReplayer(
+	ctx context.Context,
+	conn *sql.Conn,
+	path string,
+) (err error) {
+	s.replayerMu.Lock()
+	defer s.replayerMu.Unlock()
+
+	if conn == nil {
+		conn, err = s.db.Conn(ctx)
+		if err != nil {
+			return errors.Annotatef(err, "load plan replayer from %s", path)
+		}
+		defer util.DiscardConn(conn)
+	}
+	return s.loadPlanReplayer(ctx, conn, path)
+}
+
+func (s *Syncer) loadPlanReplayer(
+	ctx context.Context,
+	conn *sql.Conn,
+	path string,
+) (err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return util.WrapUnretryableError(errors.Annotatef(err, "read plan replayer dump %s", path))
+	}
+	dump, err := readReplayerDump(content)
+	if err != nil {
+		return util.WrapUnretryableError(errors.Annotatef(err, "read plan replayer dump %s", path))
+	}
+	for _, o := range dump.objects {
+		if err = s.dedupeReplayerObject(ctx, conn, dump, o); err != nil {
+			return errors.Annotatef(err, "load plan replayer from %s", path)
+		}
+	}
+	content, err = dump.zip()
+	if err != nil {
+		return errors.Annotatef(err, "rewrite plan replayer dump %s", path)
+	}
+	var newDatabases []string
+	for _, dbName := range dump.databases() {
+		exists, err := util.DatabaseExists(ctx, s.db, dbName)
+		if err != nil {
+			return errors.Annotatef(err, "load plan replayer from %s", path)
+		}
+		if !exists {
+			newDatabases = append(newDatabases, dbName)
+		}
+	}
+
+	// PLAN REPLAYER LOAD stops at the first error, so the rewritten dump is sent
+	// by a reader instead of the file
+	mysql.RegisterReaderHandler(path, func() io.Reader {
+		return bytes.NewReader(content)
+	})
+	defer mysql.DeregisterReaderHandler(path)
+	_, err = conn.ExecContext(ctx, "PLAN REPLAYER LOAD '"+util.EscapeStringLiteral("Reader::"+path)+"'")
+	if err != nil {
+		// the objects created before the error should also be cleaned up
+		if err2 := s.recordReplayerObjects(ctx, dump, newDatabases, false); err2 != nil {
+			util.Logger.Warn("failed to record the objects of plan replayer",
+				zap.String("path", path),
+				zap.Error(err2))
+		}
+		if merr, ok := err.(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
+			err = util.WrapUnretryableError(err)
+		}
+		return errors.Annotatef(err, "load plan replayer from %s", path)
+	}
+	return errors.Annotatef(
+		s.recordReplayerObjects(ctx, dump, newDatabases, true),
+		"load plan replayer from %s", path,
+	)
+}
+
+// recordReplayerObjects records the databases, tables, stats and global
+// bindings created by the dump like other methods. newDatabases is the
+// databases not existing before loading. If the load is failed, only the
+// databases and tables that exist are recorded.
+func (s *Syncer) recordReplayerObjects(
+	ctx context.Context,
+	dump *replayerDump,
+	newDatabases []string,
+	loaded bool,
+) error {
+	for _, dbName := range newDatabases {
+		if !loaded {
+			exists, err := util.DatabaseExists(ctx, s.db, dbName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !exists {
+				continue
+			}
+		}
+		if err := s.record(&JournalEntry{Kind: JournalDatabase, Name: dbName}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, o := range dump.objects {
+		if dump.isRemoved(o) {
+			continue
+		}
+		if !loaded {
+			status, err := util.ReadTableStatus(ctx, s.db, o.dbName, o.tableName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !status.Exists {
+				continue
+			}
+		}
+		if err := s.recordTable(o.dbName, o.tableName, o.createSQL); err != nil {
+			return errors.Trace(err)
+		}
+		if loaded && dump.hasStats(o) {
+			if err := s.recordStats(o.dbName, o.tableName); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if !loaded {
+		return nil
+	}
+
+	bindings, err := dump.globalBindings()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, originalSQL := range bindings {
+		if _, ok := s.replayerBindings[originalSQL]; ok {
+			continue
+		}
+		if err = s.record(&JournalEntry{Kind: JournalBinding, Name: originalSQL}); err != nil {
+			return errors.Trace(err)
+		}
+		s.replayerBindings[originalSQL] = struct{}{}
+	}
+	return nil
+}
+
+// dedupeReplayerObject removes the object from the dump if it already exists.
+// The one created by this Syncer is removed with its stats, and the structure
+// of other ones are checked like CreateTable.
+func (s *Syncer) dedupeReplayerObject(
+	ctx context.Context,
+	conn *sql.Conn,
+	dump *replayerDump,
+	o *replayerObject,
+) error {
+	if s.isCreated(o.dbName, o.tableName) {
+		dump.remove(o, true)
+		return nil
+	}
+	status, err := util.ReadTableStatus(ctx, s.db, o.dbName, o.tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !status.Exists {
+		return nil
+	}
+	existing, err := util.ReadCreateTableViewSeq(ctx, s.db, o.dbName, o.tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	diffs, err := DiffCreateStmt(o.createSQL, existing)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(diffs) > 0 {
+		dropped, err := s.resolveMismatch(ctx, conn, o.dbName, o.tableName, o.createSQL, diffs)
+		if err != nil || dropped {
+			return err
+		}
 	}
+	// the stats are still loaded into the existing table like LoadStats
+	dump.remove(o, false)
 	return nil
 }
+
+// CreatePlacementPolicy creates the placement policy, which should be created
+// before the databases and tables using it.
+func (s *Syncer) CreatePlacementPolicy(
+	ctx context.Context,
+	policyName string,
+	sql string,
+) (err error) {
+	o := new(sync.Once)
+	once, _ := s.policyOnce.LoadOrStore(policyName, o)
+	once.(*sync.Once).Do(func() {
+		s.policyErr.Store(policyName, s.createPlacementPolicy(ctx, policyName, sql))
+	})
+	errLoaded, _ := s.policyErr.Load(policyName)
+	if errLoaded == nil {
+		return nil
+	}
+	return errLoaded.(error)
+}
+
+func (s *Syncer) createPlacementPolicy(
+	ctx context.Context,
+	policyName string,
+	sql string,
+) (err error) {
+	_, err = s.db.ExecContext(ctx, sql)
+	if err == nil {
+		return s.record(&JournalEntry{Kind: JournalPlacementPolicy, Name: policyName})
+	}
+
+	// when error happens, we check if the same policy is created before
+	util.Logger.Warn(
+		"create placement policy failed, will check if the same policy is created before",
+		zap.String("sql", sql),
+		zap.Error(err))
+	sql2, err2 := util.ReadCreatePlacementPolicy(ctx, s.db, policyName)
+	if err2 != nil {
+		return errors.Trace(err2)
+	}
+	if sql == sql2 {
+		return nil
+	}
+	return errors.Annotatef(err,
+		"create placement policy failed and the same policy is not created before. sql: %s",
+		sql,
+	)
+}
+
+// SetTiFlashReplica sets the TiFlash replica count of the table. The new
+// version cluster should have enough TiFlash stores.
+func (s *Syncer) SetTiFlashReplica(
+	ctx context.Context,
+	dbName, tableName string,
+	count int,
+) (err error) {
+	dbDotTable := util.EscapeIdentifier(s.mapping.Target(dbName)) + "." + util.EscapeIdentifier(tableName)
+	o := new(sync.Once)
+	once, _ := s.tiflashOnce.LoadOrStore(dbDotTable, o)
+	once.(*sync.Once).Do(func() {
+		s.tiflashErr.Store(dbDotTable, s.setTiFlashReplica(ctx, s.mapping.Target(dbName), tableName, count))
+	})
+	errLoaded, _ := s.tiflashErr.Load(dbDotTable)
+	if errLoaded == nil {
+		return nil
+	}
+	return errLoaded.(error)
+}
+
+func (s *Syncer) setTiFlashReplica(
+	ctx context.Context,
+	dbName, tableName string,
+	count int,
+) error {
+	dbDotTable := util.EscapeIdentifier(dbName) + "." + util.EscapeIdentifier(tableName)
+	// the original count of an existing table is recorded to restore it
+	record := s.journal != nil && !s.isCreated(dbName, tableName)
+	original := 0
+	if record {
+		var err error
+		original, err = util.ReadTiFlashReplicaCount(ctx, s.db, dbName, tableName)
+		if err != nil {
+			return errors.Annotatef(err, "set TiFlash replica for %s", dbDotTable)
+		}
+		if original == count {
+			return nil
+		}
+	}
+	sql := "ALTER TABLE " + dbDotTable + " SET TIFLASH REPLICA " + strconv.Itoa(count)
+	if _, err := s.db.ExecContext(ctx, sql); err != nil {
+		return errors.Annotatef(err, "set TiFlash replica for %s", dbDotTable)
+	}
+	if !record {
+		return nil
+	}
+	return s.record(&JournalEntry{
+		Kind:     JournalTiFlashReplica,
+		Database: dbName,
+		Name:     tableName,
+		Value:    strconv.Itoa(original),
+	})
+}
+
+// CacheTable caches the table by ALTER TABLE ... CACHE. It should be called
+// after other changes of the table, because the DDL on a cached table is
+// restricted.
+func (s *Syncer) CacheTable(
+	ctx context.Context,
+	dbName, tableName string,
+) (err error) {
+	dbDotTable := util.EscapeIdentifier(s.mapping.Target(dbName)) + "." + util.EscapeIdentifier(tableName)
+	o := new(sync.Once)
+	once, _ := s.cacheOnce.LoadOrStore(dbDotTable, o)
+	once.(*sync.Once).Do(func() {
+		s.cacheErr.Store(dbDotTable, s.cacheTable(ctx, s.mapping.Target(dbName), tableName))
+	})
+	errLoaded, _ := s.cacheErr.Load(dbDotTable)
+	if errLoaded == nil {
+		return nil
+	}
+	return errLoaded.(error)
+}
+
+func (s *Syncer) cacheTable(
+	ctx context.Context,
+	dbName, tableName string,
+) error {
+	dbDotTable := util.EscapeIdentifier(dbName) + "." + util.EscapeIdentifier(tableName)
+	// an existing table that is already cached should not be changed
+	record := s.journal != nil && !s.isCreated(dbName, tableName)
+	if record {
+		status, err := util.ReadTableStatus(ctx, s.db, dbName, tableName)
+		if err != nil {
+			return errors.Annotatef(err, "cache table %s", dbDotTable)
+		}
+		if status.Cached {
+			return nil
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, "ALTER TABLE "+dbDotTable+" CACHE"); err != nil {
+		return errors.Annotatef(err, "cache table %s", dbDotTable)
+	}
+	if !record {
+		return nil
+	}
+	return s.record(&JournalEntry{Kind: JournalCache, Database: dbName, Name: tableName})
+}
+
+// CreateResourceGroup creates the resource group. It's not an error if the same
+// resource group is created before.
+func (s *Syncer) CreateResourceGroup(
+	ctx context.Context,
+	groupName string,
+	sql string,
+) (err error) {
+	o := new(sync.Once)
+	once, _ := s.groupOnce.LoadOrStore(groupName, o)
+	once.(*sync.Once).Do(func() {
+		s.groupErr.Store(groupName, s.createResourceGroup(ctx, groupName, sql))
+	})
+	errLoaded, _ := s.groupErr.Load(groupName)
+	if errLoaded == nil {
+		return nil
+	}
+	return errLoaded.(error)
+}
+
+func (s *Syncer) createResourceGroup(
+	ctx context.Context,
+	groupName string,
+	sql string,
+) (err error) {
+	_, err = s.db.ExecContext(ctx, sql)
+	if err == nil {
+		return s.record(&JournalEntry{Kind: JournalResourceGroup, Name: groupName})
+	}
+
+	util.Logger.Warn(
+		"create resource group failed, will check if the same resource group is created before",
+		zap.String("sql", sql),
+		zap.Error(err))
+	sql2, err2 := util.ReadCreateResourceGroup(ctx, s.db, groupName)
+	if err2 != nil {
+		return errors.Trace(err2)
+	}
+	if sql == sql2 {
+		return nil
+	}
+	return errors.Annotatef(err,
+		"create resource group failed and the same resource group is not created before. sql: %s",
+		sql,
+	)
+}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lance6716/plan-change-capturer/pkg/source"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,7 +40,7 @@ func TestConcurrentSyncDBSucc(t *testing.T) {
 	}
 	wg := sync.WaitGroup{}
 	ctx := context.Background()
-	syncer := NewSyncer(db)
+	syncer := NewSyncer(db, MismatchReport, nil, nil)
 
 	wg.Add(taskNum)
 	for _, dbName := range dbNames {
@@ -78,7 +83,7 @@ func TestConcurrentSyncDBFail(t *testing.T) {
 	}
 	wg := sync.WaitGroup{}
 	ctx := context.Background()
-	syncer := NewSyncer(db)
+	syncer := NewSyncer(db, MismatchReport, nil, nil)
 
 	wg.Add(taskNum)
 	for _, dbName := range dbNames {
@@ -91,3 +96,219 @@ func TestConcurrentSyncDBFail(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestSyncPlacementPolicyAndTiFlashReplica(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	ctx := context.Background()
+	syncer := NewSyncer(db, MismatchReport, nil, nil)
+
+	createPolicy := "CREATE PLACEMENT POLICY `p1` FOLLOWERS=4"
+	mock.ExpectExec(regexp.QuoteMeta(createPolicy)).
+		WillReturnError(errors.New("placement policy 'p1' already exists"))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE PLACEMENT POLICY `p1`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Policy", "Create Policy"}).AddRow("p1", createPolicy))
+	require.NoError(t, syncer.CreatePlacementPolicy(ctx, "p1", createPolicy))
+	// the same policy is only created once
+	require.NoError(t, syncer.CreatePlacementPolicy(ctx, "p1", createPolicy))
+
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `test`.`t` SET TIFLASH REPLICA 2")).
+		WillReturnError(errors.New("the tiflash replica count: 2 should be less than the total tiflash server count: 0"))
+	err = syncer.SetTiFlashReplica(ctx, "test", "t", 2)
+	require.ErrorContains(t, err, "set TiFlash replica for `test`.`t`")
+	err = syncer.SetTiFlashReplica(ctx, "test", "t", 2)
+	require.ErrorContains(t, err, "tiflash server count")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTableMismatch(t *testing.T) {
+	ctx := context.Background()
+	createTable := "CREATE TABLE `t` (\n  `a` int DEFAULT NULL COMMENT 'old',\n  KEY `idx` (`a`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin"
+	existing := "CREATE TABLE `t` (\n  `a` bigint DEFAULT NULL,\n  KEY `idx` (`a`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin AUTO_INCREMENT=30001"
+	errExists := errors.New("Table 'test.t' already exists")
+	expectExisting := func(mock sqlmock.Sqlmock, create string) {
+		mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(createTable)).WillReturnError(errExists)
+		mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `test`.`t`")).
+			WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("t", create))
+	}
+
+	// the differences only in formatting and ignored options are not mismatch
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	expectExisting(mock, "CREATE TABLE `t` (\n  `a` int DEFAULT NULL COMMENT 'new',\n  KEY `idx` (`a`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin AUTO_INCREMENT=30001")
+	require.NoError(t, NewSyncer(db, MismatchReport, nil, nil).CreateTable(ctx, "test", "t", createTable))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectExisting(mock, existing)
+	syncer := NewSyncer(db, MismatchReport, nil, nil)
+	err = syncer.CreateTable(ctx, "test", "t", createTable)
+	require.ErrorContains(t, err, "table test.t already exists with a different structure: column `a` mismatch: expected `a` INT DEFAULT NULL, got `a` BIGINT DEFAULT NULL")
+	require.True(t, util.IsUnretryableError(err))
+	// the error is remembered
+	require.Error(t, syncer.CreateTable(ctx, "test", "t", createTable))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectExisting(mock, existing)
+	require.NoError(t, NewSyncer(db, MismatchAdopt, nil, nil).CreateTable(ctx, "test", "t", createTable))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	db, mock, err = sqlmock.New()
+	require.NoError(t, err)
+	expectExisting(mock, existing)
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE IF EXISTS `test`.`t`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, NewSyncer(db, MismatchRecreate, nil, nil).CreateTable(ctx, "test", "t", createTable))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSyncWithMapping(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	ctx := context.Background()
+	syncer := NewSyncer(db, MismatchReport, Mapping{"app": "pcc_app"}, nil)
+
+	// the existing database has a different format
+	mock.ExpectExec(regexp.QuoteMeta("CREATE DATABASE `pcc_app` CHARACTER SET = utf8mb4")).
+		WillReturnError(errors.New("database exists"))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE DATABASE `pcc_app`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Database", "Create Database"}).
+			AddRow("pcc_app", "CREATE DATABASE `pcc_app` /*!40100 DEFAULT CHARACTER SET utf8mb4 */"))
+	err = syncer.CreateDatabase(ctx, "app", "CREATE DATABASE `app` /*!40100 DEFAULT CHARACTER SET utf8mb4 */")
+	require.NoError(t, err)
+
+	mock.ExpectExec("USE `pcc_app`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE ALGORITHM = UNDEFINED DEFINER = CURRENT_USER SQL SECURITY DEFINER VIEW `v` AS SELECT `a` FROM `pcc_app`.`t`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = syncer.CreateTable(ctx, "app", "v", "CREATE VIEW `v` AS SELECT `a` FROM `app`.`t`")
+	require.NoError(t, err)
+
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+	require.NoError(t, os.WriteFile(statsPath, []byte(`{"database_name":"app","table_name":"t","count":1}`), 0o644))
+	mock.ExpectExec(regexp.QuoteMeta("LOAD STATS 'Reader::" + statsPath + "'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.LoadStats(ctx, "app", "t", statsPath))
+	content, err := syncer.mapStatsDatabase([]byte(`{"database_name":"app","table_name":"t","count":1}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"database_name":"pcc_app","table_name":"t","count":1}`, string(content))
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE GLOBAL BINDING FOR SELECT * FROM `pcc_app`.`t` WHERE `a`=? USING SELECT /*+ USE_INDEX(`pcc_app`.`t` `idx`)*/ * FROM `pcc_app`.`t` WHERE `a`=?")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = syncer.CreateBinding(ctx, "digest", source.Binding{
+		OriginalSQL: "select * from `app` . `t` where `a` = ?",
+		BindSQL:     "SELECT /*+ use_index(`app`.`t` `idx`)*/ * FROM `app`.`t` WHERE `a` = ?",
+	})
+	require.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `pcc_app`.`t` CACHE")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.CacheTable(ctx, "app", "t"))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// the target database name needs to be quoted
+	mapping := Mapping{"*": "pcc-task1_*"}
+	require.NoError(t, mapping.Validate("schema-mapping"))
+	syncer = NewSyncer(db, MismatchReport, mapping, nil)
+	mock.ExpectExec(regexp.QuoteMeta("USE `pcc-task1_app`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE ALGORITHM = UNDEFINED DEFINER = CURRENT_USER SQL SECURITY DEFINER VIEW `v` AS SELECT `a` FROM `pcc-task1_app`.`t`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = syncer.CreateTable(ctx, "app", "v", "CREATE VIEW `v` AS SELECT `a` FROM `app`.`t`")
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+type mockJournal struct {
+	mu      sync.Mutex
+	entries []*JournalEntry
+}
+
+func (j *mockJournal) AppendJournal(entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, entry)
+	return nil
+}
+
+func TestSyncerJournal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	ctx := context.Background()
+	journal := &mockJournal{}
+	syncer := NewSyncer(db, MismatchReport, Mapping{"app": "pcc_app"}, journal)
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE DATABASE `pcc_app`")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.CreateDatabase(ctx, "app", "CREATE DATABASE `app`"))
+
+	mock.ExpectExec("USE `pcc_app`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t` (`a` INT)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.CreateTable(ctx, "app", "t", "CREATE TABLE `t` (`a` int)"))
+	// the changes of the created table are not recorded
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `pcc_app`.`t` SET TIFLASH REPLICA 1")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.SetTiFlashReplica(ctx, "app", "t", 1))
+	statsPath := filepath.Join(t.TempDir(), "t.json")
+	require.NoError(t, os.WriteFile(statsPath, []byte(`{"database_name":"app","table_name":"t"}`), 0o644))
+	mock.ExpectExec(regexp.QuoteMeta("LOAD STATS 'Reader::" + statsPath + "'")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.LoadStats(ctx, "app", "t", statsPath))
+
+	// the existing table with the same structure is not recorded, but the
+	// changes of it are
+	mock.ExpectExec("USE `pcc_app`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `t2` (`a` INT DEFAULT NULL)")).WillReturnError(errors.New("table exists"))
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE `pcc_app`.`t2`")).
+		WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("t2", "CREATE TABLE `t2` (\n  `a` int DEFAULT NULL\n)"))
+	require.NoError(t, syncer.CreateTable(ctx, "app", "t2", "CREATE TABLE `t2` (`a` int DEFAULT NULL)"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT REPLICA_COUNT FROM INFORMATION_SCHEMA.TIFLASH_REPLICA")).
+		WithArgs("pcc_app", "t2").
+		WillReturnRows(sqlmock.NewRows([]string{"REPLICA_COUNT"}))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE `pcc_app`.`t2` SET TIFLASH REPLICA 2")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.SetTiFlashReplica(ctx, "app", "t2", 2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES")).
+		WithArgs("pcc_app", "t2").
+		WillReturnRows(sqlmock.NewRows([]string{"CREATE_OPTIONS"}).AddRow("cached=on"))
+	require.NoError(t, syncer.CacheTable(ctx, "app", "t2"))
+
+	// the stats of the existing table can't be restored, so they are not
+	// recorded
+	statsPath = filepath.Join(t.TempDir(), "t2.json")
+	require.NoError(t, os.WriteFile(statsPath, []byte(`{"database_name":"app","table_name":"t2"}`), 0o644))
+	mock.ExpectExec(regexp.QuoteMeta("LOAD STATS 'Reader::" + statsPath + "'")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, syncer.LoadStats(ctx, "app", "t2", statsPath))
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE GLOBAL BINDING FOR SELECT * FROM `pcc_app`.`t` USING SELECT /*+ USE_INDEX(`t` )*/ * FROM `pcc_app`.`t`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	err = syncer.CreateBinding(ctx, "digest", source.Binding{
+		OriginalSQL: "select * from `app` . `t`",
+		BindSQL:     "SELECT /*+ use_index(`t` )*/ * FROM `app`.`t`",
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Equal(t, []*JournalEntry{
+		{Kind: JournalDatabase, Name: "pcc_app"},
+		{Kind: JournalTable, Database: "pcc_app", Name: "t"},
+		{Kind: JournalStats, Database: "pcc_app", Name: "t"},
+		{Kind: JournalTiFlashReplica, Database: "pcc_app", Name: "t2", Value: "0"},
+		{Kind: JournalBinding, Name: "SELECT * FROM `pcc_app`.`t`"},
+	}, journal.entries)
+
+	undo := make([]string, 0, len(journal.entries))
+	for _, e := range journal.entries {
+		undo = append(undo, e.UndoSQL())
+	}
+	require.Equal(t, []string{
+		"DROP DATABASE IF EXISTS `pcc_app`",
+		"DROP TABLE IF EXISTS `pcc_app`.`t`",
+		"DROP STATS `pcc_app`.`t`",
+		"ALTER TABLE `pcc_app`.`t2` SET TIFLASH REPLICA 0",
+		"DROP GLOBAL BINDING FOR SELECT * FROM `pcc_app`.`t`",
+	}, undo)
+	require.Equal(t,
+		"SET GLOBAL tidb_enable_auto_analyze = 'it''s'",
+		(&JournalEntry{Kind: JournalGlobalVariable, Name: "tidb_enable_auto_analyze", Value: "it's"}).UndoSQL(),
+	)
+	require.Empty(t, (&JournalEntry{Kind: JournalGlobalVariable, Name: "a = 1, b", Value: "1"}).UndoSQL())
+}
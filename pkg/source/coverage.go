@@ -0,0 +1,166 @@
+package source
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// WindowCoverage is the capture coverage of one instance in one time window of
+// statement summary. When the statement summary of an instance is full, the
+// least recently used statements are evicted and aggregated into the record
+// whose DIGEST is NULL, so their plans can never be captured.
+type WindowCoverage struct {
+	Instance  string
+	BeginTime time.Time
+	EndTime   time.Time
+	// ExecCount is the total EXEC_COUNT of the window, including the evicted
+	// ones.
+	ExecCount int64
+	// EvictedExecCount is the EXEC_COUNT of the evicted statements.
+	EvictedExecCount int64
+	// EvictedDigests is the number of evicted SQL digests, from the
+	// CLUSTER_STATEMENTS_SUMMARY_EVICTED table.
+	EvictedDigests int64
+}
+
+// Ratio returns the share of executions that are captured in the window. It
+// returns 1 if there's no execution.
+func (c *WindowCoverage) Ratio() float64 {
+	if c.ExecCount == 0 {
+		return 1
+	}
+	return float64(c.ExecCount-c.EvictedExecCount) / float64(c.ExecCount)
+}
+
+// OverallCoverage returns the share of executions that are captured in all
+// windows. It returns 1 if there's no execution.
+func OverallCoverage(coverages []*WindowCoverage) float64 {
+	total := &WindowCoverage{}
+	for _, c := range coverages {
+		total.ExecCount += c.ExecCount
+		total.EvictedExecCount += c.EvictedExecCount
+	}
+	return total.Ratio()
+}
+
+// ReadCoverage reads the capture coverage of every instance and time window
+// from the TiDB cluster. The windows are read from the same statement summary
+// table as ReadStmtSummary with `window`, and the returned slice is sorted by
+// begin time and instance.
+func ReadCoverage(
+	ctx context.Context,
+	db *sql.DB,
+	window StmtSummaryWindow,
+) ([]*WindowCoverage, error) {
+	// the history table also contains the current window
+	table := "CLUSTER_STATEMENTS_SUMMARY_HISTORY"
+	if window == WindowCurrent {
+		table = "CLUSTER_STATEMENTS_SUMMARY"
+	}
+
+	type key struct {
+		instance string
+		begin    int64
+	}
+	coverages := make(map[key]*WindowCoverage)
+	get := func(instance string, begin, end int64) *WindowCoverage {
+		k := key{instance, begin}
+		c, ok := coverages[k]
+		if !ok {
+			c = &WindowCoverage{
+				Instance:  instance,
+				BeginTime: time.Unix(begin, 0),
+				EndTime:   time.Unix(end, 0),
+			}
+			coverages[k] = c
+		}
+		return c
+	}
+
+	// use UNIX_TIMESTAMP to be independent of the time zone of the session
+	query := `
+		SELECT
+			INSTANCE,
+			UNIX_TIMESTAMP(SUMMARY_BEGIN_TIME),
+			UNIX_TIMESTAMP(MAX(SUMMARY_END_TIME)),
+			SUM(EXEC_COUNT),
+			SUM(IF(DIGEST IS NULL, EXEC_COUNT, 0))
+		FROM INFORMATION_SCHEMA.` + table + `
+		GROUP BY INSTANCE, SUMMARY_BEGIN_TIME`
+	err := queryRows(ctx, db, query, func(rows *sql.Rows) error {
+		var (
+			instance            string
+			begin, end          float64
+			execCnt, evictedCnt int64
+		)
+		if err := rows.Scan(&instance, &begin, &end, &execCnt, &evictedCnt); err != nil {
+			return err
+		}
+		c := get(instance, int64(begin), int64(end))
+		c.ExecCount = execCnt
+		c.EvictedExecCount = evictedCnt
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	query = `
+		SELECT
+			INSTANCE,
+			UNIX_TIMESTAMP(BEGIN_TIME),
+			UNIX_TIMESTAMP(END_TIME),
+			EVICTED_COUNT
+		FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_EVICTED`
+	err = queryRows(ctx, db, query, func(rows *sql.Rows) error {
+		var (
+			instance   string
+			begin, end float64
+			evicted    int64
+		)
+		if err := rows.Scan(&instance, &begin, &end, &evicted); err != nil {
+			return err
+		}
+		// the evicted table may keep the windows that are rotated out of the
+		// statement summary table, they have no EXEC_COUNT to compare with
+		if c, ok := coverages[key{instance, int64(begin)}]; ok {
+			c.EvictedDigests += evicted
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	ret := make([]*WindowCoverage, 0, len(coverages))
+	for _, c := range coverages {
+		ret = append(ret, c)
+	}
+	slices.SortFunc(ret, func(a, b *WindowCoverage) int {
+		if c := a.BeginTime.Compare(b.BeginTime); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Instance, b.Instance)
+	})
+	return ret, nil
+}
+
+// queryRows executes the query and calls fn on every row.
+func queryRows(ctx context.Context, db *sql.DB, query string, fn func(*sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			return errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+	}
+	return errors.Annotatef(rows.Err(), "failed to get rows for query: %s", query)
+}
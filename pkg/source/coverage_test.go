@@ -0,0 +1,38 @@
+package source
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCoverage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows([]string{"INSTANCE", "begin", "end", "exec", "evicted"}).
+			AddRow("tidb-1", 3600, 5400, 100, 0).
+			AddRow("tidb-0", 3600, 5400, 100, 40).
+			AddRow("tidb-0", 1800, 3600, 0, 0))
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_EVICTED").
+		WillReturnRows(sqlmock.NewRows([]string{"INSTANCE", "begin", "end", "EVICTED_COUNT"}).
+			AddRow("tidb-0", 3600, 5400, 7).
+			AddRow("tidb-0", 0, 1800, 3))
+
+	got, err := ReadCoverage(context.Background(), db, WindowBoth)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, []*WindowCoverage{
+		{Instance: "tidb-0", BeginTime: time.Unix(1800, 0), EndTime: time.Unix(3600, 0)},
+		{Instance: "tidb-0", BeginTime: time.Unix(3600, 0), EndTime: time.Unix(5400, 0), ExecCount: 100, EvictedExecCount: 40, EvictedDigests: 7},
+		{Instance: "tidb-1", BeginTime: time.Unix(3600, 0), EndTime: time.Unix(5400, 0), ExecCount: 100},
+	}, got)
+	require.Equal(t, 1.0, got[0].Ratio())
+	require.Equal(t, 0.6, got[1].Ratio())
+	require.Equal(t, 0.8, OverallCoverage(got))
+}
@@ -0,0 +1,332 @@
+package source
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// DefaultStmtTypes is the STMT_TYPE captured when Filter.StmtTypes is empty.
+var DefaultStmtTypes = []string{"Select", "Insert", "Replace", "Update", "Delete"}
+
+// Filter is the rules to select the statements to capture. A statement is
+// captured only if it matches all the rules. The empty rules are ignored.
+//
+// Schema and table patterns support wildcards, "*" matches any sequence of
+// characters and "?" matches any single character, case-insensitively. The
+// schema rules are checked on SCHEMA_NAME, which is the current database of the
+// session. The table rules are checked on the tables accessed by the statement
+// in "schema.table" format, and a statement is included if any of its tables
+// matches, excluded if any of its tables matches.
+type Filter struct {
+	IncludeSchemas []string `toml:"include-schemas" yaml:"include-schemas"`
+	ExcludeSchemas []string `toml:"exclude-schemas" yaml:"exclude-schemas"`
+	IncludeTables  []string `toml:"include-tables" yaml:"include-tables"`
+	ExcludeTables  []string `toml:"exclude-tables" yaml:"exclude-tables"`
+
+	IncludeSQLDigests  []string `toml:"include-sql-digests" yaml:"include-sql-digests"`
+	ExcludeSQLDigests  []string `toml:"exclude-sql-digests" yaml:"exclude-sql-digests"`
+	IncludeSampleUsers []string `toml:"include-sample-users" yaml:"include-sample-users"`
+	ExcludeSampleUsers []string `toml:"exclude-sample-users" yaml:"exclude-sample-users"`
+	// StmtTypes is the STMT_TYPE to capture, DefaultStmtTypes is used if it's
+	// empty.
+	StmtTypes []string `toml:"stmt-types" yaml:"stmt-types"`
+
+	MinExecCount  int           `toml:"min-exec-count" yaml:"min-exec-count"`
+	MinAvgLatency time.Duration `toml:"min-avg-latency" yaml:"min-avg-latency"`
+	// SummaryBeginTimeFrom and SummaryBeginTimeTo is the range [from, to) of
+	// SUMMARY_BEGIN_TIME, zero value means unbounded.
+	SummaryBeginTimeFrom time.Time `toml:"summary-begin-time-from" yaml:"summary-begin-time-from"`
+	SummaryBeginTimeTo   time.Time `toml:"summary-begin-time-to" yaml:"summary-begin-time-to"`
+
+	compileOnce sync.Once
+	compiled    *compiledFilter
+}
+
+// compiledFilter is the wildcard patterns of Filter compiled to regexp.
+type compiledFilter struct {
+	includeSchemas []*regexp.Regexp
+	excludeSchemas []*regexp.Regexp
+	// includeTables and excludeTables are the [schema, table] patterns
+	includeTables [][2]*regexp.Regexp
+	excludeTables [][2]*regexp.Regexp
+}
+
+// compile compiles the wildcard patterns once, so the rules should not be
+// changed after it's called. It's safe to be called concurrently.
+func (f *Filter) compile() *compiledFilter {
+	f.compileOnce.Do(func() {
+		compileTables := func(patterns []string) [][2]*regexp.Regexp {
+			ret := make([][2]*regexp.Regexp, 0, len(patterns))
+			for _, p := range patterns {
+				schemaPattern, tablePattern, _ := strings.Cut(p, ".")
+				ret = append(ret, [2]*regexp.Regexp{compileWildcard(schemaPattern), compileWildcard(tablePattern)})
+			}
+			return ret
+		}
+		f.compiled = &compiledFilter{
+			includeSchemas: compileWildcards(f.IncludeSchemas),
+			excludeSchemas: compileWildcards(f.ExcludeSchemas),
+			includeTables:  compileTables(f.IncludeTables),
+			excludeTables:  compileTables(f.ExcludeTables),
+		}
+	})
+	return f.compiled
+}
+
+// Validate checks the rules. The returned error names the invalid key with
+// `prefix`.
+func (f *Filter) Validate(prefix string) error {
+	for _, key := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"include-tables", f.IncludeTables},
+		{"exclude-tables", f.ExcludeTables},
+	} {
+		for _, p := range key.patterns {
+			if !strings.Contains(p, ".") {
+				return errors.Errorf("%s.%s should be in schema.table format, got %q", prefix, key.name, p)
+			}
+		}
+	}
+	if f.MinExecCount < 0 {
+		return errors.Errorf("%s.min-exec-count should not be negative, got %d", prefix, f.MinExecCount)
+	}
+	if f.MinAvgLatency < 0 {
+		return errors.Errorf("%s.min-avg-latency should not be negative, got %s", prefix, f.MinAvgLatency)
+	}
+	if !f.SummaryBeginTimeFrom.IsZero() && !f.SummaryBeginTimeTo.IsZero() &&
+		!f.SummaryBeginTimeFrom.Before(f.SummaryBeginTimeTo) {
+		return errors.Errorf("%s.summary-begin-time-from should be before summary-begin-time-to", prefix)
+	}
+	f.compile()
+	return nil
+}
+
+func (f *Filter) stmtTypes() []string {
+	if len(f.StmtTypes) == 0 {
+		return DefaultStmtTypes
+	}
+	return f.StmtTypes
+}
+
+// where returns the WHERE condition of the rules which can be pushed down to
+// the statement summary table, and the arguments of the placeholders. The
+// rules on the execution statistics are not included, because one row is only
+// a part of the aggregated statement, see matchStats.
+func (f *Filter) where() (string, []any) {
+	conds := make([]string, 0, 8)
+	args := make([]any, 0, 8)
+
+	inList := func(col string, values []string, not bool) {
+		if len(values) == 0 {
+			return
+		}
+		op := "IN"
+		if not {
+			op = "NOT IN"
+		}
+		conds = append(conds, fmt.Sprintf("%s %s (%s)", col, op, placeholders(len(values))))
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	likeAny := func(col string, patterns []string, not bool) {
+		if len(patterns) == 0 {
+			return
+		}
+		likes := make([]string, 0, len(patterns))
+		for _, p := range patterns {
+			likes = append(likes, col+" LIKE ?")
+			args = append(args, wildcardToLike(strings.ToLower(p)))
+		}
+		cond := "(" + strings.Join(likes, " OR ") + ")"
+		if not {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+	}
+
+	inList("STMT_TYPE", f.stmtTypes(), false)
+	likeAny("LOWER(IFNULL(SCHEMA_NAME, ''))", f.IncludeSchemas, false)
+	likeAny("LOWER(IFNULL(SCHEMA_NAME, ''))", f.ExcludeSchemas, true)
+	inList("DIGEST", f.IncludeSQLDigests, false)
+	inList("DIGEST", f.ExcludeSQLDigests, true)
+	inList("SAMPLE_USER", f.IncludeSampleUsers, false)
+	inList("SAMPLE_USER", f.ExcludeSampleUsers, true)
+	// use FROM_UNIXTIME to be independent of the time zone of the session
+	if !f.SummaryBeginTimeFrom.IsZero() {
+		conds = append(conds, "SUMMARY_BEGIN_TIME >= FROM_UNIXTIME(?)")
+		args = append(args, f.SummaryBeginTimeFrom.Unix())
+	}
+	if !f.SummaryBeginTimeTo.IsZero() {
+		conds = append(conds, "SUMMARY_BEGIN_TIME < FROM_UNIXTIME(?)")
+		args = append(args, f.SummaryBeginTimeTo.Unix())
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// matchTables checks the table rules on the tables accessed by a statement.
+func (f *Filter) matchTables(tables [][2]string) bool {
+	c := f.compile()
+	if len(c.includeTables) > 0 && !anyTableMatch(c.includeTables, tables) {
+		return false
+	}
+	return !anyTableMatch(c.excludeTables, tables)
+}
+
+// Rules returns the human-readable description of the active rules.
+func (f *Filter) Rules() []string {
+	ret := make([]string, 0, 8)
+	add := func(format string, values []string) {
+		if len(values) > 0 {
+			ret = append(ret, fmt.Sprintf(format, strings.Join(values, ", ")))
+		}
+	}
+
+	if f.MinExecCount > 0 {
+		ret = append(ret, fmt.Sprintf("EXEC_COUNT >= %d", f.MinExecCount))
+	}
+	add("STMT_TYPE IN (%s)", f.stmtTypes())
+	add("SCHEMA_NAME matches %s", f.IncludeSchemas)
+	add("SCHEMA_NAME not matches %s", f.ExcludeSchemas)
+	add("tables match %s", f.IncludeTables)
+	add("tables not match %s", f.ExcludeTables)
+	add("DIGEST IN (%s)", f.IncludeSQLDigests)
+	add("DIGEST NOT IN (%s)", f.ExcludeSQLDigests)
+	add("SAMPLE_USER IN (%s)", f.IncludeSampleUsers)
+	add("SAMPLE_USER NOT IN (%s)", f.ExcludeSampleUsers)
+	if f.MinAvgLatency > 0 {
+		ret = append(ret, fmt.Sprintf("AVG_LATENCY >= %s", f.MinAvgLatency))
+	}
+	if !f.SummaryBeginTimeFrom.IsZero() {
+		ret = append(ret, "SUMMARY_BEGIN_TIME >= "+f.SummaryBeginTimeFrom.Format(time.RFC3339))
+	}
+	if !f.SummaryBeginTimeTo.IsZero() {
+		ret = append(ret, "SUMMARY_BEGIN_TIME < "+f.SummaryBeginTimeTo.Format(time.RFC3339))
+	}
+	return ret
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// wildcardToLike converts the wildcard pattern to the pattern of LIKE.
+func wildcardToLike(pattern string) string {
+	var sb strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteByte('%')
+		case '?':
+			sb.WriteByte('_')
+		case '%', '_', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// compileWildcard compiles the wildcard pattern to a case-insensitive regexp.
+func compileWildcard(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?is)^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteByte('.')
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteByte('$')
+	return regexp.MustCompile(sb.String())
+}
+
+func compileWildcards(patterns []string) []*regexp.Regexp {
+	ret := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		ret = append(ret, compileWildcard(p))
+	}
+	return ret
+}
+
+func anyTableMatch(patterns [][2]*regexp.Regexp, tables [][2]string) bool {
+	for _, p := range patterns {
+		for _, t := range tables {
+			if p[0].MatchString(t[0]) && p[1].MatchString(t[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchStmtType checks the STMT_TYPE rule on the label of a statement.
+func (f *Filter) matchStmtType(stmtType string) bool {
+	return slices.ContainsFunc(f.stmtTypes(), func(t string) bool {
+		return strings.EqualFold(t, stmtType)
+	})
+}
+
+// matchStmt checks the schema and SQL digest rules on one statement. It's the
+// counterpart of where for the sources other than statement summary.
+func (f *Filter) matchStmt(schema, digest string) bool {
+	c := f.compile()
+	if len(c.includeSchemas) > 0 && !anyWildcardMatch(c.includeSchemas, schema) {
+		return false
+	}
+	if anyWildcardMatch(c.excludeSchemas, schema) {
+		return false
+	}
+	if len(f.IncludeSQLDigests) > 0 && !slices.Contains(f.IncludeSQLDigests, digest) {
+		return false
+	}
+	return !slices.Contains(f.ExcludeSQLDigests, digest)
+}
+
+// matchUserAndTime checks the SAMPLE_USER and SUMMARY_BEGIN_TIME rules on one
+// execution.
+func (f *Filter) matchUserAndTime(user string, t time.Time) bool {
+	if len(f.IncludeSampleUsers) > 0 && !slices.Contains(f.IncludeSampleUsers, user) {
+		return false
+	}
+	if slices.Contains(f.ExcludeSampleUsers, user) {
+		return false
+	}
+	if !f.SummaryBeginTimeFrom.IsZero() && t.Before(f.SummaryBeginTimeFrom) {
+		return false
+	}
+	return f.SummaryBeginTimeTo.IsZero() || t.Before(f.SummaryBeginTimeTo)
+}
+
+// matchStats checks the rules on the aggregated execution statistics. The
+// latency rule is skipped if the latency is unavailable.
+func (f *Filter) matchStats(s *StmtSummary) bool {
+	if s.ExecCount < f.MinExecCount {
+		return false
+	}
+	if f.MinAvgLatency > 0 && !s.LatencyUnavailable &&
+		s.SumLatency < f.MinAvgLatency*time.Duration(s.ExecCount) {
+		return false
+	}
+	return true
+}
+
+func anyWildcardMatch(patterns []*regexp.Regexp, name string) bool {
+	return slices.ContainsFunc(patterns, func(p *regexp.Regexp) bool {
+		return p.MatchString(name)
+	})
+}
@@ -0,0 +1,95 @@
+package source
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterWhere(t *testing.T) {
+	f := &Filter{}
+	where, args := f.where()
+	require.Equal(t, "STMT_TYPE IN (?, ?, ?, ?, ?)", where)
+	require.Equal(t, []any{"Select", "Insert", "Replace", "Update", "Delete"}, args)
+	require.Equal(t, []string{"STMT_TYPE IN (Select, Insert, Replace, Update, Delete)"}, f.Rules())
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f = &Filter{
+		IncludeSchemas:       []string{"App_*"},
+		ExcludeSchemas:       []string{"app_tmp", "a%b?"},
+		ExcludeSQLDigests:    []string{"d1"},
+		IncludeSampleUsers:   []string{"u1", "u2"},
+		StmtTypes:            []string{"Select"},
+		MinExecCount:         2,
+		MinAvgLatency:        time.Millisecond,
+		SummaryBeginTimeFrom: from,
+	}
+	where, args = f.where()
+	require.Equal(t, "STMT_TYPE IN (?) AND "+
+		"(LOWER(IFNULL(SCHEMA_NAME, '')) LIKE ?) AND "+
+		"NOT (LOWER(IFNULL(SCHEMA_NAME, '')) LIKE ? OR LOWER(IFNULL(SCHEMA_NAME, '')) LIKE ?) AND "+
+		"DIGEST NOT IN (?) AND "+
+		"SAMPLE_USER IN (?, ?) AND "+
+		"SUMMARY_BEGIN_TIME >= FROM_UNIXTIME(?)", where)
+	require.Equal(t, []any{
+		"Select", "app\\_%", "app\\_tmp", "a\\%b_", "d1", "u1", "u2", from.Unix(),
+	}, args)
+	require.Equal(t, []string{
+		"EXEC_COUNT >= 2",
+		"STMT_TYPE IN (Select)",
+		"SCHEMA_NAME matches App_*",
+		"SCHEMA_NAME not matches app_tmp, a%b?",
+		"DIGEST NOT IN (d1)",
+		"SAMPLE_USER IN (u1, u2)",
+		"AVG_LATENCY >= 1ms",
+		"SUMMARY_BEGIN_TIME >= 2024-01-01T00:00:00Z",
+	}, f.Rules())
+}
+
+func TestFilterMatchTables(t *testing.T) {
+	tables := [][2]string{{"app", "orders"}, {"app", "users"}}
+	require.True(t, (&Filter{}).matchTables(tables))
+	require.True(t, (&Filter{IncludeTables: []string{"APP.order?"}}).matchTables(tables))
+	require.False(t, (&Filter{IncludeTables: []string{"app.order"}}).matchTables(tables))
+	require.False(t, (&Filter{ExcludeTables: []string{"*.users"}}).matchTables(tables))
+	require.False(t, (&Filter{
+		IncludeTables: []string{"app.*"},
+		ExcludeTables: []string{"app.users"},
+	}).matchTables(tables))
+	require.True(t, (&Filter{ExcludeTables: []string{"app.user*x"}}).matchTables(tables))
+
+	// the patterns are compiled once when matching concurrently without Validate
+	f := &Filter{IncludeTables: []string{"app.*"}, IncludeSchemas: []string{"app"}}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.True(t, f.matchTables(tables))
+			require.True(t, f.matchStmt("app", "digest"))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFilterValidate(t *testing.T) {
+	require.NoError(t, (&Filter{IncludeTables: []string{"a.b"}}).Validate("filter"))
+	require.ErrorContains(t, (&Filter{ExcludeTables: []string{"ab"}}).Validate("filter"),
+		`filter.exclude-tables should be in schema.table format, got "ab"`)
+	require.ErrorContains(t, (&Filter{MinExecCount: -1}).Validate("filter"),
+		"filter.min-exec-count should not be negative")
+	now := time.Now()
+	require.ErrorContains(t, (&Filter{SummaryBeginTimeFrom: now, SummaryBeginTimeTo: now}).Validate("filter"),
+		"filter.summary-begin-time-from should be before summary-begin-time-to")
+}
+
+func TestFilterMatchStats(t *testing.T) {
+	f := &Filter{MinExecCount: 2, MinAvgLatency: time.Second}
+	require.True(t, f.matchStats(&StmtSummary{ExecCount: 2, SumLatency: 2 * time.Second}))
+	require.False(t, f.matchStats(&StmtSummary{ExecCount: 1, SumLatency: 2 * time.Second}))
+	require.False(t, f.matchStats(&StmtSummary{ExecCount: 2, SumLatency: time.Second}))
+	// the latency rule is skipped when the latency is unavailable
+	require.True(t, f.matchStats(&StmtSummary{ExecCount: 2, LatencyUnavailable: true}))
+}
@@ -0,0 +1,202 @@
+package source
+
+import (
+	"encoding/hex"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	driver "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// argumentsPrefix is written after the SQL of an execution of prepared
+// statement, see (*PlanCacheParamList).String().
+const argumentsPrefix = " [arguments: "
+
+var (
+	numberRE        = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+	truncatedArgRE  = regexp.MustCompile(` len\(\d+\)$`)
+	quotedArgSuffix = regexp.MustCompile(`"( len\(\d+\))?$`)
+)
+
+// interpolateSQLMayHasBrackets processed the SQL returned by TiDB like `SELECT
+// ... [arguments: (6249305, 6249404)]` to a valid SQL statement.
+//
+// The arguments are formatted by types.DatumsToString, which wraps them in
+// brackets when there are more than one, quotes strings by `"` without
+// escaping, writes NULL as NULL and the others by Datum.ToString. So the
+// argument list is split according to the number of parameter markers found by
+// the parser, and each argument is turned into a typed SQL literal replacing
+// the marker at its offset. The input is returned unchanged if it can't be
+// interpolated.
+func interpolateSQLMayHasBrackets(sqlMayHasBrackets string, p *parser.Parser) string {
+	_, err := p.ParseOneStmt(sqlMayHasBrackets, "", "")
+	if err == nil {
+		return sqlMayHasBrackets
+	}
+	if !strings.HasSuffix(sqlMayHasBrackets, "]") {
+		return sqlMayHasBrackets
+	}
+
+	// the prefix may also appear in a string literal of the SQL or in the
+	// arguments, try every occurrence until the SQL part can be parsed
+	for from := 0; ; {
+		i := strings.Index(sqlMayHasBrackets[from:], argumentsPrefix)
+		if i == -1 {
+			return sqlMayHasBrackets
+		}
+		index := from + i
+		from = index + 1
+
+		sql := sqlMayHasBrackets[:index]
+		argsStr := sqlMayHasBrackets[index+len(argumentsPrefix) : len(sqlMayHasBrackets)-1]
+		if ret, ok := interpolate(sql, argsStr, p); ok {
+			return ret
+		}
+	}
+}
+
+// interpolate replaces the parameter markers of `sql` with the arguments in
+// `argsStr`. It returns false if the SQL can't be parsed or the arguments don't
+// match the parameter markers.
+func interpolate(sql, argsStr string, p *parser.Parser) (string, bool) {
+	stmt, err := p.ParseOneStmt(sql, "", "")
+	if err != nil {
+		return "", false
+	}
+	collector := &paramMarkerCollector{}
+	stmt.Accept(collector)
+	offsets := collector.offsets
+	if len(offsets) == 0 {
+		return "", false
+	}
+	slices.Sort(offsets)
+
+	if len(offsets) > 1 {
+		if len(argsStr) < 2 || argsStr[0] != '(' || argsStr[len(argsStr)-1] != ')' {
+			return "", false
+		}
+		argsStr = argsStr[1 : len(argsStr)-1]
+	}
+	args, ok := splitArguments(argsStr, len(offsets))
+	if !ok {
+		return "", false
+	}
+
+	var sb strings.Builder
+	last := 0
+	for i, offset := range offsets {
+		if offset >= len(sql) || sql[offset] != '?' {
+			return "", false
+		}
+		sb.WriteString(sql[last:offset])
+		sb.WriteString(argumentToLiteral(args[i]))
+		last = offset + 1
+	}
+	sb.WriteString(sql[last:])
+	return sb.String(), true
+}
+
+type paramMarkerCollector struct {
+	offsets []int
+}
+
+func (c *paramMarkerCollector) Enter(n ast.Node) (ast.Node, bool) {
+	if m, ok := n.(*driver.ParamMarkerExpr); ok {
+		c.offsets = append(c.offsets, m.Offset)
+	}
+	return n, false
+}
+
+func (c *paramMarkerCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+// splitArguments splits the arguments separated by ", " into n parts. Because
+// the string arguments are not escaped, the separator may also appear in an
+// argument, so it backtracks to find the first split that every part is a
+// valid argument.
+func splitArguments(s string, n int) ([]string, bool) {
+	if n == 1 {
+		if !isValidArgument(s) {
+			return nil, false
+		}
+		return []string{s}, true
+	}
+	const sep = ", "
+	for i := 0; ; {
+		j := strings.Index(s[i:], sep)
+		if j == -1 {
+			return nil, false
+		}
+		arg := s[:i+j]
+		if isValidArgument(arg) {
+			if rest, ok := splitArguments(s[i+j+len(sep):], n-1); ok {
+				return append([]string{arg}, rest...), true
+			}
+		}
+		i += j + len(sep)
+	}
+}
+
+// isValidArgument checks if the string can be one argument formatted by
+// types.DatumsToString. A string argument must be quoted on both sides.
+func isValidArgument(arg string) bool {
+	if arg == "" {
+		// empty bytes
+		return true
+	}
+	if arg[0] == '"' {
+		return len(arg) >= 2 && quotedArgSuffix.MatchString(arg[1:])
+	}
+	return true
+}
+
+// argumentToLiteral converts one argument formatted by types.DatumsToString to
+// the SQL literal.
+func argumentToLiteral(arg string) string {
+	// the argument longer than 2048 bytes is truncated and the original length
+	// is appended, we can only use the prefix
+	arg = truncatedArgRE.ReplaceAllString(arg, "")
+
+	switch {
+	case arg == "NULL":
+		return "NULL"
+	case len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"':
+		return quoteString(arg[1 : len(arg)-1])
+	case numberRE.MatchString(arg):
+		return arg
+	default:
+		// datetime, duration, bytes, enum, set, JSON and so on. Their string
+		// representation can be converted to the column type implicitly.
+		return quoteString(arg)
+	}
+}
+
+// quoteString returns the string literal of s. Binary data which is not a
+// printable UTF-8 string is written as a hexadecimal literal.
+func quoteString(s string) string {
+	if !utf8.ValidString(s) || strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) != -1 {
+		return "0x" + hex.EncodeToString([]byte(s))
+	}
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'':
+			sb.WriteString("''")
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
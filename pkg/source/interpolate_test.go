@@ -0,0 +1,128 @@
+package source
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterpolateSQLMayHasBrackets(t *testing.T) {
+	cases := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{
+			name:     "no arguments",
+			sql:      "SELECT * FROM t WHERE a = 1",
+			expected: "SELECT * FROM t WHERE a = 1",
+		},
+		{
+			name:     "prefix in string literal",
+			sql:      "SELECT * FROM t WHERE a = ' [arguments: (1, 2)]'",
+			expected: "SELECT * FROM t WHERE a = ' [arguments: (1, 2)]'",
+		},
+		{
+			name:     "integers",
+			sql:      "SELECT DISTINCT c FROM sbtest2 WHERE id BETWEEN ? AND ? ORDER BY c [arguments: (6249305, 6249404)]",
+			expected: "SELECT DISTINCT c FROM sbtest2 WHERE id BETWEEN 6249305 AND 6249404 ORDER BY c",
+		},
+		{
+			name:     "single argument without brackets",
+			sql:      "SELECT w_street_1, w_street_2, w_city, w_state, w_zip, w_name FROM warehouse WHERE w_id = ? [arguments: 270]",
+			expected: "SELECT w_street_1, w_street_2, w_city, w_state, w_zip, w_name FROM warehouse WHERE w_id = 270",
+		},
+		{
+			name:     "negative, float and decimal",
+			sql:      "SELECT * FROM t WHERE a > ? AND b < ? AND c = ? [arguments: (-1, 1.5e-3, 12.3400)]",
+			expected: "SELECT * FROM t WHERE a > -1 AND b < 1.5e-3 AND c = 12.3400",
+		},
+		{
+			name:     "strings",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: (\"abc\", \"\")]",
+			expected: "SELECT * FROM t WHERE a = 'abc' AND b = ''",
+		},
+		{
+			name:     "string looks like number is still a string",
+			sql:      "SELECT * FROM t WHERE a = ? [arguments: \"123\"]",
+			expected: "SELECT * FROM t WHERE a = '123'",
+		},
+		{
+			name:     "string contains separator",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: (\"x, y\", 3)]",
+			expected: "SELECT * FROM t WHERE a = 'x, y' AND b = 3",
+		},
+		{
+			// ambiguous, the first valid split is used
+			name:     "string contains quoted separator",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: (\"x\", \"y\", \"z\")]",
+			expected: "SELECT * FROM t WHERE a = 'x' AND b = 'y\", \"z'",
+		},
+		{
+			name:     "string contains quotes and backslash",
+			sql:      "SELECT * FROM t WHERE a = ? [arguments: \"it's a \\ \"test\"\"]",
+			expected: "SELECT * FROM t WHERE a = 'it''s a \\\\ \"test\"'",
+		},
+		{
+			name:     "question mark in string literal and comment",
+			sql:      "SELECT /* ? */ * FROM t WHERE a = '?' AND b = ? AND `c?` = ? [arguments: (1, 2)]",
+			expected: "SELECT /* ? */ * FROM t WHERE a = '?' AND b = 1 AND `c?` = 2",
+		},
+		{
+			name:     "question mark in argument",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: (\"?\", \"??\")]",
+			expected: "SELECT * FROM t WHERE a = '?' AND b = '??'",
+		},
+		{
+			name:     "NULL",
+			sql:      "SELECT * FROM t WHERE a <=> ? AND b = ? [arguments: (NULL, 1)]",
+			expected: "SELECT * FROM t WHERE a <=> NULL AND b = 1",
+		},
+		{
+			name:     "datetime and duration",
+			sql:      "SELECT * FROM t WHERE a >= ? AND b < ? [arguments: (2024-12-01 10:00:00.123456, 10:00:00)]",
+			expected: "SELECT * FROM t WHERE a >= '2024-12-01 10:00:00.123456' AND b < '10:00:00'",
+		},
+		{
+			name:     "binary",
+			sql:      "SELECT * FROM t WHERE a = ? [arguments: \x00\x01\xff]",
+			expected: "SELECT * FROM t WHERE a = 0x0001ff",
+		},
+		{
+			name:     "printable bytes",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: (abc, 1)]",
+			expected: "SELECT * FROM t WHERE a = 'abc' AND b = 1",
+		},
+		{
+			name:     "truncated string",
+			sql:      "SELECT * FROM t WHERE a = ? [arguments: \"abc\" len(3000)]",
+			expected: "SELECT * FROM t WHERE a = 'abc'",
+		},
+		{
+			name:     "IN list and LIMIT",
+			sql:      "SELECT * FROM t WHERE a IN (?, ?, ?) LIMIT ? [arguments: (1, \"b\", NULL, 10)]",
+			expected: "SELECT * FROM t WHERE a IN (1, 'b', NULL) LIMIT 10",
+		},
+		{
+			name:     "prefix in string argument",
+			sql:      "SELECT * FROM t WHERE a = ? [arguments: \" [arguments: x]\"]",
+			expected: "SELECT * FROM t WHERE a = ' [arguments: x]'",
+		},
+		{
+			name:     "argument count mismatch",
+			sql:      "SELECT * FROM t WHERE a = ? AND b = ? [arguments: 1]",
+			expected: "SELECT * FROM t WHERE a = ? AND b = ? [arguments: 1]",
+		},
+	}
+
+	p := parser.New()
+	for _, c := range cases {
+		require.Equal(t, c.expected, interpolateSQLMayHasBrackets(c.sql, p), c.name)
+		if !strings.HasSuffix(c.name, "mismatch") {
+			_, err := p.ParseOneStmt(c.expected, "", "")
+			require.NoError(t, err, c.name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+)
+
+// ReadPlanReplayerDump runs PLAN REPLAYER DUMP EXPLAIN for the statement on the
+// TiDB cluster and downloads the zip file from the status port. The zip file
+// contains the structure, stats, session variables and bindings that the
+// optimizer sees when it generates the plan. `statusURL` is the same as
+// ReadTableStats.
+func ReadPlanReplayerDump(
+	ctx context.Context,
+	db *sql.DB,
+	client *http.Client,
+	statusURL string,
+	schema, stmt string,
+) ([]byte, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+
+	if schema != "" {
+		if _, err = conn.ExecContext(ctx, "USE "+util.EscapeIdentifier(schema)); err != nil {
+			return nil, errors.Annotatef(err, "failed to use database %s", schema)
+		}
+	}
+	query := "PLAN REPLAYER DUMP EXPLAIN " + stmt
+	var token string
+	if err = conn.QueryRowContext(ctx, query).Scan(&token); err != nil {
+		return nil, errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+
+	url := fmt.Sprintf("%s/plan_replayer/dump/%s", statusURL, token)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Errorf("error when build HTTP request to URL (%s): %s", url, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Errorf("error when request URL (%s): %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.Errorf("error when request URL (%s): HTTP status not 200, got %d", url, resp.StatusCode)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Errorf("error when read response body from URL (%s): %s", url, err)
+	}
+	return content, nil
+}
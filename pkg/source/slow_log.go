@@ -0,0 +1,355 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/util/plancodec"
+	"go.uber.org/zap"
+)
+
+// below are the format of TiDB slow log, see (*SessionVars).SlowLogFormat.
+const (
+	slowLogRowPrefix   = "# "
+	slowLogStartPrefix = "# Time: "
+	slowLogPlanPrefix  = "tidb_decode_plan('"
+	slowLogPlanSuffix  = "')"
+)
+
+// aggregateWindow is the time window of the aggregated statements read from the
+// sources other than statement summary, it's used as SummaryBeginTimes of
+// StmtSummary.
+const aggregateWindow = time.Hour
+
+// SlowLogOptions is the options of ReadSlowLog.
+type SlowLogOptions struct {
+	// Files are the slow log files to read, glob patterns are supported. If it's
+	// empty, INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY is read.
+	Files []string
+	// Filter skips the statements not matching it, nil filter means only the
+	// default STMT_TYPE rule is used.
+	Filter *Filter
+}
+
+// slowLogEntry is one entry of the slow log.
+type slowLogEntry struct {
+	instance        string
+	time            time.Time
+	user            string
+	db              string
+	query           string
+	digest          string
+	plan            string
+	planDigest      string
+	queryTime       float64
+	isInternal      bool
+	planFromBinding bool
+}
+
+// ReadSlowLog reads the TiDB slow log and turns the entries into StmtSummary.
+// Like ReadStmtSummary, the entries with the same SQL digest and plan digest are
+// aggregated, the StmtSummary are emitted into `outCh` after all entries are
+// read and the channel is not closed. The slow log has the full SQL and the
+// encoded plan, so the statements truncated in statement summary can be
+// captured.
+func ReadSlowLog(
+	ctx context.Context,
+	db *sql.DB,
+	opts *SlowLogOptions,
+	outCh chan<- *StmtSummary,
+) error {
+	if opts.Filter == nil {
+		opts.Filter = &Filter{}
+	}
+	p := util.ParserPool.Get().(*parser.Parser)
+	defer util.ParserPool.Put(p)
+	r := &slowLogReader{
+		agg: &stmtSummaryAggregator{
+			filter:      opts.Filter,
+			p:           p,
+			byID:        make(map[string]*StmtSummary),
+			order:       make([]*StmtSummary, 0, 64),
+			seenRecords: make(map[string]struct{}),
+		},
+		rejected: make(map[string]struct{}),
+	}
+
+	if len(opts.Files) == 0 {
+		if err := r.readTable(ctx, db); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		for _, pattern := range opts.Files {
+			files, err := filepath.Glob(pattern)
+			if err != nil {
+				return errors.Annotatef(err, "invalid slow log file pattern %s", pattern)
+			}
+			if len(files) == 0 {
+				return errors.Errorf("no slow log file matches %s", pattern)
+			}
+			for _, file := range files {
+				if err = r.readFile(file); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+
+	for _, s := range r.agg.order {
+		if !opts.Filter.matchStats(s) {
+			continue
+		}
+		select {
+		case outCh <- s:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+	return nil
+}
+
+type slowLogReader struct {
+	agg *stmtSummaryAggregator
+	// rejected is the ID of StmtSummary rejected by the filter rules of SQL
+	// digest level, to avoid parsing the SQL again.
+	rejected map[string]struct{}
+}
+
+func (r *slowLogReader) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Annotatef(err, "open slow log file %s", path)
+	}
+	defer f.Close()
+	err = parseSlowLog(f, path, func(e *slowLogEntry) {
+		r.add(e)
+	})
+	return errors.Annotatef(err, "read slow log file %s", path)
+}
+
+func (r *slowLogReader) readTable(ctx context.Context, db *sql.DB) error {
+	f := r.agg.filter
+	from := int64(1)
+	if !f.SummaryBeginTimeFrom.IsZero() {
+		from = f.SummaryBeginTimeFrom.Unix()
+	}
+	to := time.Now().Unix() + 1
+	if !f.SummaryBeginTimeTo.IsZero() {
+		to = f.SummaryBeginTimeTo.Unix()
+	}
+	// CLUSTER_SLOW_QUERY only reads the files in the time range, use
+	// UNIX_TIMESTAMP and FROM_UNIXTIME to be independent of the time zone of
+	// the session
+	query := `
+		SELECT
+			INSTANCE,
+			UNIX_TIMESTAMP(Time),
+			IFNULL(User, ''),
+			IFNULL(DB, ''),
+			Query,
+			Digest,
+			IFNULL(Plan, ''),
+			IFNULL(Plan_digest, ''),
+			Query_time,
+			Plan_from_binding
+		FROM INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY
+		WHERE Is_internal = 0 AND Time >= FROM_UNIXTIME(?) AND Time < FROM_UNIXTIME(?)`
+	rows, err := db.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			e        slowLogEntry
+			unixTime float64
+		)
+		err = rows.Scan(
+			&e.instance,
+			&unixTime,
+			&e.user,
+			&e.db,
+			&e.query,
+			&e.digest,
+			&e.plan,
+			&e.planDigest,
+			&e.queryTime,
+			&e.planFromBinding,
+		)
+		if err != nil {
+			return errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+		e.time = time.Unix(0, int64(unixTime*float64(time.Second)))
+		e.query = strings.TrimSuffix(strings.TrimSpace(e.query), ";")
+		r.add(&e)
+	}
+	return errors.Annotatef(rows.Err(), "failed to get rows for query: %s", query)
+}
+
+// add aggregates one slow log entry.
+func (r *slowLogReader) add(e *slowLogEntry) {
+	if e.isInternal || e.digest == "" || e.planDigest == "" {
+		return
+	}
+	f := r.agg.filter
+	if !f.matchStmt(e.db, e.digest) || !f.matchUserAndTime(e.user, e.time) {
+		return
+	}
+
+	s := &StmtSummary{
+		Schema:            e.db,
+		SQLDigest:         e.digest,
+		PlanDigest:        e.planDigest,
+		ExecCount:         1,
+		SumLatency:        time.Duration(e.queryTime * float64(time.Second)),
+		PlanInBinding:     e.planFromBinding,
+		Instances:         []string{e.instance},
+		SummaryBeginTimes: []time.Time{e.time.Truncate(aggregateWindow)},
+	}
+	id := s.ID()
+	if _, ok := r.rejected[id]; ok {
+		return
+	}
+	if _, ok := r.agg.byID[id]; !ok {
+		// the first entry of the ID, check the rules of SQL digest level
+		stmt, err := r.agg.p.ParseOneStmt(e.query, "", "")
+		if err == nil && !f.matchStmtType(ast.GetStmtLabel(stmt)) {
+			r.rejected[id] = struct{}{}
+			return
+		}
+		planStr, err := decodeSlowLogPlan(e.plan)
+		if err != nil {
+			util.Logger.Warn("failed to decode plan in slow log, skip it",
+				zap.String("sql_digest", e.digest),
+				zap.String("plan_digest", e.planDigest),
+				zap.Error(err))
+			r.rejected[id] = struct{}{}
+			return
+		}
+		s.PlanStr = planStr
+	}
+	r.agg.add(s, e.query, "")
+	if _, ok := r.agg.byID[id]; !ok {
+		r.rejected[id] = struct{}{}
+	}
+}
+
+// decodeSlowLogPlan decodes the Plan field of slow log to the same format of the
+// PLAN column of statement summary.
+func decodeSlowLogPlan(plan string) (string, error) {
+	plan = strings.TrimPrefix(plan, slowLogPlanPrefix)
+	plan = strings.TrimSuffix(plan, slowLogPlanSuffix)
+	if plan == "" {
+		return "", errors.New("empty plan")
+	}
+	ret, err := plancodec.DecodePlan(plan)
+	return ret, errors.Trace(err)
+}
+
+// parseSlowLog parses the slow log from `r` and calls fn on every entry. Every
+// entry starts with the "# Time: " row, followed by other "# Key: value" rows
+// and the query. The query may have multiple lines and may be prefixed with a
+// "use db;" line.
+func parseSlowLog(r io.Reader, instance string, fn func(*slowLogEntry)) error {
+	reader := bufio.NewReader(r)
+	var (
+		e          *slowLogEntry
+		queryLines []string
+	)
+	finish := func() {
+		if e == nil || len(queryLines) == 0 {
+			return
+		}
+		if len(queryLines) > 1 {
+			first := strings.TrimSpace(queryLines[0])
+			if strings.HasPrefix(strings.ToLower(first), "use ") && strings.HasSuffix(first, ";") {
+				if e.db == "" {
+					e.db = strings.Trim(strings.TrimSuffix(first[4:], ";"), " `")
+				}
+				queryLines = queryLines[1:]
+			}
+		}
+		e.query = strings.TrimSuffix(strings.TrimSpace(strings.Join(queryLines, "\n")), ";")
+		fn(e)
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return errors.Trace(err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, slowLogStartPrefix):
+			finish()
+			e = &slowLogEntry{instance: instance}
+			queryLines = queryLines[:0]
+			t, err2 := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, slowLogStartPrefix))
+			if err2 != nil {
+				return errors.Annotatef(err2, "parse time of slow log row %q", line)
+			}
+			e.time = t
+		case e == nil:
+			// skip the content before the first entry
+		case len(queryLines) == 0 && strings.HasPrefix(line, slowLogRowPrefix):
+			parseSlowLogRow(strings.TrimPrefix(line, slowLogRowPrefix), e)
+		case line != "" || len(queryLines) > 0:
+			queryLines = append(queryLines, line)
+		}
+
+		if err == io.EOF {
+			finish()
+			return nil
+		}
+	}
+}
+
+// parseSlowLogRow parses the "Key: value" pairs of a row, some rows have more
+// than one pair separated by space.
+func parseSlowLogRow(row string, e *slowLogEntry) {
+	if strings.HasPrefix(row, "User@Host: ") {
+		user := strings.TrimPrefix(row, "User@Host: ")
+		if i := strings.Index(user, "["); i >= 0 {
+			user = user[:i]
+		}
+		e.user = user
+		return
+	}
+	fields := strings.Fields(row)
+	for i := 0; i+1 < len(fields); i++ {
+		key, ok := strings.CutSuffix(fields[i], ":")
+		if !ok {
+			continue
+		}
+		value := fields[i+1]
+		i++
+		switch key {
+		case "DB":
+			e.db = value
+		case "Digest":
+			e.digest = value
+		case "Plan_digest":
+			e.planDigest = value
+		case "Plan":
+			e.plan = value
+		case "Query_time":
+			e.queryTime, _ = strconv.ParseFloat(value, 64)
+		case "Is_internal":
+			e.isInternal = value == "true"
+		case "Plan_from_binding":
+			e.planFromBinding = value == "true"
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/tidb/pkg/util/plancodec"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestPlan() string {
+	var buf bytes.Buffer
+	plancodec.EncodePlanNode(0, "5", plancodec.TypeTableReader, 10, plancodec.EncodeTaskType(true, 0), "data:TableFullScan_4", "", "", "", "", &buf)
+	plancodec.EncodePlanNode(1, "4", plancodec.TypeTableFullScan, 10, plancodec.EncodeTaskType(false, 0), "table:t, keep order:false", "", "", "", "", &buf)
+	return plancodec.Compress(buf.Bytes())
+}
+
+func TestReadSlowLogFile(t *testing.T) {
+	plan := encodeTestPlan()
+	content := `# Time: 2024-12-01T10:10:00.123456+08:00
+# Txn_start_ts: 1
+# User@Host: root[root] @ 127.0.0.1 [127.0.0.1]
+# Query_time: 1.5
+# DB: test
+# Is_internal: false
+# Digest: sql1
+# Num_cop_tasks: 1
+# Plan: tidb_decode_plan('` + plan + `')
+# Plan_digest: plan1
+# Plan_from_binding: false
+use test;
+SELECT *
+FROM t WHERE a > 1;
+# Time: 2024-12-01T11:20:00+08:00
+# User@Host: root[root] @ 127.0.0.1 [127.0.0.1]
+# Query_time: 0.5
+# Is_internal: false
+# Digest: sql1
+# Plan: tidb_decode_plan('` + plan + `')
+# Plan_digest: plan1
+use test;
+SELECT * FROM t WHERE a > 2;
+# Time: 2024-12-01T11:30:00+08:00
+# Query_time: 3
+# DB: mysql
+# Is_internal: true
+# Digest: sql2
+# Plan: tidb_decode_plan('` + plan + `')
+# Plan_digest: plan2
+SELECT * FROM mysql.user;
+# Time: 2024-12-01T11:40:00+08:00
+# Query_time: 3
+# DB: test
+# Is_internal: false
+# Digest: sql3
+# Plan: tidb_decode_plan('` + plan + `')
+# Plan_digest: plan3
+SELECT * FROM t WHERE b > 1;
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb-slow.log")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	ch := make(chan *StmtSummary, 10)
+	err := ReadSlowLog(context.Background(), nil, &SlowLogOptions{
+		Files:  []string{filepath.Join(dir, "*.log")},
+		Filter: &Filter{MinExecCount: 2},
+	}, ch)
+	require.NoError(t, err)
+	close(ch)
+
+	var got []*StmtSummary
+	for s := range ch {
+		got = append(got, s)
+	}
+	require.Len(t, got, 1)
+	s := got[0]
+	require.Equal(t, "test", s.Schema)
+	require.Equal(t, "SELECT *\nFROM t WHERE a > 1", s.SQL)
+	require.Equal(t, [][2]string{{"test", "t"}}, s.TableNamesNeedToSync)
+	require.Equal(t, "sql1", s.SQLDigest)
+	require.Equal(t, "plan1", s.PlanDigest)
+	require.Equal(t, 2, s.ExecCount)
+	require.Equal(t, 2*time.Second, s.SumLatency)
+	require.Equal(t, []string{path}, s.Instances)
+	require.Len(t, s.SummaryBeginTimes, 2)
+	require.True(t, s.SummaryBeginTimes[0].Equal(time.Date(2024, 12, 1, 2, 0, 0, 0, time.UTC)))
+	require.True(t, s.SummaryBeginTimes[1].Equal(time.Date(2024, 12, 1, 3, 0, 0, 0, time.UTC)))
+	require.Contains(t, s.PlanStr, "TableReader_5")
+	require.Contains(t, s.PlanStr, "TableFullScan_4")
+
+	err = ReadSlowLog(context.Background(), nil, &SlowLogOptions{
+		Files: []string{filepath.Join(dir, "not-exist-*.log")},
+	}, ch)
+	require.ErrorContains(t, err, "no slow log file matches")
+}
+
+func TestReadSlowLogTable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	plan := "tidb_decode_plan('" + encodeTestPlan() + "')"
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY").
+		WithArgs(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC).Unix(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"INSTANCE", "Time", "User", "DB", "Query", "Digest", "Plan", "Plan_digest", "Query_time", "Plan_from_binding",
+		}).
+			AddRow("tidb-0", 1733047200.5, "root", "test", "SELECT * FROM t WHERE a > 1;", "sql1", plan, "plan1", 0.25, false).
+			AddRow("tidb-1", 1733047300, "root", "test", "SELECT * FROM t WHERE a > 2;", "sql1", plan, "plan1", 0.75, false).
+			AddRow("tidb-1", 1733047300, "app", "test", "SELECT * FROM t WHERE a > 3;", "sql1", plan, "plan1", 1, false).
+			AddRow("tidb-1", 1733047300, "root", "test", "SHOW TABLES", "sql2", plan, "plan2", 1, false))
+
+	ch := make(chan *StmtSummary, 10)
+	err = ReadSlowLog(context.Background(), db, &SlowLogOptions{
+		Filter: &Filter{
+			ExcludeSampleUsers:   []string{"app"},
+			SummaryBeginTimeFrom: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}, ch)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	close(ch)
+
+	var got []*StmtSummary
+	for s := range ch {
+		got = append(got, s)
+	}
+	require.Len(t, got, 1)
+	require.Equal(t, "SELECT * FROM t WHERE a > 1", got[0].SQL)
+	require.Equal(t, 2, got[0].ExecCount)
+	require.Equal(t, time.Second, got[0].SumLatency)
+	require.Equal(t, []string{"tidb-0", "tidb-1"}, got[0].Instances)
+	require.Contains(t, got[0].PlanStr, "TableReader_5")
+}
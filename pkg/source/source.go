@@ -0,0 +1,170 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// Source is where the statements to compare are read from. The pipeline only
+// depends on this interface, so a new capture origin can be added by
+// implementing it.
+type Source interface {
+	// Read emits the statements into outCh. When StmtSummary.PlanInBinding is
+	// true, the binding is attached to StmtSummary.Binding. It returns nil when
+	// all statements are emitted, and in any cases it will not close outCh.
+	Read(ctx context.Context, outCh chan<- *StmtSummary) error
+	// Describe returns the human-readable description used in the report.
+	Describe() string
+}
+
+// NewStmtSummarySource creates a Source of the statement summary tables, see
+// ReadStmtSummary. loadCheckpoint is called before every Read to fill
+// opts.Checkpoint if it's not nil.
+func NewStmtSummarySource(
+	db *sql.DB,
+	opts *ReadOptions,
+	loadCheckpoint func() (*ReadCheckpoint, error),
+) Source {
+	return &stmtSummarySource{db: db, opts: opts, loadCheckpoint: loadCheckpoint}
+}
+
+type stmtSummarySource struct {
+	db             *sql.DB
+	opts           *ReadOptions
+	loadCheckpoint func() (*ReadCheckpoint, error)
+}
+
+func (s *stmtSummarySource) Read(ctx context.Context, outCh chan<- *StmtSummary) error {
+	opts := *s.opts
+	if s.loadCheckpoint != nil {
+		cp, err := s.loadCheckpoint()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		opts.Checkpoint = cp
+	}
+	return readWithBinding(ctx, s.db, outCh, func(ctx context.Context, ch chan<- *StmtSummary) error {
+		return ReadStmtSummary(ctx, s.db, &opts, ch)
+	})
+}
+
+func (s *stmtSummarySource) Describe() string {
+	return "system table " + strings.Join(s.opts.Window.Tables(), ", ")
+}
+
+// NewSlowLogSource creates a Source of the slow log, see ReadSlowLog.
+func NewSlowLogSource(db *sql.DB, opts *SlowLogOptions) Source {
+	return &slowLogSource{db: db, opts: opts}
+}
+
+type slowLogSource struct {
+	db   *sql.DB
+	opts *SlowLogOptions
+}
+
+func (s *slowLogSource) Read(ctx context.Context, outCh chan<- *StmtSummary) error {
+	return readWithBinding(ctx, s.db, outCh, func(ctx context.Context, ch chan<- *StmtSummary) error {
+		return ReadSlowLog(ctx, s.db, s.opts, ch)
+	})
+}
+
+func (s *slowLogSource) Describe() string {
+	if len(s.opts.Files) == 0 {
+		return "system table CLUSTER_SLOW_QUERY"
+	}
+	return "slow log files " + strings.Join(s.opts.Files, ", ")
+}
+
+// NewWorkloadSource creates a Source of the workload files, see ReadWorkload.
+func NewWorkloadSource(db *sql.DB, opts *WorkloadOptions) Source {
+	return &workloadSource{db: db, opts: opts}
+}
+
+type workloadSource struct {
+	db   *sql.DB
+	opts *WorkloadOptions
+}
+
+func (s *workloadSource) Read(ctx context.Context, outCh chan<- *StmtSummary) error {
+	return readWithBinding(ctx, s.db, outCh, func(ctx context.Context, ch chan<- *StmtSummary) error {
+		return ReadWorkload(ctx, s.db, s.opts, ch)
+	})
+}
+
+func (s *workloadSource) Describe() string {
+	return "workload files " + strings.Join(s.opts.Files, ", ")
+}
+
+// readWithBinding runs `read` and reads the bindings from `db` concurrently.
+// The StmtSummary emitted by `read` are attached with their bindings and
+// forwarded to outCh.
+func readWithBinding(
+	ctx context.Context,
+	db *sql.DB,
+	outCh chan<- *StmtSummary,
+	read func(context.Context, chan<- *StmtSummary) error,
+) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+
+	var allBindings map[string]Binding
+	readBindingDone := make(chan struct{})
+	eg.Go(func() error {
+		var err error
+		allBindings, err = ReadBinding(egCtx, db)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		close(readBindingDone)
+		return nil
+	})
+
+	readCh := make(chan *StmtSummary, cap(outCh))
+	eg.Go(func() error {
+		if err := read(egCtx, readCh); err != nil {
+			return errors.Trace(err)
+		}
+		close(readCh)
+		return nil
+	})
+
+	eg.Go(func() error {
+		// wait binding is loaded
+		select {
+		case <-readBindingDone:
+		case <-egCtx.Done():
+			return nil
+		}
+
+		for {
+			select {
+			case s, ok := <-readCh:
+				if !ok {
+					return nil
+				}
+				if s.PlanInBinding {
+					b, ok2 := allBindings[s.BindingDigest]
+					if !ok2 {
+						util.Logger.Warn("binding not found", zap.String("sql_digest", s.SQLDigest))
+					} else {
+						s.Binding = b
+					}
+				}
+				select {
+				case outCh <- s:
+				case <-egCtx.Done():
+					return nil
+				}
+			case <-egCtx.Done():
+				return nil
+			}
+		}
+	})
+
+	return errors.Trace(eg.Wait())
+}
@@ -0,0 +1,47 @@
+package source
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWithBinding(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM mysql.bind_info").
+		WillReturnRows(sqlmock.NewRows([]string{"original_sql", "bind_sql", "sql_digest"}).
+			AddRow("select * from `test` . `t`", "SELECT /*+ use_index(t, idx)*/ * FROM test.t", "bind1"))
+
+	s1 := &StmtSummary{SQLDigest: "sql1", PlanInBinding: true, BindingDigest: "bind1"}
+	s2 := &StmtSummary{SQLDigest: "sql2", PlanInBinding: true, BindingDigest: "bind2"}
+	s3 := &StmtSummary{SQLDigest: "sql3"}
+	ch := make(chan *StmtSummary, 3)
+	err = readWithBinding(context.Background(), db, ch, func(_ context.Context, outCh chan<- *StmtSummary) error {
+		outCh <- s1
+		outCh <- s2
+		outCh <- s3
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	close(ch)
+
+	var got []*StmtSummary
+	for s := range ch {
+		got = append(got, s)
+	}
+	require.Equal(t, []*StmtSummary{s1, s2, s3}, got)
+	require.Equal(t, "SELECT /*+ use_index(t, idx)*/ * FROM test.t", s1.Binding.BindSQL)
+	require.Empty(t, s2.Binding)
+	require.Empty(t, s3.Binding)
+
+	src := NewWorkloadSource(db, &WorkloadOptions{Files: []string{"a.sql", "b.jsonl"}})
+	require.Equal(t, "workload files a.sql, b.jsonl", src.Describe())
+	src = NewStmtSummarySource(db, &ReadOptions{Window: WindowBoth}, nil)
+	require.Equal(t, "system table CLUSTER_STATEMENTS_SUMMARY, CLUSTER_STATEMENTS_SUMMARY_HISTORY", src.Describe())
+}
@@ -5,13 +5,14 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"regexp"
 	"slices"
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/lance6716/plan-change-capturer/pkg/util"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tidb/pkg/parser"
@@ -20,9 +21,10 @@ import (
 	"go.uber.org/zap"
 )
 
-// StmtSummary represents one record in
-// INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY. The Instance +
-// SummaryBeginTime + SQLDigest + PlanDigest fields are used as the ID of the
+// StmtSummary represents the records in
+// INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY with the same SQLDigest
+// and PlanDigest, which are aggregated from different instances and time
+// windows. The SQLDigest + PlanDigest fields are used as the ID of the
 // StmtSummary.
 type StmtSummary struct {
 	// fields from the table
@@ -34,28 +36,335 @@ type StmtSummary struct {
 	PlanDigest           string
 	ExecCount            int
 	SumLatency           time.Duration
-	Instance             string
-	SummaryBeginTime     time.Time
 	PlanInBinding        bool
+	// LatencyUnavailable means the source doesn't provide the latency, and
+	// SumLatency is meaningless.
+	LatencyUnavailable bool
+	// Instances and SummaryBeginTimes are the TiDB instances and the time
+	// windows of the aggregated records, sorted and deduplicated.
+	Instances         []string
+	SummaryBeginTimes []time.Time
 	// computed fields
 	HasParseError bool
 	BindingDigest string
 	Binding       Binding
+	// Unsupported is the reason why the statement can't be compared, empty
+	// means it's supported. The unsupported statements are still emitted to be
+	// counted in the report.
+	Unsupported string
 }
 
-// ReadStmtSummary reads the statement summary from the TiDB cluster. It emits
-// the StmtSummary one by one into `outCh`, or return error. When work is
-// completed, it will return nil. In any cases it will not close the channel.
+// UnsupportedTruncated is the StmtSummary.Unsupported of the statements whose
+// SQL text is truncated by TiDB and can't be recovered.
+const UnsupportedTruncated = "SQL text is truncated"
+
+var truncatedSQLRE = regexp.MustCompile(`\(len:\d+\)$`)
+
+// isTruncatedSQL checks if the SQL text is truncated by TiDB, which appends the
+// original length like "(len:5000)". The "(len:" elsewhere is a part of the SQL.
+func isTruncatedSQL(sql string) bool {
+	return truncatedSQLRE.MatchString(sql)
+}
+
+// ID returns the identifier of the StmtSummary, see the comment of StmtSummary.
+func (s *StmtSummary) ID() string {
+	return s.SQLDigest + "/" + s.PlanDigest
+}
+
+// Merge aggregates `other` which has the same ID into s. The statistics are
+// summed up, and the other fields of s are kept because they are same or just
+// samples.
+func (s *StmtSummary) Merge(other *StmtSummary) {
+	s.ExecCount += other.ExecCount
+	s.SumLatency += other.SumLatency
+	s.Instances = mergeSorted(s.Instances, other.Instances, strings.Compare)
+	s.SummaryBeginTimes = mergeSorted(s.SummaryBeginTimes, other.SummaryBeginTimes, time.Time.Compare)
+}
+
+func mergeSorted[T any](a, b []T, cmp func(T, T) int) []T {
+	ret := append(slices.Clone(a), b...)
+	slices.SortFunc(ret, cmp)
+	return slices.CompactFunc(ret, func(x, y T) bool { return cmp(x, y) == 0 })
+}
+
+// StmtSummaryWindow decides which statement summary tables are read.
+type StmtSummaryWindow string
+
+const (
+	// WindowHistory reads CLUSTER_STATEMENTS_SUMMARY_HISTORY, which contains the
+	// current and the history time windows persisted by TiDB.
+	WindowHistory StmtSummaryWindow = "history"
+	// WindowCurrent reads CLUSTER_STATEMENTS_SUMMARY, which only contains the
+	// current time window.
+	WindowCurrent StmtSummaryWindow = "current"
+	// WindowBoth reads both tables, the statements in the current window that
+	// haven't reached the history table are also captured.
+	WindowBoth StmtSummaryWindow = "both"
+)
+
+// Tables returns the tables to read for the window. Empty window is treated as
+// WindowHistory. It returns nil for an invalid window.
+func (w StmtSummaryWindow) Tables() []string {
+	const (
+		history = "CLUSTER_STATEMENTS_SUMMARY_HISTORY"
+		current = "CLUSTER_STATEMENTS_SUMMARY"
+	)
+	switch w {
+	case "", WindowHistory:
+		return []string{history}
+	case WindowCurrent:
+		return []string{current}
+	case WindowBoth:
+		return []string{current, history}
+	}
+	return nil
+}
+
+// ReadOptions is the options of ReadStmtSummary.
+type ReadOptions struct {
+	// Window decides the tables to read.
+	Window StmtSummaryWindow
+	// Filter skips the statements not matching it, nil filter means only the
+	// default STMT_TYPE rule is used.
+	Filter *Filter
+	// PageInterval is the range of SUMMARY_BEGIN_TIME read by one query. The
+	// whole table is read by one query if it's not positive.
+	PageInterval time.Duration
+	// Checkpoint is the progress of an interrupted ReadStmtSummary to resume
+	// from, nil means reading from the beginning. It's ignored if it's not
+	// created by the same Window and Filter.
+	Checkpoint *ReadCheckpoint
+	// SaveCheckpoint is called after each page is finished if it's not nil.
+	SaveCheckpoint func(*ReadCheckpoint) error
+}
+
+// ReadCheckpoint is the progress of ReadStmtSummary, which is saved after each
+// page is finished.
+type ReadCheckpoint struct {
+	// Rules identifies the Window and Filter of the ReadOptions.
+	Rules string
+	// Table is the table being read, the tables before it in
+	// StmtSummaryWindow.Tables() are finished. NextPageBegin is the unix
+	// seconds of the first SUMMARY_BEGIN_TIME not read in Table.
+	Table         string
+	NextPageBegin int64
+	// Summaries are the aggregated StmtSummary of the finished pages.
+	Summaries []*StmtSummary
+	// SeenRecords is used to deduplicate the records read from different
+	// tables, see stmtSummaryAggregator.
+	SeenRecords []string
+}
+
+func (o *ReadOptions) rules() string {
+	where, args := o.Filter.where()
+	return fmt.Sprintf("%s %s %v", o.Window, where, args)
+}
+
+const (
+	maxPageRetry      = 3
+	pageRetryInterval = time.Second
+)
+
+// ReadStmtSummary reads the statement summary from the TiDB cluster. The tables
+// to read are decided by opts.Window, and the same record read from different
+// tables is only counted once. The records with the same SQL digest and plan
+// digest are aggregated into one StmtSummary, so it emits the StmtSummary into
+// `outCh` after all records are read, or return error. When work is completed,
+// it will return nil. In any cases it will not close the channel.
 //
-// TODO(lance6716): can use statements_summary_evicted to calculate confidence
-// TODO(lance6716): query CLUSTER_STATEMENTS_SUMMARY to get real time data
+// The tables are read in pages of opts.PageInterval on SUMMARY_BEGIN_TIME, so a
+// slow consumer will not hold a long-lived cursor. A failed page is retried
+// from its beginning, and the progress is saved by opts.SaveCheckpoint.
 func ReadStmtSummary(
 	ctx context.Context,
 	db *sql.DB,
+	opts *ReadOptions,
 	outCh chan<- *StmtSummary,
 ) error {
-	// TODO(lance6716): filter on table/schema names, sql, sample user...
-	// TODO(lance6716): pagination on time range
+	if opts.Filter == nil {
+		opts.Filter = &Filter{}
+	}
+	tables := opts.Window.Tables()
+	if len(tables) == 0 {
+		return errors.Errorf("unknown statement summary window %q", opts.Window)
+	}
+
+	p := util.ParserPool.Get().(*parser.Parser)
+	defer util.ParserPool.Put(p)
+	agg := &stmtSummaryAggregator{
+		filter:      opts.Filter,
+		p:           p,
+		byID:        make(map[string]*StmtSummary),
+		order:       make([]*StmtSummary, 0, 64),
+		seenRecords: make(map[string]struct{}),
+	}
+	cp := &ReadCheckpoint{Rules: opts.rules(), Table: tables[0]}
+	if opts.Checkpoint != nil && opts.Checkpoint.Rules == cp.Rules && slices.Contains(tables, opts.Checkpoint.Table) {
+		cp = opts.Checkpoint
+		agg.restore(cp)
+		util.Logger.Info("resume reading statement summary from checkpoint",
+			zap.String("table", cp.Table),
+			zap.Time("next-page-begin", time.Unix(cp.NextPageBegin, 0)),
+			zap.Int("summaries", len(cp.Summaries)))
+	}
+
+	for i := slices.Index(tables, cp.Table); i < len(tables); i++ {
+		if tables[i] != cp.Table {
+			cp.Table = tables[i]
+			cp.NextPageBegin = 0
+		}
+		// only the records of the tables except the last one need to be
+		// remembered for deduplication
+		agg.trackRecords = i < len(tables)-1
+		if err := agg.readTable(ctx, db, opts, cp); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := agg.recoverTruncated(ctx, db); err != nil {
+		return errors.Trace(err)
+	}
+
+	for _, s := range agg.order {
+		if !opts.Filter.matchStats(s) {
+			continue
+		}
+		select {
+		case outCh <- s:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+	return nil
+}
+
+// stmtSummaryAggregator aggregates the records of statement summary tables by
+// the ID of StmtSummary.
+type stmtSummaryAggregator struct {
+	filter *Filter
+	p      *parser.Parser
+	byID   map[string]*StmtSummary
+	// order keeps the order of the first occurrence
+	order []*StmtSummary
+	// seenRecords is the ID + instance + SUMMARY_BEGIN_TIME of the records read
+	// when trackRecords is true. The history table also contains the current
+	// window so the same record can be read from both tables. The current table
+	// is read first because it's small.
+	seenRecords  map[string]struct{}
+	trackRecords bool
+}
+
+// stmtSummaryRecord is one row of the statement summary table.
+type stmtSummaryRecord struct {
+	s           *StmtSummary
+	sqlRecorded string
+	tableNames  string
+}
+
+func (a *stmtSummaryAggregator) restore(cp *ReadCheckpoint) {
+	for _, s := range cp.Summaries {
+		a.byID[s.ID()] = s
+		a.order = append(a.order, s)
+	}
+	for _, k := range cp.SeenRecords {
+		a.seenRecords[k] = struct{}{}
+	}
+}
+
+// readTable reads cp.Table from cp.NextPageBegin page by page, and updates cp
+// after each page.
+func (a *stmtSummaryAggregator) readTable(
+	ctx context.Context,
+	db *sql.DB,
+	opts *ReadOptions,
+	cp *ReadCheckpoint,
+) error {
+	where, args := a.filter.where()
+	// use UNIX_TIMESTAMP and FROM_UNIXTIME to be independent of the time zone
+	// of the session
+	query := fmt.Sprintf(`
+		SELECT UNIX_TIMESTAMP(MIN(SUMMARY_BEGIN_TIME)), UNIX_TIMESTAMP(MAX(SUMMARY_BEGIN_TIME))
+		FROM INFORMATION_SCHEMA.%s
+		WHERE %s`, cp.Table, where)
+	var minBegin, maxBegin sql.NullFloat64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&minBegin, &maxBegin); err != nil {
+		return errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	if !minBegin.Valid {
+		return nil
+	}
+
+	begin := max(int64(minBegin.Float64), cp.NextPageBegin)
+	// the page range is [pageBegin, pageEnd), so the end is excluded
+	end := int64(maxBegin.Float64) + 1
+	pageInterval := int64(opts.PageInterval.Seconds())
+	if pageInterval <= 0 {
+		pageInterval = end - begin
+	}
+	for pageBegin := begin; pageBegin < end; pageBegin += pageInterval {
+		pageEnd := min(pageBegin+pageInterval, end)
+		records, err := a.readPageWithRetry(ctx, db, cp.Table, pageBegin, pageEnd)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, r := range records {
+			a.add(r.s, r.sqlRecorded, r.tableNames)
+		}
+
+		cp.NextPageBegin = pageEnd
+		if opts.SaveCheckpoint == nil {
+			continue
+		}
+		cp.Summaries = a.order
+		cp.SeenRecords = cp.SeenRecords[:0]
+		for k := range a.seenRecords {
+			cp.SeenRecords = append(cp.SeenRecords, k)
+		}
+		if err = opts.SaveCheckpoint(cp); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (a *stmtSummaryAggregator) readPageWithRetry(
+	ctx context.Context,
+	db *sql.DB,
+	table string,
+	pageBegin, pageEnd int64,
+) ([]stmtSummaryRecord, error) {
+	for attempt := 1; ; attempt++ {
+		records, err := a.readPage(ctx, db, table, pageBegin, pageEnd)
+		if err == nil {
+			return records, nil
+		}
+		if attempt >= maxPageRetry || ctx.Err() != nil {
+			return nil, errors.Trace(err)
+		}
+		if merr, ok := errors.Cause(err).(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
+			return nil, errors.Trace(err)
+		}
+		util.Logger.Warn("failed to read a page of statement summary, will retry",
+			zap.String("table", table),
+			zap.Time("page-begin", time.Unix(pageBegin, 0)),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		case <-time.After(pageRetryInterval * time.Duration(attempt)):
+		}
+	}
+}
+
+// readPage reads the records whose SUMMARY_BEGIN_TIME is in [pageBegin,
+// pageEnd) of `table`. The records are returned after all rows are read, so a
+// failed page has no effect on the aggregator.
+func (a *stmtSummaryAggregator) readPage(
+	ctx context.Context,
+	db *sql.DB,
+	table string,
+	pageBegin, pageEnd int64,
+) ([]stmtSummaryRecord, error) {
 	// TODO(lance6716): for plan_in_binding, need to get the sync binding first because binding may not take effect
 	// rely on the ast.GetStmtLabel function to filter out non-select statements
 	query := `
@@ -71,17 +380,19 @@ func ReadStmtSummary(
     		INSTANCE,
     		SUMMARY_BEGIN_TIME,
     		PLAN_IN_BINDING
-		FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY
-		WHERE EXEC_COUNT > 1 AND STMT_TYPE IN ('Select', 'Insert', 'Replace', 'Update', 'Delete')`
-	rows, err := db.QueryContext(ctx, query)
+		FROM INFORMATION_SCHEMA.` + table
+	where, args := a.filter.where()
+	query += `
+		WHERE ` + where + `
+			AND SUMMARY_BEGIN_TIME >= FROM_UNIXTIME(?) AND SUMMARY_BEGIN_TIME < FROM_UNIXTIME(?)`
+	args = append(args, pageBegin, pageEnd)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return errors.Annotatef(err, "failed to execute query: %s", query)
+		return nil, errors.Annotatef(err, "failed to execute query: %s", query)
 	}
 	defer rows.Close()
 
-	p := util.ParserPool.Get().(*parser.Parser)
-	defer util.ParserPool.Put(p)
-
+	var records []stmtSummaryRecord
 	for rows.Next() {
 		var (
 			s                 StmtSummary
@@ -89,6 +400,8 @@ func ReadStmtSummary(
 			schema            sql.NullString
 			sqlRecorded       string
 			sumLatencyNanoSec int64
+			instance          string
+			summaryBeginTime  time.Time
 		)
 
 		err = rows.Scan(
@@ -100,51 +413,200 @@ func ReadStmtSummary(
 			&s.PlanDigest,
 			&s.ExecCount,
 			&sumLatencyNanoSec,
-			&s.Instance,
-			&s.SummaryBeginTime,
+			&instance,
+			&summaryBeginTime,
 			&s.PlanInBinding,
 		)
 		if err != nil {
-			return errors.Annotatef(err, "failed to scan row for query: %s", query)
+			return nil, errors.Annotatef(err, "failed to scan row for query: %s", query)
 		}
 
 		if schema.Valid {
 			s.Schema = schema.String
 		}
-		skip := fillFromSQLRecorded(sqlRecorded, &s, p)
-		if skip {
-			continue
+		s.SumLatency = time.Duration(sumLatencyNanoSec)
+		s.Instances = []string{instance}
+		s.SummaryBeginTimes = []time.Time{summaryBeginTime}
+		records = append(records, stmtSummaryRecord{s: &s, sqlRecorded: sqlRecorded, tableNames: tableNames.String})
+	}
+	return records, errors.Annotatef(rows.Err(), "failed to get rows for query: %s", query)
+}
+
+// add aggregates one record. s should only have the fields from the table.
+func (a *stmtSummaryAggregator) add(s *StmtSummary, sqlRecorded, tableNames string) {
+	recordKey := s.ID() + "/" + s.Instances[0] + "/" + s.SummaryBeginTimes[0].Format(time.RFC3339)
+	if _, ok := a.seenRecords[recordKey]; ok {
+		return
+	}
+	if a.trackRecords {
+		a.seenRecords[recordKey] = struct{}{}
+	}
+
+	if prev, ok := a.byID[s.ID()]; ok {
+		// the same SQL digest and plan digest have the same SQL pattern and
+		// tables, skip parsing again
+		prev.Merge(s)
+		return
+	}
+	if !a.fill(s, sqlRecorded, tableNames) {
+		return
+	}
+	a.byID[s.ID()] = s
+	a.order = append(a.order, s)
+}
+
+// fill fills the fields computed from the SQL text of a new StmtSummary, and
+// returns false if the statement should be skipped. The statement whose SQL
+// text is truncated is kept and marked as unsupported, see recoverTruncated.
+func (a *stmtSummaryAggregator) fill(s *StmtSummary, sqlRecorded, tableNames string) bool {
+	truncated := isTruncatedSQL(sqlRecorded)
+	if truncated {
+		s.SQL = sqlRecorded
+		s.Unsupported = UnsupportedTruncated
+	} else if skip := fillFromSQLRecorded(sqlRecorded, s, a.p); skip {
+		return false
+	}
+
+	failedToSplitDBTable := false
+	if (s.HasParseError || truncated) && len(tableNames) > 0 {
+		tables := strings.Split(tableNames, ",")
+		s.TableNamesNeedToSync = make([][2]string, 0, len(tables))
+		for _, table := range tables {
+			dbAndTable := strings.Split(table, ".")
+			if len(dbAndTable) != 2 {
+				failedToSplitDBTable = true
+				util.Logger.Error(
+					"failed to split db and table, error may happen subsequently",
+					zap.String("dbAndTable", table),
+					zap.String("allTables", tableNames),
+					zap.String("sqlDigest", s.SQLDigest),
+					zap.String("planDigest", s.PlanDigest))
+				continue
+			}
+			s.TableNamesNeedToSync = append(s.TableNamesNeedToSync, [2]string{dbAndTable[0], dbAndTable[1]})
 		}
+	}
 
-		s.SumLatency = time.Duration(sumLatencyNanoSec)
+	// don't synchronize system tables
+	s.TableNamesNeedToSync = slices.DeleteFunc(s.TableNamesNeedToSync, util.IsMemOrSysTable)
+	// skip simple SELECT without accessing any user table. A truncated SQL is
+	// too long to be a simple one.
+	if len(s.TableNamesNeedToSync) == 0 && !failedToSplitDBTable && !truncated {
+		return false
+	}
+	return a.filter.matchTables(s.TableNamesNeedToSync)
+}
+
+// recoverBatchSize is the number of SQL digests queried by one statement in
+// recoverTruncated.
+const recoverBatchSize = 256
 
-		failedToSplitDBTable := false
-		if s.HasParseError && len(tableNames.String) > 0 {
-			tables := strings.Split(tableNames.String, ",")
-			s.TableNamesNeedToSync = make([][2]string, 0, len(tables))
-			for _, table := range tables {
-				dbAndTable := strings.Split(table, ".")
-				if len(dbAndTable) != 2 {
-					failedToSplitDBTable = true
-					util.Logger.Error(
-						"failed to split db and table, error may happen subsequently",
-						zap.String("dbAndTable", table),
-						zap.String("allTables", tableNames.String),
-						zap.String("sqlDigest", s.SQLDigest),
-						zap.String("planDigest", s.PlanDigest))
-					continue
-				}
-				s.TableNamesNeedToSync = append(s.TableNamesNeedToSync, [2]string{dbAndTable[0], dbAndTable[1]})
+// recoverTruncated tries to recover the full SQL text of the statements marked
+// as UnsupportedTruncated from the slow log by SQL digest. The SQL text in the
+// slow log is limited by tidb_query_log_max_len rather than
+// tidb_stmt_summary_max_sql_length, so it's usually longer. The statements that
+// can't be recovered are kept as unsupported, and the ones skipped after
+// recovery are removed.
+func (a *stmtSummaryAggregator) recoverTruncated(ctx context.Context, db *sql.DB) error {
+	byDigest := make(map[string][]*StmtSummary)
+	for _, s := range a.order {
+		if s.Unsupported == UnsupportedTruncated {
+			byDigest[s.SQLDigest] = append(byDigest[s.SQLDigest], s)
+		}
+	}
+	if len(byDigest) == 0 {
+		return nil
+	}
+
+	fullSQLs := make(map[string]string, len(byDigest))
+	digests := slices.Sorted(maps.Keys(byDigest))
+	for batch := range slices.Chunk(digests, recoverBatchSize) {
+		err := readFullSQL(ctx, db, batch, fullSQLs)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errors.Trace(ctx.Err())
+		}
+		// the slow log is optional, so the truncated statements are kept as
+		// unsupported
+		util.Logger.Warn("failed to recover truncated SQL from slow log", zap.Error(err))
+		break
+	}
+
+	removed := make(map[string]struct{})
+	for digest, fullSQL := range fullSQLs {
+		for _, s := range byDigest[digest] {
+			tables := make([]string, 0, len(s.TableNamesNeedToSync))
+			for _, t := range s.TableNamesNeedToSync {
+				tables = append(tables, t[0]+"."+t[1])
+			}
+			s.SQL = ""
+			s.Unsupported = ""
+			s.TableNamesNeedToSync = nil
+			if !a.fill(s, fullSQL, strings.Join(tables, ",")) {
+				removed[s.ID()] = struct{}{}
 			}
 		}
+	}
+	a.order = slices.DeleteFunc(a.order, func(s *StmtSummary) bool {
+		_, ok := removed[s.ID()]
+		return ok
+	})
+	for id := range removed {
+		delete(a.byID, id)
+	}
 
-		// don't synchronize system tables
-		s.TableNamesNeedToSync = slices.DeleteFunc(s.TableNamesNeedToSync, util.IsMemOrSysTable)
-		// skip simple SELECT without accessing any user table
-		if len(s.TableNamesNeedToSync) == 0 && !failedToSplitDBTable {
+	stillTruncated := 0
+	for _, s := range a.order {
+		if s.Unsupported == UnsupportedTruncated {
+			stillTruncated++
+		}
+	}
+	util.Logger.Info("recover truncated SQL from slow log",
+		zap.Int("truncated-digests", len(byDigest)),
+		zap.Int("recovered-digests", len(fullSQLs)),
+		zap.Int("still-truncated", stillTruncated))
+	if stillTruncated > 0 {
+		util.Logger.Warn("some statements are still truncated and will be reported as unsupported, " +
+			"consider increasing tidb_stmt_summary_max_sql_length or tidb_query_log_max_len")
+	}
+	return nil
+}
+
+// readFullSQL reads the SQL text of `digests` which is not truncated from
+// CLUSTER_SLOW_QUERY into `fullSQLs`. The truncated ones are checked by
+// isTruncatedSQL rather than the query, because LIKE can't match the suffix.
+func readFullSQL(
+	ctx context.Context,
+	db *sql.DB,
+	digests []string,
+	fullSQLs map[string]string,
+) error {
+	query := `
+		SELECT Digest, Query
+		FROM INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY
+		WHERE Is_internal = 0 AND Digest IN (` + placeholders(len(digests)) + `)`
+	args := make([]any, 0, len(digests))
+	for _, d := range digests {
+		args = append(args, d)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var digest, fullSQL string
+		if err = rows.Scan(&digest, &fullSQL); err != nil {
+			return errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+		fullSQL = strings.TrimSuffix(strings.TrimSpace(fullSQL), ";")
+		if isTruncatedSQL(fullSQL) {
 			continue
 		}
-		outCh <- &s
+		fullSQLs[digest] = fullSQL
 	}
 	return errors.Annotatef(rows.Err(), "failed to get rows for query: %s", query)
 }
@@ -163,12 +625,6 @@ var dmlRE = regexp.MustCompile(`(?i)^\s*(?:INSERT|REPLACE|UPDATE|DELETE)\b`)
 // - StmtSummary.HasParseError
 // - StmtSummary.BindingDigest if StmtSummary.PlanInBinding is true
 func fillFromSQLRecorded(sqlRecorded string, s *StmtSummary, p *parser.Parser) (skip bool) {
-	if strings.Contains(sqlRecorded, "(len:") {
-		util.Logger.Warn("skip SQL because it's already truncated",
-			zap.String("sql", sqlRecorded))
-		return true
-	}
-
 	s.SQL = interpolateSQLMayHasBrackets(sqlRecorded, p)
 	stmt, err2 := p.ParseOneStmt(s.SQL, "", "")
 	if err2 != nil {
@@ -210,42 +666,16 @@ func fillFromSQLRecorded(sqlRecorded string, s *StmtSummary, p *parser.Parser) (
 	return false
 }
 
-// interpolateSQLMayHasBrackets processed the SQL returned by TiDB like `SELECT
-// ... [arguments: (6249305, 6249404)]` to a valid SQL statement.
-func interpolateSQLMayHasBrackets(sqlMayHasBrackets string, p *parser.Parser) string {
-	_, err := p.ParseOneStmt(sqlMayHasBrackets, "", "")
-	if err == nil {
-		return sqlMayHasBrackets
-	}
-
-	// see (*PlanCacheParamList).String()
-	const argumentsPrefix = " [arguments: "
-	index := strings.Index(sqlMayHasBrackets, argumentsPrefix)
-	if index == -1 {
-		return sqlMayHasBrackets
-	}
-	lastIndex := strings.LastIndex(sqlMayHasBrackets, "]")
-	sql := sqlMayHasBrackets[:index]
-	argsStr := sqlMayHasBrackets[index+len(argumentsPrefix) : lastIndex]
-	if argsStr[0] == '(' {
-		// remove the first and last bracket
-		argsStr = argsStr[1 : len(argsStr)-1]
-	}
-	// TODO(lance6716): check different argument types can be handled correctly.
-	args := strings.Split(argsStr, ", ")
-	for _, arg := range args {
-		sql = strings.Replace(sql, "?", arg, 1)
-	}
-	return sql
-}
-
+// ReadTableStats reads the stats of the table from the status port of TiDB.
+// `statusURL` should contain the scheme, like "http://127.0.0.1:10080". client
+// should be able to verify the server if the scheme is https.
 func ReadTableStats(
 	ctx context.Context,
 	client *http.Client,
-	addr string,
+	statusURL string,
 	schema, table string,
 ) (string, error) {
-	url := fmt.Sprintf("http://%s/stats/dump/%v/%v", addr, schema, table)
+	url := fmt.Sprintf("%s/stats/dump/%v/%v", statusURL, schema, table)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", errors.Errorf("error when build HTTP request to URL (%s): %s", url, err)
@@ -264,6 +694,57 @@ func ReadTableStats(
 	return string(content), nil
 }
 
+// ReadLocalTemporaryTables reads the tables created as local temporary tables
+// from the statement summary, which are only visible to their sessions. The
+// returned [schema, table] are in lower case. The CREATE TABLE statements that
+// are not recorded or are truncated are not included.
+func ReadLocalTemporaryTables(
+	ctx context.Context,
+	db *sql.DB,
+) (map[[2]string]struct{}, error) {
+	query := `
+		SELECT SCHEMA_NAME, QUERY_SAMPLE_TEXT
+		FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY
+		WHERE STMT_TYPE = 'CreateTable' AND QUERY_SAMPLE_TEXT LIKE '%TEMPORARY%'
+		UNION ALL
+		SELECT SCHEMA_NAME, QUERY_SAMPLE_TEXT
+		FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY
+		WHERE STMT_TYPE = 'CreateTable' AND QUERY_SAMPLE_TEXT LIKE '%TEMPORARY%'`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+
+	p := util.ParserPool.Get().(*parser.Parser)
+	defer util.ParserPool.Put(p)
+	ret := make(map[[2]string]struct{})
+	for rows.Next() {
+		var schemaName sql.NullString
+		var sampleText string
+		if err = rows.Scan(&schemaName, &sampleText); err != nil {
+			return nil, errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+		stmt, err2 := p.ParseOneStmt(sampleText, "", "")
+		if err2 != nil {
+			continue
+		}
+		create, ok := stmt.(*ast.CreateTableStmt)
+		if !ok || create.TemporaryKeyword != ast.TemporaryLocal {
+			continue
+		}
+		createSchema := create.Table.Schema.L
+		if createSchema == "" {
+			createSchema = strings.ToLower(schemaName.String)
+		}
+		ret[[2]string{createSchema, create.Table.Name.L}] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Annotatef(err, "failed to read rows for query: %s", query)
+	}
+	return ret, nil
+}
+
 type Binding struct {
 	OriginalSQL string
 	BindSQL     string
@@ -4,8 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/lance6716/plan-change-capturer/pkg/util"
 	"github.com/pingcap/tidb/pkg/parser"
 	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
@@ -31,7 +36,7 @@ func TestReadStmtSummary(t *testing.T) {
 		t.Skip("test disabled")
 	}
 
-	db, err := util.ConnectDB(*testHost, *testPort, *testUser, *testPassword)
+	db, err := util.ConnectDB("test", *testHost, *testPort, *testUser, *testPassword, nil)
 	require.NoError(t, err)
 	defer db.Close()
 
@@ -47,45 +52,15 @@ func TestReadStmtSummary(t *testing.T) {
 	mustExec(t, conn, "SELECT a FROM test_read_stmt_summary WHERE b = 2 AND c = 2")
 
 	outCh := make(chan *StmtSummary, 16)
-	err = ReadStmtSummary(ctx, db, outCh)
+	err = ReadStmtSummary(ctx, db, &ReadOptions{Window: WindowBoth, Filter: &Filter{MinExecCount: 2}}, outCh)
 	require.NoError(t, err)
 	// at least we have executed above two queries which has same pattern
 	require.Greater(t, len(outCh), 0)
 }
 
-func TestInterpolateSQLMayHasBrackets(t *testing.T) {
-	cases := []struct {
-		sql      string
-		expected string
-	}{
-		{
-			sql:      "SELECT * FROM t WHERE a = 1",
-			expected: "SELECT * FROM t WHERE a = 1",
-		},
-		{
-			sql:      "SELECT * FROM t WHERE a = ' [arguments: (1, 2)]'",
-			expected: "SELECT * FROM t WHERE a = ' [arguments: (1, 2)]'",
-		},
-		{
-			sql:      "SELECT DISTINCT c FROM sbtest2 WHERE id BETWEEN ? AND ? ORDER BY c [arguments: (6249305, 6249404)]",
-			expected: "SELECT DISTINCT c FROM sbtest2 WHERE id BETWEEN 6249305 AND 6249404 ORDER BY c",
-		},
-		{
-			sql:      "SELECT w_street_1, w_street_2, w_city, w_state, w_zip, w_name FROM warehouse WHERE w_id = ? [arguments: 270]",
-			expected: "SELECT w_street_1, w_street_2, w_city, w_state, w_zip, w_name FROM warehouse WHERE w_id = 270",
-		},
-	}
-
-	p := parser.New()
-	for _, c := range cases {
-		require.Equal(t, c.expected, interpolateSQLMayHasBrackets(c.sql, p))
-	}
-}
-
 func TestFillFromSQLRecordedSkip(t *testing.T) {
 	p := parser.New()
 	shouldSkipCases := []string{
-		"aaaaa(len:20)",
 		"INSERT INTO test.t VALUES (1, 1, 1), (2, 2, 2)",
 		"UPDATE test.t SET a = 1",
 		"DELETE FROM test.t",
@@ -103,6 +78,8 @@ func TestFillFromSQLRecordedSkip(t *testing.T) {
 		"INSERT INTO test.t SELECT * FROM t",
 		"UPDATE t SET a = 1 WHERE b = 1",
 		"DELETE FROM t WHERE b = 1",
+		// not truncated, see isTruncatedSQL
+		"SELECT * FROM t WHERE a = '(len:3)'",
 	}
 
 	for _, c := range shouldNotSkipCases {
@@ -127,3 +104,267 @@ func TestBindingDigest(t *testing.T) {
 	require.False(t, fillFromSQLRecorded("SELect * FRom T1", s, p))
 	require.Equal(t, expected, s.BindingDigest)
 }
+
+func TestReadTableStats(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/dump/test/t" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"database_name":"test","table_name":"t"}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	got, err := ReadTableStats(ctx, server.Client(), server.URL, "test", "t")
+	require.NoError(t, err)
+	require.Equal(t, `{"database_name":"test","table_name":"t"}`, got)
+
+	_, err = ReadTableStats(ctx, server.Client(), server.URL, "test", "t2")
+	require.ErrorContains(t, err, "HTTP status not 200, got 404")
+}
+
+func TestStmtSummaryMerge(t *testing.T) {
+	t1 := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(30 * time.Minute)
+	s := &StmtSummary{
+		SQLDigest:         "sql1",
+		PlanDigest:        "plan1",
+		SQL:               "SELECT * FROM t WHERE a = 1",
+		ExecCount:         2,
+		SumLatency:        time.Second,
+		Instances:         []string{"tidb-1"},
+		SummaryBeginTimes: []time.Time{t2},
+	}
+	s.Merge(&StmtSummary{
+		SQLDigest:         "sql1",
+		PlanDigest:        "plan1",
+		SQL:               "SELECT * FROM t WHERE a = 2",
+		ExecCount:         3,
+		SumLatency:        2 * time.Second,
+		Instances:         []string{"tidb-0"},
+		SummaryBeginTimes: []time.Time{t1},
+	})
+	s.Merge(&StmtSummary{
+		SQLDigest:         "sql1",
+		PlanDigest:        "plan1",
+		ExecCount:         1,
+		SumLatency:        time.Second,
+		Instances:         []string{"tidb-1"},
+		SummaryBeginTimes: []time.Time{t1},
+	})
+	require.Equal(t, "sql1/plan1", s.ID())
+	require.Equal(t, "SELECT * FROM t WHERE a = 1", s.SQL)
+	require.Equal(t, 6, s.ExecCount)
+	require.Equal(t, 4*time.Second, s.SumLatency)
+	require.Equal(t, []string{"tidb-0", "tidb-1"}, s.Instances)
+	require.Equal(t, []time.Time{t1, t2}, s.SummaryBeginTimes)
+}
+
+func TestStmtSummaryAggregator(t *testing.T) {
+	p := parser.New()
+	agg := &stmtSummaryAggregator{
+		filter:      &Filter{},
+		p:           p,
+		byID:        make(map[string]*StmtSummary),
+		seenRecords: make(map[string]struct{}),
+		// simulate reading the current table
+		trackRecords: true,
+	}
+	t1 := time.Date(2024, 12, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(30 * time.Minute)
+	record := func(instance string, beginTime time.Time, execCount int) *StmtSummary {
+		return &StmtSummary{
+			Schema:            "test",
+			SQLDigest:         "sql1",
+			PlanDigest:        "plan1",
+			ExecCount:         execCount,
+			Instances:         []string{instance},
+			SummaryBeginTimes: []time.Time{beginTime},
+		}
+	}
+	const sql = "SELECT * FROM t WHERE a = 1"
+	agg.add(record("tidb-0", t1, 1), sql, "")
+	agg.add(record("tidb-1", t1, 2), sql, "")
+	agg.add(record("tidb-0", t2, 4), sql, "")
+	// the same record read from the history table
+	agg.trackRecords = false
+	agg.add(record("tidb-0", t2, 4), sql, "")
+	// skipped because it doesn't access user table
+	agg.add(&StmtSummary{
+		SQLDigest:         "sql2",
+		Instances:         []string{"tidb-0"},
+		SummaryBeginTimes: []time.Time{t1},
+	}, "SELECT 1", "")
+
+	require.Len(t, agg.order, 1)
+	s := agg.order[0]
+	require.Equal(t, sql, s.SQL)
+	require.Equal(t, [][2]string{{"test", "t"}}, s.TableNamesNeedToSync)
+	require.Equal(t, 7, s.ExecCount)
+	require.Equal(t, []string{"tidb-0", "tidb-1"}, s.Instances)
+	require.Equal(t, []time.Time{t1, t2}, s.SummaryBeginTimes)
+}
+
+func TestReadStmtSummaryPages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{
+		"SCHEMA_NAME", "QUERY_SAMPLE_TEXT", "TABLE_NAMES", "PLAN", "DIGEST", "PLAN_DIGEST",
+		"EXEC_COUNT", "SUM_LATENCY", "INSTANCE", "SUMMARY_BEGIN_TIME", "PLAN_IN_BINDING",
+	}
+	t1 := time.Unix(3600, 0)
+	t2 := time.Unix(7200, 0)
+	mock.ExpectQuery("SELECT UNIX_TIMESTAMP\\(MIN.*CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3600, 7200))
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WithArgs("Select", int64(3600), int64(7200)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test", "SELECT * FROM t WHERE a = 1", "test.t", "plan", "sql1", "plan1", 2, 10, "tidb-0", t1, false))
+	// the connection is broken, retry the same page
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WithArgs("Select", int64(7200), int64(7201)).
+		WillReturnError(mysql.ErrInvalidConn)
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WithArgs("Select", int64(7200), int64(7201)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test", "SELECT * FROM t WHERE a = 2", "test.t", "plan", "sql1", "plan1", 3, 20, "tidb-0", t2, false))
+
+	var checkpoints []ReadCheckpoint
+	opts := &ReadOptions{
+		Window:       WindowHistory,
+		Filter:       &Filter{MinExecCount: 2, StmtTypes: []string{"Select"}},
+		PageInterval: time.Hour,
+		SaveCheckpoint: func(cp *ReadCheckpoint) error {
+			checkpoints = append(checkpoints, *cp)
+			return nil
+		},
+	}
+	outCh := make(chan *StmtSummary, 4)
+	require.NoError(t, ReadStmtSummary(context.Background(), db, opts, outCh))
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, outCh, 1)
+	s := <-outCh
+	require.Equal(t, 5, s.ExecCount)
+	require.Equal(t, []time.Time{t1, t2}, s.SummaryBeginTimes)
+	require.Len(t, checkpoints, 2)
+	require.EqualValues(t, 7200, checkpoints[0].NextPageBegin)
+	require.EqualValues(t, 7201, checkpoints[1].NextPageBegin)
+
+	// resume from the first checkpoint, only the second page is read
+	cp := &ReadCheckpoint{
+		Rules:         checkpoints[0].Rules,
+		Table:         checkpoints[0].Table,
+		NextPageBegin: 7200,
+		Summaries: []*StmtSummary{{
+			SQLDigest:         "sql1",
+			PlanDigest:        "plan1",
+			SQL:               "SELECT * FROM t WHERE a = 1",
+			ExecCount:         2,
+			Instances:         []string{"tidb-0"},
+			SummaryBeginTimes: []time.Time{t1},
+		}},
+	}
+	mock.ExpectQuery("SELECT UNIX_TIMESTAMP\\(MIN.*CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3600, 7200))
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WithArgs("Select", int64(7200), int64(7201)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test", "SELECT * FROM t WHERE a = 2", "test.t", "plan", "sql1", "plan1", 3, 20, "tidb-0", t2, false))
+	opts.Checkpoint = cp
+	opts.SaveCheckpoint = nil
+	require.NoError(t, ReadStmtSummary(context.Background(), db, opts, outCh))
+	require.NoError(t, mock.ExpectationsWereMet())
+	s = <-outCh
+	require.Equal(t, 5, s.ExecCount)
+	require.Equal(t, []time.Time{t1, t2}, s.SummaryBeginTimes)
+}
+
+func TestRecoverTruncatedSQL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{
+		"SCHEMA_NAME", "QUERY_SAMPLE_TEXT", "TABLE_NAMES", "PLAN", "DIGEST", "PLAN_DIGEST",
+		"EXEC_COUNT", "SUM_LATENCY", "INSTANCE", "SUMMARY_BEGIN_TIME", "PLAN_IN_BINDING",
+	}
+	t1 := time.Unix(3600, 0)
+	mock.ExpectQuery("SELECT UNIX_TIMESTAMP\\(MIN.*CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3600, 3600))
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test", "SELECT * FROM t1 WHERE a IN (1, 2(len:100)", "test.t1", "plan", "sql1", "plan1", 1, 10, "tidb-0", t1, false).
+			AddRow("test", "SELECT * FROM t2 WHERE a IN (1, 2(len:100)", "test.t2", "plan", "sql2", "plan2", 2, 10, "tidb-0", t1, false).
+			AddRow("test", "SELECT * FROM t3 WHERE a IN (1, 2(len:100)", "test.t3", "plan", "sql3", "plan3", 3, 10, "tidb-0", t1, false))
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_SLOW_QUERY").
+		WithArgs("sql1", "sql2", "sql3").
+		WillReturnRows(sqlmock.NewRows([]string{"Digest", "Query"}).
+			AddRow("sql1", "SELECT * FROM t1 WHERE a IN (1, 2, 3);").
+			// the recovered SQL doesn't access user table
+			AddRow("sql2", "SELECT 1").
+			// the slow log is also truncated
+			AddRow("sql3", "SELECT * FROM t3 WHERE a IN (1, 2, 3, 4(len:200)"))
+
+	outCh := make(chan *StmtSummary, 4)
+	err = ReadStmtSummary(context.Background(), db, &ReadOptions{Window: WindowHistory}, outCh)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, outCh, 2)
+
+	s := <-outCh
+	require.Equal(t, "sql1", s.SQLDigest)
+	require.Equal(t, "SELECT * FROM t1 WHERE a IN (1, 2, 3)", s.SQL)
+	require.Equal(t, "", s.Unsupported)
+	require.Equal(t, [][2]string{{"test", "t1"}}, s.TableNamesNeedToSync)
+
+	s = <-outCh
+	require.Equal(t, "sql3", s.SQLDigest)
+	require.Equal(t, UnsupportedTruncated, s.Unsupported)
+	require.Equal(t, [][2]string{{"test", "t3"}}, s.TableNamesNeedToSync)
+}
+
+func TestReadStmtSummaryMatchStatsAfterAggregation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{
+		"SCHEMA_NAME", "QUERY_SAMPLE_TEXT", "TABLE_NAMES", "PLAN", "DIGEST", "PLAN_DIGEST",
+		"EXEC_COUNT", "SUM_LATENCY", "INSTANCE", "SUMMARY_BEGIN_TIME", "PLAN_IN_BINDING",
+	}
+	t1 := time.Unix(3600, 0)
+	t2 := time.Unix(7200, 0)
+	t3 := time.Unix(10800, 0)
+	mock.ExpectQuery("SELECT UNIX_TIMESTAMP\\(MIN.*CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3600, 10800))
+	// sql1 runs once in each window, the rows are not filtered by EXEC_COUNT
+	mock.ExpectQuery("FROM INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY").
+		WithArgs("Select", int64(3600), int64(10801)).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("test", "SELECT * FROM t WHERE a = 1", "test.t", "plan", "sql1", "plan1", 1, 2000, "tidb-0", t1, false).
+			AddRow("test", "SELECT * FROM t WHERE a = 2", "test.t", "plan", "sql1", "plan1", 1, 2000, "tidb-0", t2, false).
+			AddRow("test", "SELECT * FROM t WHERE a = 3", "test.t", "plan", "sql1", "plan1", 1, 2000, "tidb-0", t3, false).
+			AddRow("test", "SELECT * FROM t WHERE b = 1", "test.t", "plan", "sql2", "plan2", 1, 2000, "tidb-0", t1, false).
+			AddRow("test", "SELECT * FROM t WHERE c = 1", "test.t", "plan", "sql3", "plan3", 5, 5000, "tidb-0", t1, false))
+
+	opts := &ReadOptions{
+		Window: WindowHistory,
+		Filter: &Filter{
+			MinExecCount:  2,
+			MinAvgLatency: 2 * time.Microsecond,
+			StmtTypes:     []string{"Select"},
+		},
+	}
+	outCh := make(chan *StmtSummary, 4)
+	require.NoError(t, ReadStmtSummary(context.Background(), db, opts, outCh))
+	require.NoError(t, mock.ExpectationsWereMet())
+	// sql2 is executed only once, and the average latency of sql3 is too low
+	require.Len(t, outCh, 1)
+	s := <-outCh
+	require.Equal(t, "sql1", s.SQLDigest)
+	require.Equal(t, 3, s.ExecCount)
+	require.Equal(t, []time.Time{t1, t2, t3}, s.SummaryBeginTimes)
+}
@@ -0,0 +1,88 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/errno"
+)
+
+// ReadGlobalVariables reads the global variables whose names match any of the
+// wildcard patterns from the TiDB cluster, and returns a map of variable name to
+// value.
+func ReadGlobalVariables(
+	ctx context.Context,
+	db util.Querier,
+	patterns []string,
+) (map[string]string, error) {
+	ret := make(map[string]string, 64)
+	if len(patterns) == 0 {
+		return ret, nil
+	}
+	conds := make([]string, 0, len(patterns))
+	args := make([]any, 0, len(patterns))
+	for _, p := range patterns {
+		conds = append(conds, "VARIABLE_NAME LIKE ?")
+		args = append(args, wildcardToLike(strings.ToLower(p)))
+	}
+	query := `SELECT VARIABLE_NAME, VARIABLE_VALUE FROM INFORMATION_SCHEMA.GLOBAL_VARIABLES WHERE ` +
+		strings.Join(conds, " OR ")
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return nil, errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+		ret[strings.ToLower(name)] = value
+	}
+	return ret, errors.Annotatef(rows.Err(), "failed to get rows for query: %s", query)
+}
+
+// ReadResourceGroups reads the resource groups except the default one from the
+// TiDB cluster, and returns a map of resource group name to the CREATE RESOURCE
+// GROUP statement. It returns an empty map if the TiDB cluster doesn't support
+// resource groups.
+func ReadResourceGroups(
+	ctx context.Context,
+	db *sql.DB,
+) (map[string]string, error) {
+	ret := make(map[string]string, 8)
+	query := `SELECT NAME FROM INFORMATION_SCHEMA.RESOURCE_GROUPS WHERE NAME != 'default'`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		if merr, ok := err.(*mysql.MySQLError); ok && merr.Number == errno.ErrNoSuchTable {
+			return ret, nil
+		}
+		return nil, errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, 8)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, errors.Annotatef(err, "failed to scan row for query: %s", query)
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Annotatef(err, "failed to get rows for query: %s", query)
+	}
+	for _, name := range names {
+		create, err2 := util.ReadCreateResourceGroup(ctx, db, name)
+		if err2 != nil {
+			return nil, errors.Trace(err2)
+		}
+		ret[name] = create
+	}
+	return ret, nil
+}
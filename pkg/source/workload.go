@@ -0,0 +1,242 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lance6716/plan-change-capturer/pkg/plan"
+	"github.com/lance6716/plan-change-capturer/pkg/util"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"go.uber.org/zap"
+)
+
+// WorkloadOptions is the options of ReadWorkload.
+type WorkloadOptions struct {
+	// Files are the workload files to read, glob patterns are supported. A file
+	// with ".sql" extension contains SQL statements separated by ";", and "USE
+	// db" statements change the default schema of the following statements. A
+	// file with ".jsonl" or ".json" extension contains one WorkloadStmt in JSON
+	// format per line.
+	Files []string
+	// Filter skips the statements not matching it, nil filter means only the
+	// default STMT_TYPE rule is used. The rules of SAMPLE_USER,
+	// SUMMARY_BEGIN_TIME and execution statistics are ignored because the
+	// workload file has no such information.
+	Filter *Filter
+}
+
+// WorkloadStmt is one statement of the workload file.
+type WorkloadStmt struct {
+	SQL string `json:"sql"`
+	// Schema is the default schema to run the statement, optional.
+	Schema string `json:"schema"`
+	// Weight is used as the execution count of the statement, default is 1.
+	Weight int `json:"weight"`
+}
+
+// ReadWorkload reads the statements from workload files and turns them into
+// StmtSummary. The workload files have no recorded plan, so the plan of every
+// statement is read by EXPLAIN on the old version cluster `db`. Like
+// ReadStmtSummary, the statements with the same SQL digest and plan digest are
+// aggregated and their weights are summed, the StmtSummary are emitted into
+// `outCh` after all statements are read and the channel is not closed.
+func ReadWorkload(
+	ctx context.Context,
+	db *sql.DB,
+	opts *WorkloadOptions,
+	outCh chan<- *StmtSummary,
+) error {
+	if opts.Filter == nil {
+		opts.Filter = &Filter{}
+	}
+	p := util.ParserPool.Get().(*parser.Parser)
+	defer util.ParserPool.Put(p)
+	agg := &stmtSummaryAggregator{
+		filter:      opts.Filter,
+		p:           p,
+		byID:        make(map[string]*StmtSummary),
+		order:       make([]*StmtSummary, 0, 64),
+		seenRecords: make(map[string]struct{}),
+	}
+
+	for _, pattern := range opts.Files {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.Annotatef(err, "invalid workload file pattern %s", pattern)
+		}
+		if len(files) == 0 {
+			return errors.Errorf("no workload file matches %s", pattern)
+		}
+		for _, file := range files {
+			if err = readWorkloadFile(ctx, db, file, agg); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	for _, s := range agg.order {
+		select {
+		case outCh <- s:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+	return nil
+}
+
+func readWorkloadFile(
+	ctx context.Context,
+	db *sql.DB,
+	path string,
+	agg *stmtSummaryAggregator,
+) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Annotatef(err, "stat workload file %s", path)
+	}
+	var stmts []*WorkloadStmt
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".sql":
+		stmts, err = parseSQLWorkload(path, agg.p)
+	case ".jsonl", ".json":
+		stmts, err = parseJSONWorkload(path)
+	default:
+		return errors.Errorf(
+			"unsupported extension %q of workload file %s, should be one of .sql, .jsonl, .json",
+			ext, path,
+		)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// there is no execution time, use the modification time of the file
+	beginTime := info.ModTime().Truncate(aggregateWindow)
+	for _, stmt := range stmts {
+		if err = addWorkloadStmt(ctx, db, path, beginTime, stmt, agg); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// addWorkloadStmt reads the plan of the statement from the old version cluster
+// and aggregates it. The statements which can't be explained on the old version
+// cluster are skipped.
+func addWorkloadStmt(
+	ctx context.Context,
+	db *sql.DB,
+	path string,
+	beginTime time.Time,
+	stmt *WorkloadStmt,
+	agg *stmtSummaryAggregator,
+) error {
+	f := agg.filter
+	node, err := agg.p.ParseOneStmt(stmt.SQL, "", "")
+	if err != nil {
+		util.Logger.Warn("failed to parse statement in workload file, skip it",
+			zap.String("file", path),
+			zap.String("sql", stmt.SQL),
+			zap.Error(err))
+		return nil
+	}
+	_, digest := parser.NormalizeDigest(stmt.SQL)
+	if !f.matchStmtType(ast.GetStmtLabel(node)) || !f.matchStmt(stmt.Schema, digest.String()) {
+		return nil
+	}
+
+	planStr, err := plan.ExplainAsStmtSummaryPlan(ctx, db, stmt.Schema, stmt.SQL)
+	if err != nil {
+		if merr, ok := errors.Cause(err).(*mysql.MySQLError); ok && util.IsSQLErrorUnretryable(merr) {
+			util.Logger.Warn("failed to EXPLAIN statement in workload file on old version cluster, skip it",
+				zap.String("file", path),
+				zap.String("sql", stmt.SQL),
+				zap.Error(err))
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	planDigest := sha256.Sum256([]byte(planStr))
+
+	weight := stmt.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	agg.add(&StmtSummary{
+		Schema:             stmt.Schema,
+		PlanStr:            planStr,
+		SQLDigest:          digest.String(),
+		PlanDigest:         hex.EncodeToString(planDigest[:]),
+		ExecCount:          weight,
+		LatencyUnavailable: true,
+		Instances:          []string{path},
+		SummaryBeginTimes:  []time.Time{beginTime},
+	}, stmt.SQL, "")
+	return nil
+}
+
+// parseSQLWorkload parses the statements in a ".sql" file. "USE db" statements
+// are not returned but change the schema of the following statements.
+func parseSQLWorkload(path string, p *parser.Parser) ([]*WorkloadStmt, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "read workload file %s", path)
+	}
+	nodes, _, err := p.Parse(string(content), "", "")
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse workload file %s", path)
+	}
+	ret := make([]*WorkloadStmt, 0, len(nodes))
+	schema := ""
+	for _, node := range nodes {
+		if use, ok := node.(*ast.UseStmt); ok {
+			schema = use.DBName
+			continue
+		}
+		sql := strings.TrimSuffix(strings.TrimSpace(node.Text()), ";")
+		ret = append(ret, &WorkloadStmt{SQL: sql, Schema: schema})
+	}
+	return ret, nil
+}
+
+// parseJSONWorkload parses the WorkloadStmt in a ".jsonl" or ".json" file, one
+// per line. Empty lines are skipped.
+func parseJSONWorkload(path string) ([]*WorkloadStmt, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "open workload file %s", path)
+	}
+	defer f.Close()
+
+	ret := make([]*WorkloadStmt, 0, 64)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		stmt := &WorkloadStmt{}
+		if err = json.Unmarshal([]byte(line), stmt); err != nil {
+			return nil, errors.Annotatef(err, "unmarshal line %d of workload file %s", lineNo, path)
+		}
+		if stmt.SQL == "" {
+			return nil, errors.Errorf("sql is required at line %d of workload file %s", lineNo, path)
+		}
+		ret = append(ret, stmt)
+	}
+	return ret, errors.Annotatef(scanner.Err(), "read workload file %s", path)
+}
@@ -0,0 +1,92 @@
+package source
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/pkg/errno"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWorkload(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dir := t.TempDir()
+	sqlPath := filepath.Join(dir, "queries.sql")
+	require.NoError(t, os.WriteFile(sqlPath, []byte(`
+USE test;
+SELECT * FROM t WHERE a = 1;
+SELECT * FROM t WHERE a = 2;
+DELETE FROM t;
+SELECT * FROM not_exist WHERE a = 1;
+`), 0644))
+	jsonPath := filepath.Join(dir, "queries.jsonl")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`
+{"sql": "UPDATE t SET b = 1 WHERE a = 3", "schema": "test", "weight": 10}
+{"sql": "SELECT * FROM test.t2", "weight": 2}
+`), 0644))
+
+	explainRows := func(table string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "estRows", "task", "access object", "operator info"}).
+			AddRow("TableReader_6", "10.00", "root", "", "data:TableFullScan_5").
+			AddRow("└─TableFullScan_5", "10.00", "cop[tikv]", "table:"+table, "keep order:false")
+	}
+	// the files are read in the order of the patterns
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("EXPLAIN SELECT \\* FROM t WHERE a = ").WillReturnRows(explainRows("t"))
+	}
+	// DELETE without WHERE is skipped after EXPLAIN, like statement summary
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("EXPLAIN DELETE FROM t").WillReturnRows(explainRows("t"))
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("EXPLAIN SELECT \\* FROM not_exist").
+		WillReturnError(&mysql.MySQLError{Number: errno.ErrNoSuchTable, Message: "Table 'test.not_exist' doesn't exist"})
+	mock.ExpectExec("USE `test`").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("EXPLAIN UPDATE t SET b = 1").WillReturnRows(explainRows("t"))
+	mock.ExpectQuery("EXPLAIN SELECT \\* FROM test.t2").WillReturnRows(explainRows("t2"))
+
+	ch := make(chan *StmtSummary, 10)
+	err = ReadWorkload(context.Background(), db, &WorkloadOptions{
+		Files:  []string{sqlPath, jsonPath},
+		Filter: &Filter{MinExecCount: 100},
+	}, ch)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	close(ch)
+
+	var got []*StmtSummary
+	for s := range ch {
+		got = append(got, s)
+	}
+	require.Len(t, got, 3)
+
+	require.Equal(t, "test", got[0].Schema)
+	require.Equal(t, "SELECT * FROM t WHERE a = 1", got[0].SQL)
+	require.Equal(t, [][2]string{{"test", "t"}}, got[0].TableNamesNeedToSync)
+	require.Equal(t, 2, got[0].ExecCount)
+	require.True(t, got[0].LatencyUnavailable)
+	require.Equal(t, []string{sqlPath}, got[0].Instances)
+	require.Len(t, got[0].PlanDigest, 64)
+	require.Contains(t, got[0].PlanStr, "TableFullScan_5")
+
+	require.Equal(t, "UPDATE t SET b = 1 WHERE a = 3", got[1].SQL)
+	require.Equal(t, 10, got[1].ExecCount)
+	require.Equal(t, got[0].PlanDigest, got[1].PlanDigest)
+
+	require.Equal(t, "", got[2].Schema)
+	require.Equal(t, [][2]string{{"test", "t2"}}, got[2].TableNamesNeedToSync)
+	require.Equal(t, 2, got[2].ExecCount)
+	require.NotEqual(t, got[0].PlanDigest, got[2].PlanDigest)
+
+	err = ReadWorkload(context.Background(), db, &WorkloadOptions{
+		Files: []string{filepath.Join(dir, "*.txt")},
+	}, ch)
+	require.ErrorContains(t, err, "no workload file matches")
+}
@@ -1,6 +1,10 @@
 package util
 
-import "github.com/pingcap/tidb/pkg/parser/ast"
+import (
+	"slices"
+
+	"github.com/pingcap/tidb/pkg/parser/ast"
+)
 
 type visitor struct {
 	currDB     string
@@ -28,3 +32,34 @@ func ExtractTableNames(s ast.Node, currDB string) [][2]string {
 	s.Accept(v)
 	return v.tableNames
 }
+
+// ExtractPlacementPolicies extracts the names of placement policies used by a
+// CREATE DATABASE / TABLE statement, including the ones of partitions.
+func ExtractPlacementPolicies(s ast.StmtNode) []string {
+	var ret []string
+	switch n := s.(type) {
+	case *ast.CreateDatabaseStmt:
+		for _, opt := range n.Options {
+			if opt.Tp == ast.DatabaseOptionPlacementPolicy {
+				ret = append(ret, opt.Value)
+			}
+		}
+	case *ast.CreateTableStmt:
+		ret = appendPlacementPolicies(ret, n.Options)
+		if n.Partition != nil {
+			for _, def := range n.Partition.Definitions {
+				ret = appendPlacementPolicies(ret, def.Options)
+			}
+		}
+	}
+	return ret
+}
+
+func appendPlacementPolicies(ret []string, opts []*ast.TableOption) []string {
+	for _, opt := range opts {
+		if opt.Tp == ast.TableOptionPlacementPolicy && !slices.Contains(ret, opt.StrValue) {
+			ret = append(ret, opt.StrValue)
+		}
+	}
+	return ret
+}
@@ -55,3 +55,36 @@ func TestExtractTableNames(t *testing.T) {
 		require.Equal(t, ca.expected, ExtractTableNames(stmt, currDB), "sql: %s", ca.sql)
 	}
 }
+
+func TestExtractPlacementPolicies(t *testing.T) {
+	cases := []struct {
+		sql      string
+		expected []string
+	}{
+		{
+			sql:      "CREATE TABLE `t` (`a` int)",
+			expected: nil,
+		},
+		{
+			sql:      "CREATE DATABASE `test` /*T![placement] PLACEMENT POLICY=`p1` */",
+			expected: []string{"p1"},
+		},
+		{
+			sql:      "CREATE TABLE `t` (`a` int) /*T![placement] PLACEMENT POLICY=`p1` */",
+			expected: []string{"p1"},
+		},
+		{
+			sql: "CREATE TABLE `t` (`a` int) /*T![placement] PLACEMENT POLICY=`p1` */ PARTITION BY RANGE (`a`) (" +
+				"PARTITION `p0` VALUES LESS THAN (10) /*T![placement] PLACEMENT POLICY=`p2` */, " +
+				"PARTITION `p1` VALUES LESS THAN (20) /*T![placement] PLACEMENT POLICY=`p1` */)",
+			expected: []string{"p1", "p2"},
+		},
+	}
+
+	p := parser.New()
+	for _, c := range cases {
+		stmt, err := p.ParseOneStmt(c.sql, "", "")
+		require.NoError(t, err, c.sql)
+		require.Equal(t, c.expected, ExtractPlacementPolicies(stmt), c.sql)
+	}
+}
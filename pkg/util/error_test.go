@@ -33,7 +33,7 @@ func TestCheckOldDBSQLErrorUnretryable(t *testing.T) {
 		t.Skip("test disabled")
 	}
 
-	db, err := ConnectDB(*testHost, *testPort, *testUser, *testPassword)
+	db, err := ConnectDB("test", *testHost, *testPort, *testUser, *testPassword, nil)
 	require.NoError(t, err)
 	defer db.Close()
 
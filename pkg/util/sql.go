@@ -2,9 +2,12 @@ package util
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
 	"maps"
 	"net"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -22,14 +25,42 @@ func EscapeIdentifier(s string) string {
 	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
 }
 
-// ConnectDB connects to a MySQL database.
+// EscapeStringLiteral escapes the content of a single-quoted MySQL string
+// literal.
+func EscapeStringLiteral(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+var variableNameRE = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// SetGlobalVariableSQL returns the statement to set the global variable. The
+// name can't be quoted in the statement, so it returns error if the name is not
+// a valid variable name.
+func SetGlobalVariableSQL(name, value string) (string, error) {
+	if !variableNameRE.MatchString(name) {
+		return "", errors.Errorf("invalid variable name %q", name)
+	}
+	return "SET GLOBAL " + name + " = '" + EscapeStringLiteral(value) + "'", nil
+}
+
+// Querier is implemented by *sql.DB and *sql.Conn.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ConnectDB connects to a MySQL database. If tlsConfig is not nil, the
+// connection is encrypted by it. role names the database like "old" or "new",
+// the TLS config is registered by it so the databases behind the same address
+// use their own TLS configs.
 func ConnectDB(
+	role string,
 	host string,
 	port int,
 	user string,
 	password string,
+	tlsConfig *tls.Config,
 ) (*sql.DB, error) {
-	// TODO(lance6716): TLS
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	cfg := mysql.NewConfig()
 	cfg.User = user
@@ -43,6 +74,13 @@ func ConnectDB(
 		// relax SQL mode
 		"sql_mode": "'IGNORE_SPACE,NO_AUTO_VALUE_ON_ZERO,ALLOW_INVALID_DATES,NO_ENGINE_SUBSTITUTION'",
 	}
+	if tlsConfig != nil {
+		// the driver fills ServerName by the host of addr if it's empty
+		cfg.TLSConfig = "pcc-" + role
+		if err := mysql.RegisterTLSConfig(cfg.TLSConfig, tlsConfig); err != nil {
+			return nil, errors.Annotatef(err, "register TLS config for %s", role)
+		}
+	}
 
 	c, err := mysql.NewConnector(cfg)
 	if err != nil {
@@ -51,6 +89,13 @@ func ConnectDB(
 	return sql.OpenDB(c), nil
 }
 
+// DiscardConn closes the connection without putting it back to the pool, so
+// the changed session is not reused.
+func DiscardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
+
 // TODO(lance6716): retry
 
 var ParserPool = sync.Pool{
@@ -188,6 +233,115 @@ func ReadCreateTableViewSeq(
 	return "", errors.Errorf("failed to find create table or view statement for %s.%s, got columns %v", escapedDBName, escapedTable, columnNames)
 }
 
+// ReadCreatePlacementPolicy reads the CREATE PLACEMENT POLICY statement from
+// the database.
+func ReadCreatePlacementPolicy(
+	ctx context.Context,
+	db *sql.DB,
+	policyName string,
+) (string, error) {
+	return readShowCreate(ctx, db, "SHOW CREATE PLACEMENT POLICY "+EscapeIdentifier(policyName), "Create Policy")
+}
+
+// ReadCreateResourceGroup reads the CREATE RESOURCE GROUP statement from the
+// database.
+func ReadCreateResourceGroup(
+	ctx context.Context,
+	db *sql.DB,
+	groupName string,
+) (string, error) {
+	return readShowCreate(ctx, db, "SHOW CREATE RESOURCE GROUP "+EscapeIdentifier(groupName), "Create Resource Group")
+}
+
+func readShowCreate(
+	ctx context.Context,
+	db *sql.DB,
+	query string,
+	columnName string,
+) (string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to execute query: %s", query)
+	}
+	defer rows.Close()
+
+	create, allFound, err := ReadStrRowsByColumnName(rows, []string{columnName})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if allFound && len(create) > 0 {
+		return create[0][0], nil
+	}
+	return "", errors.Errorf("failed to find %s for query: %s", columnName, query)
+}
+
+// DatabaseExists checks if the database exists.
+func DatabaseExists(
+	ctx context.Context,
+	db *sql.DB,
+	dbName string,
+) (bool, error) {
+	query := "SELECT 1 FROM INFORMATION_SCHEMA.SCHEMATA WHERE SCHEMA_NAME = ?"
+	var one int
+	err := db.QueryRowContext(ctx, query, dbName).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Annotatef(err, "failed to check if database %s exists", dbName)
+	}
+	return true, nil
+}
+
+// ReadTiFlashReplicaCount reads the TiFlash replica count of the table. It
+// returns 0 if the table has no TiFlash replica.
+func ReadTiFlashReplicaCount(
+	ctx context.Context,
+	db *sql.DB,
+	dbName, tableName string,
+) (int, error) {
+	query := "SELECT REPLICA_COUNT FROM INFORMATION_SCHEMA.TIFLASH_REPLICA WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+	var count int
+	err := db.QueryRowContext(ctx, query, dbName, tableName).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Annotatef(err, "failed to read TiFlash replica of %s.%s", dbName, tableName)
+	}
+	return count, nil
+}
+
+// TableStatus is the status of a table read from INFORMATION_SCHEMA.TABLES.
+type TableStatus struct {
+	// Exists is false if the table is not found, like a local temporary table
+	// which is only visible to its session.
+	Exists bool
+	// Cached is true if the table is cached by ALTER TABLE ... CACHE.
+	Cached bool
+}
+
+// ReadTableStatus reads the TableStatus of the table, view or sequence.
+func ReadTableStatus(
+	ctx context.Context,
+	db *sql.DB,
+	dbName, tableName string,
+) (*TableStatus, error) {
+	query := "SELECT CREATE_OPTIONS FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+	var createOptions sql.NullString
+	err := db.QueryRowContext(ctx, query, dbName, tableName).Scan(&createOptions)
+	if err == sql.ErrNoRows {
+		return &TableStatus{}, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read status of %s.%s", dbName, tableName)
+	}
+	return &TableStatus{
+		Exists: true,
+		Cached: strings.Contains(createOptions.String, "cached=on"),
+	}, nil
+}
+
 type ClusterInfo struct {
 	TiDBCnt     int
 	TiDBVersion string
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"github.com/pingcap/errors"
+)
+
+// TLS modes, which are the same as the --ssl-mode of MySQL client except
+// PREFERRED.
+const (
+	// TLSModeDisabled doesn't use TLS.
+	TLSModeDisabled = "disabled"
+	// TLSModeRequired uses TLS without verifying the server.
+	TLSModeRequired = "required"
+	// TLSModeVerifyCA verifies the server certificate by the CA, but not the
+	// server name.
+	TLSModeVerifyCA = "verify-ca"
+	// TLSModeVerifyIdentity verifies the server certificate by the CA and the
+	// server name.
+	TLSModeVerifyIdentity = "verify-identity"
+)
+
+// NewTLSConfig creates a tls.Config by the TLS mode and the given files. It
+// returns nil if TLS is not used. Empty mode means TLSModeVerifyIdentity if any
+// of caPath, certPath and keyPath is set, otherwise TLSModeDisabled. The system
+// roots are used to verify the server if caPath is empty. certPath and keyPath
+// should be both empty or both non-empty. If serverName is empty, the caller
+// should fill it by the host to be verified.
+func NewTLSConfig(mode, caPath, certPath, keyPath, serverName string) (*tls.Config, error) {
+	hasFiles := caPath != "" || certPath != "" || keyPath != ""
+	if mode == "" {
+		mode = TLSModeDisabled
+		if hasFiles {
+			mode = TLSModeVerifyIdentity
+		}
+	}
+	switch mode {
+	case TLSModeDisabled:
+		if hasFiles {
+			return nil, errors.Errorf("TLS files are set but TLS mode is %q", mode)
+		}
+		return nil, nil
+	case TLSModeRequired, TLSModeVerifyCA, TLSModeVerifyIdentity:
+	default:
+		return nil, errors.Errorf(
+			"TLS mode should be one of %q, %q, %q and %q, got %q",
+			TLSModeDisabled, TLSModeRequired, TLSModeVerifyCA, TLSModeVerifyIdentity, mode,
+		)
+	}
+
+	ret := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// the verification of TLSModeVerifyCA is done by VerifyConnection
+		InsecureSkipVerify: mode != TLSModeVerifyIdentity,
+	}
+	if caPath != "" {
+		ca, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, errors.Annotatef(err, "read CA file %s", caPath)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("failed to append CA certificates from %s, is it in PEM format?", caPath)
+		}
+		ret.RootCAs = pool
+	}
+
+	if (certPath == "") != (keyPath == "") {
+		return nil, errors.Errorf(
+			"client certificate and key should be both set or both empty, got certificate %q and key %q",
+			certPath, keyPath,
+		)
+	}
+	if certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, errors.Annotatef(err, "load client certificate %s and key %s", certPath, keyPath)
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+	if mode == TLSModeVerifyCA {
+		ret.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyCA(cs, ret.RootCAs)
+		}
+	}
+	return ret, nil
+}
+
+// verifyCA verifies the certificate chain of the server by roots without
+// checking the server name. nil roots means the system roots.
+func verifyCA(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server doesn't provide certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return errors.Trace(err)
+}
+
+// NewHTTPClient creates a http.Client which uses tlsConfig for HTTPS
+// connections. If tlsConfig is nil, it returns http.DefaultClient.
+func NewHTTPClient(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}
+}
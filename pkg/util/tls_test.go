@@ -0,0 +1,91 @@
+package util
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig, err := NewTLSConfig("", "", "", "", "")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+	require.Equal(t, http.DefaultClient, NewHTTPClient(tlsConfig))
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caPath, caPEM, 0666))
+
+	tlsConfig, err = NewTLSConfig("", caPath, "", "", "")
+	require.NoError(t, err)
+	resp, err := NewHTTPClient(tlsConfig).Get(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+
+	// the certificate of httptest is valid for example.com but not for other names
+	tlsConfig, err = NewTLSConfig("", caPath, "", "", "example.com")
+	require.NoError(t, err)
+	resp, err = NewHTTPClient(tlsConfig).Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	tlsConfig, err = NewTLSConfig("", caPath, "", "", "pingcap.com")
+	require.NoError(t, err)
+	_, err = NewHTTPClient(tlsConfig).Get(server.URL)
+	require.ErrorContains(t, err, "certificate is valid for")
+
+	// server is not trusted without CA
+	_, err = NewHTTPClient(&tls.Config{}).Get(server.URL)
+	require.ErrorContains(t, err, "certificate signed by unknown authority")
+
+	// required mode doesn't verify the server
+	tlsConfig, err = NewTLSConfig(TLSModeRequired, "", "", "", "")
+	require.NoError(t, err)
+	resp, err = NewHTTPClient(tlsConfig).Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	// verify-ca mode verifies the CA but not the server name
+	tlsConfig, err = NewTLSConfig(TLSModeVerifyCA, caPath, "", "", "pingcap.com")
+	require.NoError(t, err)
+	resp, err = NewHTTPClient(tlsConfig).Get(server.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	// the system roots are used without CA
+	for _, mode := range []string{TLSModeVerifyCA, TLSModeVerifyIdentity} {
+		tlsConfig, err = NewTLSConfig(mode, "", "", "", "")
+		require.NoError(t, err)
+		require.NotNil(t, tlsConfig)
+		_, err = NewHTTPClient(tlsConfig).Get(server.URL)
+		require.ErrorContains(t, err, "certificate signed by unknown authority")
+	}
+	tlsConfig, err = NewTLSConfig(TLSModeDisabled, "", "", "", "")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+	_, err = NewTLSConfig(TLSModeDisabled, caPath, "", "", "")
+	require.ErrorContains(t, err, "TLS files are set but TLS mode is \"disabled\"")
+	_, err = NewTLSConfig("preferred", "", "", "", "")
+	require.ErrorContains(t, err, "TLS mode should be one of")
+
+	_, err = NewTLSConfig("", filepath.Join(dir, "not-exist.pem"), "", "", "")
+	require.ErrorContains(t, err, "read CA file")
+	badCAPath := filepath.Join(dir, "bad.pem")
+	require.NoError(t, os.WriteFile(badCAPath, []byte("not a PEM"), 0666))
+	_, err = NewTLSConfig("", badCAPath, "", "", "")
+	require.ErrorContains(t, err, "failed to append CA certificates")
+	_, err = NewTLSConfig("", caPath, caPath, "", "")
+	require.ErrorContains(t, err, "client certificate and key should be both set or both empty")
+	_, err = NewTLSConfig("", "", caPath, badCAPath, "")
+	require.ErrorContains(t, err, "load client certificate")
+}